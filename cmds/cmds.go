@@ -21,6 +21,7 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/illusionman1212/gorc/irc"
+	"github.com/illusionman1212/gorc/logging"
 )
 
 type ConnectMsg struct{}
@@ -29,16 +30,56 @@ func Connect() tea.Msg {
 	return ConnectMsg{}
 }
 
-func Quit(client *irc.Client) tea.Cmd {
+// Quit leaves every network with an optional reason and exits.
+func Quit(session *irc.Session, reason string) tea.Cmd {
 	return func() tea.Msg {
-		if client.TCPConn != nil {
-			client.SendCommand("QUIT")
+		for _, client := range session.Networks {
+			if client.TCPConn == nil {
+				continue
+			}
+
+			if reason != "" {
+				client.SendCommand("QUIT", reason)
+			} else {
+				client.SendCommand("QUIT")
+			}
+		}
+
+		if session.LastSeen != nil {
+			if err := session.LastSeen.Save(); err != nil {
+				logging.Errorf("Failed to save last seen timestamps: %v", err)
+			}
 		}
 
 		return tea.Quit()
 	}
 }
 
+type ConnectNetworkMsg struct {
+	Profile irc.Profile
+}
+
+func ConnectNetwork(profile irc.Profile) tea.Cmd {
+	return func() tea.Msg {
+		return ConnectNetworkMsg{Profile: profile}
+	}
+}
+
+type DisconnectNetworkMsg struct {
+	// Name or host of the network, the active network is used if this is empty
+	Network string
+	Reason  string
+}
+
+func DisconnectNetwork(network string, reason string) tea.Cmd {
+	return func() tea.Msg {
+		return DisconnectNetworkMsg{
+			Network: network,
+			Reason:  reason,
+		}
+	}
+}
+
 type SendPrivMsgMsg struct {
 	Msg      string
 	Datetime time.Time
@@ -53,6 +94,54 @@ func SendPrivMsg(msg string) tea.Cmd {
 	}
 }
 
+type SendReplyMsg struct {
+	Msg    string
+	Parent *irc.Line
+}
+
+func SendReply(msg string, parent *irc.Line) tea.Cmd {
+	return func() tea.Msg {
+		return SendReplyMsg{
+			Msg:    msg,
+			Parent: parent,
+		}
+	}
+}
+
+type SendReactionMsg struct {
+	Emoji  string
+	Parent *irc.Line
+}
+
+func SendReaction(emoji string, parent *irc.Line) tea.Cmd {
+	return func() tea.Msg {
+		return SendReactionMsg{
+			Emoji:  emoji,
+			Parent: parent,
+		}
+	}
+}
+
+type TypingChangedMsg struct{}
+
+func TypingChanged() tea.Msg {
+	return TypingChangedMsg{}
+}
+
+// AskMsg asks the user a yes or no question, Answer is called with what they said
+// and can return a command to run after.
+type AskMsg struct {
+	Question string
+	Answer   func(yes bool) tea.Cmd
+}
+
+func Ask(question string, answer func(yes bool) tea.Cmd) tea.Msg {
+	return AskMsg{
+		Question: question,
+		Answer:   answer,
+	}
+}
+
 type ReceivedIRCMsgMsg struct{}
 
 func ReceivedIRCMsg() tea.Msg {
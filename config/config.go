@@ -0,0 +1,254 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/illusionman1212/gorc/irc"
+)
+
+type Identity struct {
+	Nick     string   `toml:"nick"`
+	AltNicks []string `toml:"alt-nicks"`
+	Username string   `toml:"username"`
+	Realname string   `toml:"realname"`
+
+	// Take the nick back once it's free if we had to use an alternate one
+	RegainNick bool `toml:"regain-nick"`
+}
+
+type SASL struct {
+	Mechanism string `toml:"mechanism"`
+	Username  string `toml:"username"`
+	Password  string `toml:"password"`
+}
+
+type Network struct {
+	Name string `toml:"name"`
+
+	// host:port, the port defaults to 6697 with TLS and 6667 without
+	Address  string `toml:"address"`
+	TLS      bool   `toml:"tls"`
+	Password string `toml:"password"`
+
+	// socks5://[user:pass@]host:port
+	Proxy string `toml:"proxy"`
+
+	// Connect to this network on startup instead of showing the login screen
+	Autoconnect bool `toml:"autoconnect"`
+
+	// Channels to join after connecting, a key can follow the channel name after a space
+	Autojoin []string `toml:"autojoin"`
+
+	// Slash commands to run after connecting
+	Commands []string `toml:"commands"`
+
+	// Nicks to tell us about when they come online or go offline
+	Friends []string `toml:"friends"`
+
+	SASL SASL `toml:"sasl"`
+
+	// Overrides the global identity for this network
+	Identity Identity `toml:"identity"`
+}
+
+type Settings struct {
+	// Maximum number of lines kept in memory for each buffer
+	Scrollback int `toml:"scrollback"`
+
+	// Number of messages fetched at a time on servers with chathistory, -1 disables fetching history
+	History int `toml:"history"`
+
+	// Don't let others know when we're typing
+	DisableTyping bool `toml:"disable-typing"`
+
+	// Don't show the colors people put in their messages
+	StripColors bool `toml:"strip-colors"`
+
+	// Ask before sending a paste of more lines than this, 0 uses the default and -1 never asks
+	ConfirmPaste int `toml:"confirm-paste"`
+
+	// Where files received over DCC are saved, defaults to ~/Downloads
+	DownloadDir string `toml:"download-dir"`
+
+	// The address others connect to for DCC if it isn't the one we connect to IRC with, e.g. behind a NAT
+	DCCAddress string `toml:"dcc-address"`
+
+	// Wait for every block sent over DCC to be acknowledged, for old clients that need it
+	DCCWaitAcks bool `toml:"dcc-wait-acks"`
+}
+
+type Config struct {
+	Identity Identity  `toml:"identity"`
+	Settings Settings  `toml:"settings"`
+	Networks []Network `toml:"network"`
+}
+
+// Path returns the default location of the config file,
+// $XDG_CONFIG_HOME/gorc/config.toml or ~/.config/gorc/config.toml.
+func Path() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "gorc", "config.toml"), nil
+}
+
+// Load reads and validates the config file at path.
+// A missing file isn't an error, an empty config is returned instead.
+func Load(path string) (Config, error) {
+	var cfg Config
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	return Parse(path, string(data))
+}
+
+// Parse decodes a config file's contents, name is only used in error messages.
+func Parse(name string, data string) (Config, error) {
+	var cfg Config
+
+	meta, err := toml.Decode(data, &cfg)
+	if err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return cfg, fmt.Errorf("%s:%d: %s", name, parseErr.Position.Line, parseErr.Message)
+		}
+
+		return cfg, fmt.Errorf("%s: %w", name, err)
+	}
+
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return cfg, fmt.Errorf("%s: unknown option %q", name, undecoded[0].String())
+	}
+
+	for i, network := range cfg.Networks {
+		if network.Address == "" {
+			return cfg, fmt.Errorf("%s: network #%d (%s) has no address", name, i+1, network.Name)
+		}
+
+		if mechanism := network.SASL.Mechanism; mechanism != "" && !strings.EqualFold(mechanism, "PLAIN") {
+			return cfg, fmt.Errorf("%s: network #%d (%s) has unsupported SASL mechanism %q", name, i+1, network.Name, mechanism)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Find returns the network with the given name or nil if there's none.
+func (c Config) Find(name string) *Network {
+	for i := range c.Networks {
+		if strings.EqualFold(c.Networks[i].Name, name) {
+			return &c.Networks[i]
+		}
+	}
+
+	return nil
+}
+
+// Autoconnect returns the networks that should be connected to on startup.
+func (c Config) Autoconnect() []Network {
+	var networks []Network
+	for _, network := range c.Networks {
+		if network.Autoconnect {
+			networks = append(networks, network)
+		}
+	}
+
+	return networks
+}
+
+func pick(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// Profile builds the connection profile of a network,
+// values missing from the network's identity are taken from the global one.
+func (c Config) Profile(network Network) irc.Profile {
+	host, port, err := net.SplitHostPort(network.Address)
+	if err != nil {
+		host = network.Address
+		port = "6667"
+		if network.TLS {
+			port = "6697"
+		}
+	}
+
+	altNicks := network.Identity.AltNicks
+	if len(altNicks) == 0 {
+		altNicks = c.Identity.AltNicks
+	}
+
+	profile := irc.Profile{
+		Name:     network.Name,
+		Host:     host,
+		Port:     port,
+		TLS:      network.TLS,
+		Password: network.Password,
+		Proxy:    network.Proxy,
+		Nickname: pick(network.Identity.Nick, c.Identity.Nick),
+		AltNicks: altNicks,
+		Username: pick(network.Identity.Username, c.Identity.Username),
+		Realname: pick(network.Identity.Realname, c.Identity.Realname),
+
+		RegainNick: network.Identity.RegainNick || c.Identity.RegainNick,
+		SASL: irc.SASL{
+			Mechanism: pick(network.SASL.Mechanism, "PLAIN"),
+			Username:  network.SASL.Username,
+			Password:  network.SASL.Password,
+		},
+		Commands: network.Commands,
+		Friends:  network.Friends,
+	}
+
+	for _, channel := range network.Autojoin {
+		fields := strings.Fields(channel)
+		if len(fields) == 0 {
+			continue
+		}
+
+		autojoin := irc.AutojoinChannel{Name: fields[0]}
+		if len(fields) > 1 {
+			autojoin.Key = fields[1]
+		}
+
+		profile.Autojoin = append(profile.Autojoin, autojoin)
+	}
+
+	return profile
+}
@@ -0,0 +1,124 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+[identity]
+nick = "bob"
+alt-nicks = ["bob_", "bob__"]
+realname = "Bob"
+
+[[network]]
+name = "libera"
+address = "irc.libera.chat:6697"
+tls = true
+autoconnect = true
+autojoin = ["#go", "#secret hunter2"]
+commands = ["/mode bob +i"]
+
+[network.sasl]
+username = "bob"
+password = "pass"
+
+[[network]]
+name = "oftc"
+address = "irc.oftc.net"
+
+[network.identity]
+nick = "robert"
+`
+
+func TestConfig(t *testing.T) {
+	t.Run("Test networks", func(t *testing.T) {
+		cfg, err := Parse("config.toml", testConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(cfg.Networks) != 2 || len(cfg.Autoconnect()) != 1 {
+			t.Fatal("Wrong number of networks")
+		}
+
+		libera := cfg.Profile(*cfg.Find("Libera"))
+		if libera.Host != "irc.libera.chat" || libera.Port != "6697" || !libera.TLS {
+			t.Fatal("Wrong address")
+		}
+
+		if libera.Nickname != "bob" || libera.Realname != "Bob" || len(libera.AltNicks) != 2 {
+			t.Fatal("Global identity wasn't used")
+		}
+
+		if !libera.SASLEnabled() {
+			t.Fatal("SASL should be enabled")
+		}
+
+		if len(libera.Autojoin) != 2 || libera.Autojoin[1].Name != "#secret" || libera.Autojoin[1].Key != "hunter2" {
+			t.Fatal("Wrong autojoin channels")
+		}
+
+		params := libera.JoinParams()
+		if len(params) != 2 || params[0] != "#secret,#go" || params[1] != "hunter2" {
+			t.Fatal("Wrong JOIN params:", params)
+		}
+
+		oftc := cfg.Profile(*cfg.Find("oftc"))
+		if oftc.Port != "6667" || oftc.Nickname != "robert" || oftc.Realname != "Bob" {
+			t.Fatal("Network identity wasn't merged")
+		}
+
+		if oftc.SASLEnabled() {
+			t.Fatal("SASL shouldn't be enabled without credentials")
+		}
+	})
+
+	t.Run("Test syntax error line", func(t *testing.T) {
+		_, err := Parse("config.toml", "[identity]\nnick = \"bob\"\nrealname = \n")
+		if err == nil || !strings.HasPrefix(err.Error(), "config.toml:3:") {
+			t.Fatal("Expected error on line 3, got:", err)
+		}
+	})
+
+	t.Run("Test unknown option", func(t *testing.T) {
+		_, err := Parse("config.toml", "[[network]]\nadress = \"irc.libera.chat\"\n")
+		if err == nil || !strings.Contains(err.Error(), "network.adress") {
+			t.Fatal("Expected unknown option error, got:", err)
+		}
+	})
+
+	t.Run("Test missing address", func(t *testing.T) {
+		_, err := Parse("config.toml", "[[network]]\nname = \"libera\"\n")
+		if err == nil {
+			t.Fatal("Expected missing address error")
+		}
+	})
+
+	t.Run("Test unsupported SASL mechanism", func(t *testing.T) {
+		_, err := Parse("config.toml", "[[network]]\naddress = \"irc.libera.chat\"\n[network.sasl]\nmechanism = \"EXTERNAL\"\n")
+		if err == nil || !strings.Contains(err.Error(), "EXTERNAL") {
+			t.Fatal("Expected unsupported mechanism error, got:", err)
+		}
+
+		if _, err := Parse("config.toml", "[[network]]\naddress = \"irc.libera.chat\"\n[network.sasl]\nmechanism = \"plain\"\n"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
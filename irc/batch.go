@@ -0,0 +1,185 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import "strings"
+
+// Batch is a group of messages the server wants handled together
+// (https://ircv3.net/specs/extensions/batch)
+type Batch struct {
+	Ref    string
+	Type   string
+	Params []string
+
+	// Tags of the BATCH message that opened this batch
+	Tags MessageTags
+
+	// The batch this one is nested in, nil for top level batches
+	Parent *Batch
+
+	// Messages and nested batches in the order they arrived,
+	// exactly one of Message or Batch is set on each item.
+	Items []BatchItem
+}
+
+type BatchItem struct {
+	Message *Message
+	Batch   *Batch
+}
+
+// Messages returns every message of the batch including those of nested batches, in order.
+func (b *Batch) Messages() []Message {
+	var messages []Message
+
+	for _, item := range b.Items {
+		if item.Batch != nil {
+			messages = append(messages, item.Batch.Messages()...)
+		} else {
+			messages = append(messages, *item.Message)
+		}
+	}
+
+	return messages
+}
+
+// Multiline joins the lines of a draft/multiline batch into one message with the batch's tags,
+// lines tagged draft/multiline-concat continue the previous line instead of starting a new one.
+// (https://ircv3.net/specs/extensions/multiline)
+func (b *Batch) Multiline() (Message, bool) {
+	messages := b.Messages()
+	if len(messages) == 0 || len(b.Params) < 1 {
+		return Message{}, false
+	}
+
+	var text strings.Builder
+	for i, msg := range messages {
+		if len(msg.Parameters) < 2 {
+			continue
+		}
+
+		if _, concat := msg.Tags["draft/multiline-concat"]; i > 0 && !concat {
+			text.WriteByte('\n')
+		}
+		text.WriteString(msg.Parameters[1])
+	}
+
+	combined := messages[0]
+	combined.Tags = make(MessageTags)
+	for key, value := range messages[0].Tags {
+		combined.Tags[key] = value
+	}
+	delete(combined.Tags, "batch")
+	delete(combined.Tags, "draft/multiline-concat")
+
+	// the msgid, label and time are on the batch
+	for key, value := range b.Tags {
+		combined.Tags[key] = value
+	}
+	if _, ok := b.Tags["time"]; ok {
+		combined.SetTimestamp()
+	}
+
+	combined.Parameters = []string{b.Params[0], text.String()}
+
+	return combined, true
+}
+
+// Combined returns every message of the batch like Messages,
+// except that nested multiline batches are joined into a single message.
+func (b *Batch) Combined() []Message {
+	var messages []Message
+
+	for _, item := range b.Items {
+		switch {
+		case item.Batch != nil && item.Batch.Type == "draft/multiline":
+			if msg, ok := item.Batch.Multiline(); ok {
+				messages = append(messages, msg)
+			}
+		case item.Batch != nil:
+			messages = append(messages, item.Batch.Combined()...)
+		default:
+			messages = append(messages, *item.Message)
+		}
+	}
+
+	return messages
+}
+
+// Batches keeps the batches that are still open.
+type Batches struct {
+	open map[string]*Batch
+}
+
+func NewBatches() *Batches {
+	return &Batches{
+		open: make(map[string]*Batch),
+	}
+}
+
+// Start opens the batch started by a "BATCH +ref type params..." message.
+// It's nested in its parent if the message itself has a batch tag.
+func (bs *Batches) Start(msg Message) *Batch {
+	batch := &Batch{
+		Ref:  msg.Parameters[0][1:],
+		Tags: msg.Tags,
+	}
+
+	if len(msg.Parameters) > 1 {
+		batch.Type = msg.Parameters[1]
+		batch.Params = msg.Parameters[2:]
+	}
+
+	if parent, ok := bs.open[msg.Tags["batch"]]; ok {
+		batch.Parent = parent
+		parent.Items = append(parent.Items, BatchItem{Batch: batch})
+	}
+
+	bs.open[batch.Ref] = batch
+
+	return batch
+}
+
+// End closes the batch with the given reference.
+// It returns the batch once it's complete and should be handled, that is
+// when it's a top level batch. Nested batches are handled with their parent.
+func (bs *Batches) End(ref string) *Batch {
+	batch, ok := bs.open[ref]
+	if !ok {
+		return nil
+	}
+
+	delete(bs.open, ref)
+
+	if batch.Parent != nil {
+		return nil
+	}
+
+	return batch
+}
+
+// Add holds a message until its batch is closed.
+// It returns false if the message doesn't belong to an open batch.
+func (bs *Batches) Add(msg Message) bool {
+	batch, ok := bs.open[msg.Tags["batch"]]
+	if !ok {
+		return false
+	}
+
+	batch.Items = append(batch.Items, BatchItem{Message: &msg})
+
+	return true
+}
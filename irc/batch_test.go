@@ -0,0 +1,62 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import "testing"
+
+func TestBatches(t *testing.T) {
+	t.Run("Test nested batches", func(t *testing.T) {
+		bs := NewBatches()
+
+		outer := bs.Start(Message{Command: "BATCH", Parameters: []string{"+outer", "labeled-response"}, Tags: MessageTags{"label": "1"}})
+		bs.Add(Message{Command: "PRIVMSG", Parameters: []string{"#a", "one"}, Tags: MessageTags{"batch": "outer"}})
+
+		inner := bs.Start(Message{Command: "BATCH", Parameters: []string{"+inner", "chathistory", "#a"}, Tags: MessageTags{"batch": "outer"}})
+		bs.Add(Message{Command: "PRIVMSG", Parameters: []string{"#a", "two"}, Tags: MessageTags{"batch": "inner"}})
+
+		if inner.Parent != outer || inner.Type != "chathistory" || inner.Params[0] != "#a" {
+			t.Fatal("Nested batch wasn't parsed properly")
+		}
+
+		if bs.End("inner") != nil {
+			t.Fatal("A nested batch shouldn't be handled on its own")
+		}
+
+		bs.Add(Message{Command: "PRIVMSG", Parameters: []string{"#a", "three"}, Tags: MessageTags{"batch": "outer"}})
+
+		if bs.End("outer") != outer {
+			t.Fatal("The top level batch should be returned once it ends")
+		}
+
+		messages := outer.Messages()
+		if len(messages) != 3 || messages[0].Parameters[1] != "one" || messages[1].Parameters[1] != "two" || messages[2].Parameters[1] != "three" {
+			t.Fatal("Wrong messages:", messages)
+		}
+	})
+
+	t.Run("Test messages outside batches", func(t *testing.T) {
+		bs := NewBatches()
+
+		if bs.Add(Message{Command: "PRIVMSG", Tags: MessageTags{"batch": "unknown"}}) {
+			t.Fatal("A message with an unknown batch shouldn't be held")
+		}
+
+		if bs.Add(Message{Command: "PRIVMSG"}) {
+			t.Fatal("A message without a batch shouldn't be held")
+		}
+	})
+}
@@ -0,0 +1,133 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+var tagUnescaper = strings.NewReplacer(
+	"\\\\", "\\",
+	"\\:", ";",
+	"\\s", " ",
+	"\\r", "\r",
+	"\\n", "\n",
+)
+
+// ParseBouncerAttributes parses the attributes of a BOUNCER NETWORK message, e.g. name=Libera;state=connected.
+// It returns nil for "*" which means the network was removed.
+// (https://codeberg.org/emersion/soju/src/branch/master/doc/ext/bouncer-networks.md)
+func ParseBouncerAttributes(raw string) map[string]string {
+	if raw == "*" {
+		return nil
+	}
+
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(raw, ";") {
+		if attr == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(attr, "=")
+		attrs[key] = tagUnescaper.Replace(value)
+	}
+
+	return attrs
+}
+
+// IsBouncerControl reports whether this is a soju connection that isn't bound to an upstream network,
+// the upstream networks get their own connections.
+func (c *Client) IsBouncerControl() bool {
+	_, ok := c.EnabledCapabilities["soju.im/bouncer-networks"]
+	return ok && c.Profile.BouncerNetID == ""
+}
+
+// AddBouncerNetwork records an upstream network and returns the profile to bind a new connection to it with.
+// It returns false if we already know about the network.
+func (c *Client) AddBouncerNetwork(id string, attrs map[string]string) (Profile, bool) {
+	if c.BouncerNetworks == nil {
+		c.BouncerNetworks = make(map[string]map[string]string)
+	}
+
+	_, known := c.BouncerNetworks[id]
+	c.BouncerNetworks[id] = attrs
+
+	if known {
+		return Profile{}, false
+	}
+
+	// the bouncer joins channels on its own
+	profile := c.Profile
+	profile.Name = attrs["name"]
+	profile.BouncerNetID = id
+	profile.Autojoin = nil
+	profile.Commands = nil
+
+	return profile, true
+}
+
+// PlaybackSupported reports whether we're connected to ZNC with the playback module.
+// (https://wiki.znc.in/Playback)
+func (c *Client) PlaybackSupported() bool {
+	_, ok := c.EnabledCapabilities["znc.in/playback"]
+	return ok
+}
+
+// RequestPlayback asks ZNC for everything in its buffers since the last message we saw on this network.
+func (c *Client) RequestPlayback() {
+	since := c.LastSeen()
+
+	from := "0"
+	if !since.IsZero() {
+		from = fmt.Sprintf("%.3f", float64(since.UnixMilli())/1000)
+	}
+
+	c.SendCommand(commands.PRIVMSG, "*playback", "PLAY * "+from)
+}
+
+// LastSeenKey identifies this network in the last seen timestamps,
+// the username is part of it because ZNC picks the network with it.
+func (c *Client) LastSeenKey() string {
+	key := c.Profile.Username + "@" + c.Host + ":" + c.Port
+	if c.Profile.BouncerNetID != "" {
+		key += "/" + c.Profile.BouncerNetID
+	}
+
+	return key
+}
+
+// LastSeen returns the time of the last message we saw on this network in this or a previous session.
+func (c *Client) LastSeen() time.Time {
+	if c.Session == nil || c.Session.LastSeen == nil {
+		return time.Time{}
+	}
+
+	return c.Session.LastSeen.Get(c.LastSeenKey())
+}
+
+// SeeMessage remembers the time of a message so playback doesn't send it again next time.
+func (c *Client) SeeMessage(datetime time.Time) {
+	if c.Session == nil || c.Session.LastSeen == nil {
+		return
+	}
+
+	c.Session.LastSeen.Set(c.LastSeenKey(), datetime)
+}
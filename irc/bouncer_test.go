@@ -0,0 +1,94 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBouncer(t *testing.T) {
+	t.Run("Test network attributes", func(t *testing.T) {
+		attrs := ParseBouncerAttributes(`name=Libera\sChat;host=irc.libera.chat;state=connected`)
+		if attrs["name"] != "Libera Chat" || attrs["host"] != "irc.libera.chat" || attrs["state"] != "connected" {
+			t.Fatal("Wrong attributes:", attrs)
+		}
+
+		if ParseBouncerAttributes("*") != nil {
+			t.Fatal("Removed networks should have no attributes")
+		}
+	})
+
+	t.Run("Test binding networks once", func(t *testing.T) {
+		client := &Client{Profile: Profile{
+			Host:     "soju.example",
+			Nickname: "bob",
+			Autojoin: []AutojoinChannel{{Name: "#gorc"}},
+		}}
+
+		profile, isNew := client.AddBouncerNetwork("42", map[string]string{"name": "libera"})
+		if !isNew {
+			t.Fatal("First time we see a network it should be bound")
+		}
+
+		if profile.BouncerNetID != "42" || profile.Name != "libera" || profile.Host != "soju.example" || profile.Autojoin != nil {
+			t.Fatal("Wrong profile:", profile)
+		}
+
+		if _, isNew := client.AddBouncerNetwork("42", map[string]string{"name": "libera", "state": "connected"}); isNew {
+			t.Fatal("A network we know about shouldn't be bound again")
+		}
+	})
+
+	t.Run("Test last seen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "gorc", "last-seen.json")
+
+		ls, err := LoadLastSeen(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC)
+		ls.Set("bob@znc:6697", seen)
+		ls.Set("bob@znc:6697", seen.Add(-time.Hour))
+
+		if err := ls.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		ls, err = LoadLastSeen(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ls.Get("bob@znc:6697").Equal(seen) {
+			t.Fatal("Wrong last seen time:", ls.Get("bob@znc:6697"))
+		}
+	})
+
+	t.Run("Test playback request", func(t *testing.T) {
+		client, server := newTestClient(t)
+		client.Session = &Session{LastSeen: &LastSeen{times: map[string]time.Time{}}}
+		client.SeeMessage(time.UnixMilli(1646128800250))
+
+		go client.RequestPlayback()
+		if request, _ := server.ReadString('\n'); request != "PRIVMSG *playback :PLAY * 1646128800.250\r\n" {
+			t.Fatalf("Wrong request: %q", request)
+		}
+	})
+}
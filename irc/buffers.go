@@ -0,0 +1,175 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+// Buffers keeps the open buffers of a client in the order they're shown in the tab bar
+// and indexes them by their casefolded name so lookups don't have to walk every buffer.
+type Buffers struct {
+	order []*Channel
+	index map[string]*Channel
+
+	casemapping string
+}
+
+func NewBuffers() *Buffers {
+	return &Buffers{
+		index:       make(map[string]*Channel),
+		casemapping: CasemappingRFC1459,
+	}
+}
+
+func (b *Buffers) fold(name string) string {
+	return Casefold(b.casemapping, name)
+}
+
+// SetCasemapping changes how names are compared and re-indexes every buffer.
+func (b *Buffers) SetCasemapping(casemapping string) {
+	b.casemapping = casemapping
+	b.index = make(map[string]*Channel, len(b.order))
+
+	for _, channel := range b.order {
+		b.index[b.fold(channel.Name)] = channel
+	}
+}
+
+func (b *Buffers) Len() int {
+	return len(b.order)
+}
+
+// At returns the buffer at position i or nil if it's out of range.
+func (b *Buffers) At(i int) *Channel {
+	if i < 0 || i >= len(b.order) {
+		return nil
+	}
+
+	return b.order[i]
+}
+
+// All returns the buffers in tab bar order.
+func (b *Buffers) All() []*Channel {
+	all := make([]*Channel, len(b.order))
+	copy(all, b.order)
+
+	return all
+}
+
+// Get returns the buffer with the given name or nil if there's none.
+func (b *Buffers) Get(name string) *Channel {
+	return b.index[b.fold(name)]
+}
+
+// Index returns the position of the buffer with the given name or -1 if there's none.
+func (b *Buffers) Index(name string) int {
+	channel := b.Get(name)
+	if channel == nil {
+		return -1
+	}
+
+	for i, c := range b.order {
+		if c == channel {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Add appends a buffer to the end.
+// If a buffer with the same name exists it's returned instead and nothing is added.
+func (b *Buffers) Add(channel *Channel) *Channel {
+	return b.Insert(len(b.order), channel)
+}
+
+// Insert adds a buffer at position i, clamped to the bounds of the list.
+// If a buffer with the same name exists it's returned instead and nothing is added.
+func (b *Buffers) Insert(i int, channel *Channel) *Channel {
+	key := b.fold(channel.Name)
+	if existing, ok := b.index[key]; ok {
+		return existing
+	}
+
+	i = max(0, min(i, len(b.order)))
+
+	b.order = append(b.order, nil)
+	copy(b.order[i+1:], b.order[i:])
+	b.order[i] = channel
+	b.index[key] = channel
+
+	return channel
+}
+
+// Remove deletes the buffer with the given name and returns it, or nil if there's none.
+func (b *Buffers) Remove(name string) *Channel {
+	i := b.Index(name)
+	if i == -1 {
+		return nil
+	}
+
+	channel := b.order[i]
+	b.order = append(b.order[:i], b.order[i+1:]...)
+	delete(b.index, b.fold(name))
+
+	return channel
+}
+
+// Move moves the buffer with the given name to position i.
+func (b *Buffers) Move(name string, i int) bool {
+	channel := b.Remove(name)
+	if channel == nil {
+		return false
+	}
+
+	b.Insert(i, channel)
+	return true
+}
+
+// Rename changes the name of a buffer while keeping its position.
+func (b *Buffers) Rename(oldName string, newName string) *Channel {
+	channel := b.Get(oldName)
+	if channel == nil {
+		return nil
+	}
+
+	delete(b.index, b.fold(oldName))
+	channel.Name = newName
+	b.index[b.fold(newName)] = channel
+
+	return channel
+}
+
+// Next returns the buffer after the given one, wrapping around at the end.
+func (b *Buffers) Next(channel *Channel) *Channel {
+	return b.offset(channel, 1)
+}
+
+// Prev returns the buffer before the given one, wrapping around at the start.
+func (b *Buffers) Prev(channel *Channel) *Channel {
+	return b.offset(channel, -1)
+}
+
+func (b *Buffers) offset(channel *Channel, by int) *Channel {
+	if len(b.order) == 0 {
+		return nil
+	}
+
+	i := b.Index(channel.Name)
+	if i == -1 {
+		return b.order[0]
+	}
+
+	return b.order[((i+by)%len(b.order)+len(b.order))%len(b.order)]
+}
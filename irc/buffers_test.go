@@ -0,0 +1,132 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import "testing"
+
+func names(b *Buffers) []string {
+	names := make([]string, 0, b.Len())
+	for _, channel := range b.All() {
+		names = append(names, channel.Name)
+	}
+
+	return names
+}
+
+func sameNames(a []string, b ...string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestBuffers(t *testing.T) {
+	t.Run("Test casefolded lookup", func(t *testing.T) {
+		b := NewBuffers()
+		channel := b.Add(NewChannel("#Go[dev]"))
+
+		if b.Get("#go{DEV}") != channel {
+			t.Fatal("rfc1459 lookup failed")
+		}
+
+		if b.Get("#go") != nil {
+			t.Fatal("Found a buffer that doesn't exist")
+		}
+
+		b.SetCasemapping(CasemappingASCII)
+
+		if b.Get("#go{dev}") != nil {
+			t.Fatal("ascii casemapping shouldn't fold brackets")
+		}
+
+		if b.Get("#GO[DEV]") != channel {
+			t.Fatal("ascii lookup failed")
+		}
+	})
+
+	t.Run("Test duplicate add", func(t *testing.T) {
+		b := NewBuffers()
+		first := b.Add(NewChannel("#gorc"))
+		second := b.Add(NewChannel("#GORC"))
+
+		if first != second || b.Len() != 1 {
+			t.Fatal("Duplicate buffer was added")
+		}
+	})
+
+	t.Run("Test insert, remove and move", func(t *testing.T) {
+		b := NewBuffers()
+		b.Add(NewChannel("server"))
+		b.Add(NewChannel("#a"))
+		b.Add(NewChannel("#c"))
+		b.Insert(2, NewChannel("#b"))
+
+		if !sameNames(names(b), "server", "#a", "#b", "#c") {
+			t.Fatal("Wrong order after insert:", names(b))
+		}
+
+		if b.Remove("#A") == nil || b.Get("#a") != nil {
+			t.Fatal("Failed to remove buffer")
+		}
+
+		if !sameNames(names(b), "server", "#b", "#c") {
+			t.Fatal("Wrong order after remove:", names(b))
+		}
+
+		if !b.Move("#c", 1) || !sameNames(names(b), "server", "#c", "#b") {
+			t.Fatal("Wrong order after move:", names(b))
+		}
+
+		if b.Index("#b") != 2 {
+			t.Fatal("Wrong index after move")
+		}
+
+		if b.Move("#nope", 0) {
+			t.Fatal("Moved a buffer that doesn't exist")
+		}
+	})
+
+	t.Run("Test rename", func(t *testing.T) {
+		b := NewBuffers()
+		b.Add(NewChannel("server"))
+		query := b.Add(NewChannel("alice"))
+		b.Add(NewChannel("#c"))
+
+		b.Rename("Alice", "bob")
+
+		if b.Get("alice") != nil || b.Get("bob") != query || b.Index("bob") != 1 {
+			t.Fatal("Failed to rename buffer")
+		}
+	})
+
+	t.Run("Test next and prev", func(t *testing.T) {
+		b := NewBuffers()
+		server := b.Add(NewChannel("server"))
+		a := b.Add(NewChannel("#a"))
+
+		if b.Next(a) != server || b.Prev(server) != a || b.Next(server) != a {
+			t.Fatal("Next and prev don't wrap around")
+		}
+	})
+}
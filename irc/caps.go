@@ -0,0 +1,176 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// Longest capability list we put in a single REQ, leaving room for "CAP REQ :" and CRLF
+// (https://ircv3.net/specs/extensions/capability-negotiation.html#the-cap-req-subcommand)
+const maxCapReqLength = 500
+
+// CapNegotiation keeps track of capability negotiation
+// (https://ircv3.net/specs/extensions/capability-negotiation.html)
+type CapNegotiation struct {
+	// Capabilities the server advertises and their values
+	Available Capabilities
+
+	// Whether registration is held off until we send CAP END
+	Negotiating bool
+
+	// Number of REQs we're still waiting an ACK or NAK for
+	Pending int
+
+	// LS or LIST pages received so far, the last page has no "*" marker
+	pages []string
+}
+
+func NewCapNegotiation() CapNegotiation {
+	return CapNegotiation{
+		Available: make(Capabilities),
+	}
+}
+
+// ParseCaps parses a space separated list of capabilities with optional values.
+func ParseCaps(list string) Capabilities {
+	caps := make(Capabilities)
+
+	for _, capability := range strings.Fields(list) {
+		key, value, _ := strings.Cut(capability, "=")
+		caps[key] = value
+	}
+
+	return caps
+}
+
+// CapList returns the parameters of a CAP reply after the subcommand
+// and whether more pages of the same reply follow.
+func CapList(msg Message) (string, bool) {
+	params := msg.Parameters[2:]
+	if len(params) > 1 && params[0] == "*" {
+		return params[1], true
+	}
+
+	if len(params) == 0 {
+		return "", false
+	}
+
+	return params[0], false
+}
+
+// AddPage collects a page of a multiline LS or LIST reply.
+// Once the last page arrives the whole list is returned and complete is true.
+func (n *CapNegotiation) AddPage(list string, more bool) (all string, complete bool) {
+	n.pages = append(n.pages, list)
+	if more {
+		return "", false
+	}
+
+	all = strings.Join(n.pages, " ")
+	n.pages = nil
+
+	return all, true
+}
+
+// ShouldEnd reports whether registration can go on,
+// that's once every REQ was answered and SASL is done.
+func (n *CapNegotiation) ShouldEnd(awaitingSASL bool) bool {
+	return n.Negotiating && n.Pending == 0 && !awaitingSASL
+}
+
+// WantedCaps returns the capabilities from caps that we support and haven't enabled yet, sorted by name.
+// SASL is only requested during registration, if the profile has credentials and the server offers our mechanism.
+func (c *Client) WantedCaps(caps Capabilities) []string {
+	var wanted []string
+
+	for key := range caps {
+		if !commands.Capabilities[key] {
+			continue
+		}
+
+		if _, ok := c.EnabledCapabilities[key]; ok {
+			continue
+		}
+
+		if key == "sasl" && (c.Registered || !c.Profile.SASLEnabled() || !c.SASLOffered(caps)) {
+			continue
+		}
+
+		wanted = append(wanted, key)
+	}
+
+	sort.Strings(wanted)
+
+	return wanted
+}
+
+// SASLOffered reports whether the mechanisms the server lists for sasl include ours,
+// a server that doesn't list any is assumed to accept it.
+func (c *Client) SASLOffered(caps Capabilities) bool {
+	mechanisms := caps["sasl"]
+	if mechanisms == "" {
+		return true
+	}
+
+	for _, mechanism := range strings.Split(mechanisms, ",") {
+		if strings.EqualFold(mechanism, c.Profile.SASL.Mechanism) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BatchCapReqs splits capabilities into lists that each fit in one REQ.
+func BatchCapReqs(caps []string) []string {
+	var batches []string
+	batch := ""
+
+	for _, capability := range caps {
+		if batch != "" && len(batch)+1+len(capability) > maxCapReqLength {
+			batches = append(batches, batch)
+			batch = ""
+		}
+
+		if batch != "" {
+			batch += " "
+		}
+		batch += capability
+	}
+
+	if batch != "" {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// RequestCaps sends REQs for the given capabilities and returns how many were sent.
+func (c *Client) RequestCaps(caps []string) int {
+	batches := BatchCapReqs(caps)
+	for _, batch := range batches {
+		c.SendCommand(commands.CAP, "REQ", batch)
+	}
+
+	c.Caps.Pending += len(batches)
+
+	return len(batches)
+}
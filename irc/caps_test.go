@@ -0,0 +1,117 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCaps(t *testing.T) {
+	t.Run("Test multiline LS", func(t *testing.T) {
+		n := NewCapNegotiation()
+
+		first := Message{Command: "CAP", Parameters: []string{"*", "LS", "*", "sasl=PLAIN server-time"}}
+		last := Message{Command: "CAP", Parameters: []string{"*", "LS", "cap-notify"}}
+
+		list, more := CapList(first)
+		if _, complete := n.AddPage(list, more); complete {
+			t.Fatal("The first page shouldn't complete the reply")
+		}
+
+		list, more = CapList(last)
+		all, complete := n.AddPage(list, more)
+		if !complete {
+			t.Fatal("The last page should complete the reply")
+		}
+
+		caps := ParseCaps(all)
+		if len(caps) != 3 || caps["sasl"] != "PLAIN" {
+			t.Fatal("Wrong capabilities:", caps)
+		}
+	})
+
+	t.Run("Test wanted caps", func(t *testing.T) {
+		client := &Client{EnabledCapabilities: Capabilities{"cap-notify": ""}}
+		available := ParseCaps("sasl server-time cap-notify some-unknown-cap")
+
+		wanted := client.WantedCaps(available)
+		if strings.Join(wanted, " ") != "server-time" {
+			t.Fatal("Wrong wanted caps without credentials:", wanted)
+		}
+
+		client.Profile.SASL = SASL{Mechanism: "PLAIN", Username: "bob"}
+		wanted = client.WantedCaps(available)
+		if strings.Join(wanted, " ") != "sasl server-time" {
+			t.Fatal("Wrong wanted caps with credentials:", wanted)
+		}
+
+		available["sasl"] = "EXTERNAL,SCRAM-SHA-256"
+		wanted = client.WantedCaps(available)
+		if strings.Join(wanted, " ") != "server-time" {
+			t.Fatal("Requested sasl without PLAIN being offered:", wanted)
+		}
+
+		available["sasl"] = "EXTERNAL,PLAIN"
+		if !client.SASLOffered(available) {
+			t.Fatal("PLAIN is offered")
+		}
+	})
+
+	t.Run("Test REQ batches", func(t *testing.T) {
+		var caps []string
+		for i := 0; i < 100; i++ {
+			caps = append(caps, "some-long-capability")
+		}
+
+		batches := BatchCapReqs(caps)
+		if len(batches) < 2 {
+			t.Fatal("Expected several batches, got", len(batches))
+		}
+
+		total := 0
+		for _, batch := range batches {
+			if len(batch) > maxCapReqLength {
+				t.Fatal("Batch is too long:", len(batch))
+			}
+			total += len(strings.Fields(batch))
+		}
+
+		if total != len(caps) {
+			t.Fatal("Lost capabilities while batching:", total)
+		}
+	})
+
+	t.Run("Test end of negotiation", func(t *testing.T) {
+		n := NewCapNegotiation()
+		n.Negotiating = true
+		n.Pending = 1
+
+		if n.ShouldEnd(false) {
+			t.Fatal("Shouldn't end while a REQ is pending")
+		}
+
+		n.Pending = 0
+		if n.ShouldEnd(true) {
+			t.Fatal("Shouldn't end while SASL is in progress")
+		}
+
+		if !n.ShouldEnd(false) {
+			t.Fatal("Should end once everything is answered")
+		}
+	})
+}
@@ -0,0 +1,56 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import "strings"
+
+/* --- CASEMAPPING values --- */
+const (
+	CasemappingASCII         = "ascii"
+	CasemappingRFC1459       = "rfc1459"
+	CasemappingStrictRFC1459 = "strict-rfc1459"
+)
+
+// Casefold lowercases a nickname or channel name according to the server's CASEMAPPING.
+// rfc1459 is used for unknown mappings since it's the default the spec tells us to assume.
+// (https://modern.ircdocs.horse/#casemapping-parameter)
+func Casefold(casemapping string, name string) string {
+	switch casemapping {
+	case CasemappingASCII:
+		return strings.ToLower(name)
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(name))
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+
+		switch {
+		case c >= 'A' && c <= 'Z':
+			c += 'a' - 'A'
+		case c == '[' || c == ']' || c == '\\':
+			c += '{' - '['
+		case c == '~' && casemapping != CasemappingStrictRFC1459:
+			c = '^'
+		}
+
+		sb.WriteByte(c)
+	}
+
+	return sb.String()
+}
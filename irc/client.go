@@ -18,20 +18,24 @@ package irc
 
 import (
 	"crypto/tls"
-	"fmt"
+	"errors"
 	"log"
 	"net"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/illusionman1212/gorc/irc/commands"
+	"github.com/illusionman1212/gorc/logging"
 	"github.com/illusionman1212/gorc/ui"
 )
 
 type Message struct {
 	DateTime   time.Time
+	Playback   bool        // whether a bouncer played it back from before we connected
 	Tags       MessageTags // starts with @ | Optional
 	Source     string      // starts with : | Optional
 	Command    string      // can either be a string or a numeric value | Required
@@ -43,12 +47,9 @@ type MessageTags map[string]string
 type User struct {
 	// User prefix in channel
 	Prefix string
-}
 
-type Node[T any] struct {
-	Next  *Node[T]
-	Prev  *Node[T]
-	Value T
+	// When they last said something in the channel
+	LastSpoke time.Time
 }
 
 type Channel struct {
@@ -59,16 +60,30 @@ type Channel struct {
 	Topic string
 
 	// Channel messages history
-	History string
-
-	// Date of the last message we received in this channel
-	LastUpdatedDate time.Time
+	History *Scrollback
 
 	// Users in this channel
 	// The map key is the user's nickname
 	// and the user struct holds data about that user
 	// such as, prefixes in this channel and etc...
 	Users map[string]User
+
+	// Whether we're waiting for chathistory of this buffer
+	HistoryLoading bool
+
+	// Whether the server has no history older than what we have
+	HistoryExhausted bool
+
+	// Time of the last message we read here or on another client
+	ReadMarker time.Time
+
+	// Who's typing here and what we last told others about our own typing
+	Typing     *Typing
+	ourTyping  string
+	typingSent time.Time
+
+	// The DCC chat of a direct buffer, nil for buffers on the server
+	Direct *DirectChat
 }
 
 type Client struct {
@@ -84,14 +99,30 @@ type Client struct {
 	// Nickname currently in use by the user
 	Nickname string
 
-	// The root of the doubly linked list of channel
-	RootChannel *Node[Channel]
+	// Open buffers in the order they appear in the tab bar
+	Buffers *Buffers
+
+	// Everyone we share a channel with
+	Users *Users
+
+	// WHO queries waiting to be sent
+	who whoQueue
+
+	// Nicks we want to know the presence of and how to stop polling for them
+	friends friendList
+	ison    isonQueries
+
+	// The server's own buffer, this is always the first buffer
+	RootChannel *Channel
 
 	// Active channel
-	ActiveChannel *Node[Channel]
+	ActiveChannel *Channel
+
+	// The profile this client connected with
+	Profile Profile
 
-	// The channel to join immediately after registration completes.
-	InitialChannel string
+	// The session this client belongs to
+	Session *Session
 
 	// Reference to the bubbletea program
 	Tea *tea.Program
@@ -105,8 +136,78 @@ type Client struct {
 	// The acknowledged capabilities
 	EnabledCapabilities Capabilities
 
+	// State of capability negotiation with the server
+	Caps CapNegotiation
+
+	// Batches that are still being received
+	Batches *Batches
+
+	// Labeled commands waiting for their replies
+	Requests *Requests
+
+	// Our messages waiting for the server to echo them back
+	echoes echoes
+
+	// Held while split messages are trickled out so they don't interleave
+	floodMu sync.Mutex
+
+	// Counter for the references of the multiline batches we open
+	batchRef int
+
+	// How many CTCP replies we sent to who recently
+	ctcpLimiter ctcpLimiter
+
+	// Files sent and received over DCC
+	Transfers *Transfers
+
+	// Chats over DCC, they bypass the server
+	chats directChats
+
+	// Called when something changes outside of the IRC connection, e.g. a DCC transfer or chat,
+	// so the UI can redraw
+	Notify func()
+
 	// The features currently enabled for this client
 	EnabledFeatures Features
+
+	// Whether we requested SASL and registration is waiting for authentication to finish
+	AwaitingSASL bool
+
+	// Whether the server accepted our registration with RPL_WELCOME
+	Registered bool
+
+	// Whether the registration burst ended with the MOTD
+	Ready bool
+
+	// Upstream networks of a soju bouncer and their attributes, by network id
+	BouncerNetworks map[string]map[string]string
+
+	// Number of nicks the server refused during registration
+	nickAttempts int
+
+	// Whether we're waiting for our primary nick to become free and how to stop polling for it
+	regaining  bool
+	stopRegain chan struct{}
+
+	// Maximum number of lines kept in memory for each buffer.
+	// DefaultMaxLines is used if this is 0.
+	ScrollbackLimit int
+
+	// Number of messages fetched from chathistory at a time.
+	// DefaultHistoryLimit is used if this is 0 and history isn't fetched if it's negative.
+	HistoryLimit int
+
+	// Don't let others know when we're typing
+	DisableTyping bool
+
+	// Where files received over DCC are saved, DefaultDownloadDir() is used if this is empty
+	DownloadDir string
+
+	// The address offered for DCC, our address on the IRC connection is used if this is empty
+	DCCAddress string
+
+	// Wait for every block sent over DCC to be acknowledged before sending the next one
+	DCCWaitAcks bool
 }
 
 type Capabilities map[string]string
@@ -126,142 +227,208 @@ var serverMsgStyle = lipgloss.NewStyle().Foreground(ui.ServerMsgColor)
 var errorMsgStyle = lipgloss.NewStyle().Foreground(ui.ErrorColor)
 var timestampStyle = serverMsgStyle
 var dateStyle = lipgloss.NewStyle().Foreground(ui.DateColor)
+var pendingMsgStyle = lipgloss.NewStyle().Foreground(ui.DisabledColorFocus)
+var quoteStyle = lipgloss.NewStyle().Foreground(ui.DisabledColorFocus).Italic(true)
+var reactionStyle = lipgloss.NewStyle().Foreground(ui.AccentColor)
 
 const CRLF = "\r\n"
 
 func (m *Message) SetTimestamp() {
 	if serverTime, ok := m.Tags["time"]; ok {
-		t, err := time.Parse("2006-01-02T15:04:05.000Z", serverTime)
-		if err != nil {
-			// TODO: properly handle error
-			log.Fatalln(err)
+		t, err := ParseTimestamp(serverTime)
+		if err == nil {
+			m.DateTime = t
+			return
 		}
-		m.DateTime = t.Local()
-	} else {
-		m.DateTime = time.Now()
-	}
-}
-
-func (c *Channel) AppendMsg(datetime time.Time, fullMsg string, opts MsgFmtOpts) {
-	prefixes := ""
-	style := ui.DefaultStyle
 
-	if opts.WithTimestamp {
-		str := fmt.Sprintf("[%02d:%02d]", datetime.Hour(), datetime.Minute())
-		prefixes += timestampStyle.Render(str) + " "
+		logging.Warnf("Invalid server-time %q: %v", serverTime, err)
 	}
 
-	if opts.NotImpl {
-		prefixes += unimpl + " "
-	}
+	m.DateTime = time.Now()
+}
 
-	if opts.AsServerMsg {
-		prefixes += serverMsgStyle.Render("==") + " "
-		style = serverMsgStyle
+func NewChannel(name string) *Channel {
+	return &Channel{
+		Name:    name,
+		History: NewScrollback(DefaultMaxLines),
+		Users:   make(map[string]User),
+		Typing:  NewTyping(),
 	}
+}
 
-	if opts.AsErrorMsg {
-		prefixes += errorMsgStyle.Render("==") + " "
-		style = errorMsgStyle
-	}
+func (c *Channel) AppendMsg(datetime time.Time, fullMsg string, opts MsgFmtOpts) *Line {
+	return c.History.Append(datetime, fullMsg, opts)
+}
 
-	if datetime.Year() > c.LastUpdatedDate.Year() || datetime.YearDay() > c.LastUpdatedDate.YearDay() {
-		c.LastUpdatedDate = datetime
-		dateMsg := fmt.Sprintf("————— %s %d —————", c.LastUpdatedDate.Month().String(), c.LastUpdatedDate.Day())
-		c.History += dateStyle.Render(dateMsg) + CRLF
-	}
+// How long connecting to a network, including the proxy and TLS handshakes, may take
+var DialTimeout = 30 * time.Second
 
-	c.History += prefixes + style.Render(fullMsg) + CRLF
-}
+func (c *Client) Initialize(profile Profile) error {
+	// registering with a bare NICK only gets us an error from the server
+	if profile.Nickname == "" {
+		return errors.New("no nickname to connect with, set one in the config or with ?nick= or --nick")
+	}
 
-func (c *Client) Initialize(host string, port string, tlsEnabled bool) {
-	addr := fmt.Sprintf("%s:%s", host, port)
+	addr := net.JoinHostPort(profile.Host, profile.Port)
 
-	c.Host = host
-	c.Port = port
+	c.Profile = profile
+	c.Host = profile.Host
+	c.Port = profile.Port
 	c.EnabledCapabilities = make(Capabilities, 0)
+	c.Caps = NewCapNegotiation()
+
+	c.friends.mu.Lock()
+	c.friends.list = nil
+	c.friends.mu.Unlock()
+	for _, nick := range profile.Friends {
+		c.AddFriend(nick)
+	}
+	c.Batches = NewBatches()
+	c.Requests = NewRequests()
+	c.Transfers = NewTransfers()
 	c.EnabledFeatures = make(Features, 0)
 
-	if tlsEnabled {
-		cfg := &tls.Config{ServerName: host}
-		conn, err := tls.Dial("tcp", addr, cfg)
-		if err != nil {
-			// TODO: properly handle the error instead of Fatal-ing (failed to initiate a connection to server)
-			log.Fatal(err)
-		}
+	var conn net.Conn
+	var err error
 
-		c.TCPConn = conn
-		return
+	if profile.Proxy != "" {
+		conn, err = dialSOCKS5(profile.Proxy, addr)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, DialTimeout)
 	}
 
-	addrTCP, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	conn, err := net.DialTCP("tcp", nil, addrTCP)
-	if err != nil {
-		log.Fatal(err)
+	if profile.TLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: profile.Host})
+		conn.SetDeadline(time.Now().Add(DialTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn.SetDeadline(time.Time{})
+
+		conn = tlsConn
 	}
 
 	c.TCPConn = conn
+	return nil
+}
+
+// Disconnect quits the network with an optional reason and closes the connection.
+func (c *Client) Disconnect(reason string) {
+	if c.TCPConn == nil {
+		return
+	}
+
+	c.SendCommand(commands.QUIT, reason)
+	c.TCPConn.Close()
+}
+
+// NetworkName returns the name the server advertises in RPL_ISUPPORT, or the host if it didn't.
+func (c *Client) NetworkName() string {
+	if name := c.EnabledFeatures["NETWORK"]; name != "" {
+		return name
+	}
+
+	return c.Host
 }
 
-func (c *Client) Register(nick string, password string, channel string) {
-	hostChannel := Channel{
-		Name:  c.Host,
-		Users: make(map[string]User),
+// MembershipPrefixes returns the channel membership prefixes from RPL_ISUPPORT PREFIX, highest first.
+// The usual ones are assumed if the server didn't advertise any.
+func (c *Client) MembershipPrefixes() string {
+	if _, prefixes, ok := strings.Cut(c.EnabledFeatures["PREFIX"], ")"); ok {
+		return prefixes
 	}
-	root := &Node[Channel]{Value: hostChannel}
-	root.Next = root
-	root.Prev = root
 
-	c.ActiveChannel = root
-	c.RootChannel = root
+	return "~&@%+"
+}
+
+func (c *Client) Register() {
+	c.Buffers = NewBuffers()
+	c.Users = NewUsers()
+	c.RootChannel = c.AppendChannel(NewChannel(c.Host))
+	c.ActiveChannel = c.RootChannel
+
+	nick := c.Profile.Nickname
+	username := c.Profile.Username
+	if username == "" {
+		username = nick
+	}
+	realname := c.Profile.Realname
+	if realname == "" {
+		realname = nick
+	}
 
+	c.Caps.Negotiating = true
 	c.SendCommand(commands.CAP, "LS", "302")
-	if password != "" {
-		c.SendCommand(commands.PASS, password)
+	if c.Profile.Password != "" {
+		c.SendCommand(commands.PASS, c.Profile.Password)
 	}
+	// our nickname is only set once the server confirms it in RPL_WELCOME
 	c.SendCommand(commands.NICK, nick)
-	// set user-wanted nickname
-	c.Nickname = nick
-	c.SendCommand(commands.USER, nick, "0", "*", nick)
+	c.SendCommand(commands.USER, username, "0", "*", realname)
 }
 
-func (c *Client) AppendChannel(channel Channel) *Node[Channel] {
-	first := c.RootChannel
-	last := c.RootChannel.Prev
-
-	newNode := &Node[Channel]{
-		Value: channel,
-		Next:  first,
-		Prev:  last,
+// AppendChannel adds a buffer to the end of the tab bar.
+// If a buffer with the same name is already open, that buffer is returned instead.
+func (c *Client) AppendChannel(channel *Channel) *Channel {
+	if c.ScrollbackLimit > 0 {
+		channel.History.SetMaxLines(c.ScrollbackLimit)
 	}
 
-	last.Next = newNode
-	first.Prev = newNode
+	return c.Buffers.Add(channel)
+}
 
-	return newNode
+// RemoveChannel closes a buffer, the previous buffer becomes active if it was the active one.
+func (c *Client) RemoveChannel(channel *Channel) {
+	if channel == c.ActiveChannel {
+		c.ActiveChannel = c.Buffers.Prev(channel)
+	}
+
+	c.Buffers.Remove(channel.Name)
 }
 
-func (c *Client) RemoveChannel(channel *Node[Channel]) {
-	prev := channel.Prev
-	next := channel.Next
+// Casefold lowercases a name according to the server's CASEMAPPING.
+func (c *Client) Casefold(name string) string {
+	casemapping, ok := c.EnabledFeatures["CASEMAPPING"]
+	if !ok {
+		casemapping = CasemappingRFC1459
+	}
 
-	prev.Next = next
-	next.Prev = prev
+	return Casefold(casemapping, name)
+}
 
-	channel.Prev = nil
-	channel.Next = nil
+// IsMe reports whether nick is the nickname we're currently using.
+func (c *Client) IsMe(nick string) bool {
+	return c.Casefold(nick) == c.Casefold(c.Nickname)
 }
 
 func (c *Client) SendCommand(cmd string, params ...string) {
+	c.SendTagged(nil, cmd, params...)
+}
+
+var lineBreaks = strings.NewReplacer("\r", "", "\n", "")
+
+// SendTagged sends a command with client tags, e.g. a label or a +typing notification.
+func (c *Client) SendTagged(tags MessageTags, cmd string, params ...string) {
 	if c.TCPConn == nil {
 		// TODO: properly handle the error instead of Fatal-ing
 		log.Fatal("Attempted to write data to nil connection")
 	}
 
+	// a line break in a param would end the line and start a command of its own
+	clean := make([]string, len(params))
+	for i, param := range params {
+		clean[i] = lineBreaks.Replace(param)
+	}
+
+	c.TCPConn.Write([]byte(FormatTags(tags) + cmd + formatParams(clean) + CRLF))
+}
+
+func formatParams(params []string) string {
 	paramsString := ""
 
 	// if we have more than 1 param
@@ -269,7 +436,7 @@ func (c *Client) SendCommand(cmd string, params ...string) {
 		lastParam := params[len(params)-1]
 		// if the last param is a trailing param
 		// we prepend a colon to it
-		if strings.Contains(lastParam, " ") {
+		if strings.Contains(lastParam, " ") || lastParam == "" || lastParam[0] == ':' {
 			lastParam = " :" + lastParam
 		} else {
 			lastParam = " " + lastParam
@@ -281,12 +448,45 @@ func (c *Client) SendCommand(cmd string, params ...string) {
 		// if we have exactly 1 param and it's not empty
 	} else if len(params) == 1 && params[0] != "" {
 		// if this 1 param contains spaces, we prepend a colon
-		if strings.Contains(params[0], " ") {
+		if strings.Contains(params[0], " ") || params[0][0] == ':' {
 			paramsString = " :" + params[0]
 		} else {
 			paramsString = " " + params[0]
 		}
 	}
 
-	c.TCPConn.Write([]byte(cmd + paramsString + CRLF))
+	return paramsString
+}
+
+var tagEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	";", "\\:",
+	" ", "\\s",
+	"\r", "\\r",
+	"\n", "\\n",
+)
+
+// FormatTags returns the tags prefix of a message including the trailing space,
+// or an empty string if there are no tags.
+func FormatTags(tags MessageTags) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if value := tags[key]; value != "" {
+			parts = append(parts, key+"="+tagEscaper.Replace(value))
+		} else {
+			parts = append(parts, key)
+		}
+	}
+
+	return "@" + strings.Join(parts, ";") + " "
 }
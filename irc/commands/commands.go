@@ -52,6 +52,7 @@ const (
 	STATS   = "STATS"   // Query statistics of a server.
 	INFO    = "INFO"    // Get information about a server. e.g, software name/version, compile date of server, copyright. etc...
 	MODE    = "MODE"    // Set or remove modes from a target, either a user(client) target, or a channel target.
+	WHO     = "WHO"     // Query the users of a channel or matching a mask.
 
 	// Sending Messages
 	PRIVMSG = "PRIVMSG" // Sends a "private" message to either a channel or another client
@@ -63,6 +64,21 @@ const (
 	// Optional Messages
 	AWAY     = "AWAY"     // Indicate that the client (user) is away/afk/etc..
 	USERHOST = "USERHOST" // Get information about user with a given nickname. can take up to 5 nickanmes.
+	ISON     = "ISON"     // Check which of the given nicknames are online.
+
+	// IRCv3 Messages
+	BATCH = "BATCH" // Start or end a batch of messages that should be handled together.
+	ACK   = "ACK"   // Reply to a labeled command that has no other reply.
+
+	CHATHISTORY = "CHATHISTORY" // Fetch message history of a channel or a private conversation. (Draft)
+	FAIL        = "FAIL"        // Standard reply saying a command failed.
+	MARKREAD    = "MARKREAD"    // Get or set the read marker of a buffer. (Draft)
+	BOUNCER     = "BOUNCER"     // List and bind to the upstream networks of a soju bouncer.
+	MONITOR     = "MONITOR"     // Get notified when the given nicknames come online or go offline.
+	TAGMSG      = "TAGMSG"      // A message with only tags and no text, e.g. reactions.
+	ACCOUNT     = "ACCOUNT"     // A user logged in to or out of their account.
+	CHGHOST     = "CHGHOST"     // A user's username or host changed.
+	SETNAME     = "SETNAME"     // Change our realname, or a user changed theirs.
 
 	// Left behind...
 	PING = "PING"
@@ -169,7 +185,7 @@ const (
 	RPL_NONE            = "300" // RFC1459 - Not Implemented (TODO:)
 	RPL_AWAY            = "301" // RFC1459 - Implemented
 	RPL_USERHOST        = "302" // RFC1459 - Not Implemented (TODO:)
-	RPL_ISON            = "303" // RFC1459 - Not Implemented (TODO:)
+	RPL_ISON            = "303" // RFC1459 - Implemented
 	RPL_TEXT            = "304" // irc2 - Not Implemented (TODO:)
 	RPL_UNAWAY          = "305" // RFC1459 - Implemented
 	RPL_NOWAWAY         = "306" // RFC1459 - Implemented
@@ -177,7 +193,7 @@ const (
 	RPL_WHOISSERVER     = "312" // RFC1459 - Implemented
 	RPL_WHOISOPERATOR   = "313" // RFC1459 - Not Implemented (TODO:)
 	RPL_WHOWASUSER      = "314" // RFC1459 - Not Implemented (TODO:)
-	RPL_ENDOFWHO        = "315" // RFC1459 - Not Implemented (TODO:)
+	RPL_ENDOFWHO        = "315" // RFC1459 - Implemented
 	RPL_WHOISIDLE       = "317" // RFC1459 - Implemented
 	RPL_ENDOFWHOIS      = "318" // RFC1459 - Implemented
 	RPL_WHOISCHANNELS   = "319" // RFC1459 - Implemented
@@ -198,8 +214,9 @@ const (
 	RPL_ENDOFEXCEPTLIST = "349" // RFC2812 - Not Implemented (TODO:)
 	RPL_WHOISGATEWAY    = "350" // InspIRCd - Not Implemented (TODO:)
 	RPL_VERSION         = "351" // RFC1459 - Implemented
-	RPL_WHOREPLY        = "352" // RFC1459 - Not Implemented (TODO:)
+	RPL_WHOREPLY        = "352" // RFC1459 - Implemented
 	RPL_NAMREPLY        = "353" // RFC1459 - Implemented
+	RPL_WHOSPCRPL       = "354" // ircu (WHOX) - Implemented
 	RPL_KILLDONE        = "361" // RFC1459 - Not Implemented - Deprecated (TODO:)
 	RPL_CLOSING         = "362" // RFC1459 - Not Implemented - Deprecated (TODO:)
 	RPL_CLOSEEND        = "363" // RFC1459 - Not Implemented - Deprecated (TODO:)
@@ -246,9 +263,9 @@ const (
 	ERR_NOADMININFO       = "423" // RFC1459 - Not Implemented (TODO:)
 	ERR_FILEERROR         = "424" // RFC1459 - Not Implemented (TODO:)
 	ERR_NONICKNAMEGIVEN   = "431" // RFC1459 - Implemented
-	ERR_ERRONEUSNICKNAME  = "432" // RFC1459 - Not Implemented (TODO:)
-	ERR_NICKNAMEINUSE     = "433" // RFC1459 - Not Implemented (TODO:)
-	ERR_NICKCOLLISION     = "436" // RFC1459 - Not Implemented (TODO:)
+	ERR_ERRONEUSNICKNAME  = "432" // RFC1459 - Implemented
+	ERR_NICKNAMEINUSE     = "433" // RFC1459 - Implemented
+	ERR_NICKCOLLISION     = "436" // RFC1459 - Implemented
 	ERR_UNAVAILRESOURCE   = "437" // RFC2812 - Not Implemented - Has Conflicts (TODO:)
 	ERR_USERNOTINCHANNEL  = "441" // RFC1459 - Not Implemented (TODO:)
 	ERR_NOTONCHANNEL      = "442" // RFC1459 - Not Implemented (TODO:)
@@ -283,6 +300,11 @@ const (
 	RPL_STARTTLS          = "670" // IRCv3 - Not Implemented (TODO:)
 	ERR_STARTTLS          = "691" // IRCv3 - Not Implemented (TODO:)
 	ERR_NOPRIVS           = "723" // RatBox - Not Implemented (TODO:)
+	RPL_MONONLINE         = "730" // IRCv3 - Implemented
+	RPL_MONOFFLINE        = "731" // IRCv3 - Implemented
+	RPL_MONLIST           = "732" // IRCv3 - Implemented
+	RPL_ENDOFMONLIST      = "733" // IRCv3 - Implemented
+	ERR_MONLISTFULL       = "734" // IRCv3 - Implemented
 	RPL_WHOISKEYVALUE     = "760" // IRCv3 - Not Implemented (TODO:)
 	RPL_KEYVALUE          = "761" // IRCv3 - Not Implemented (TODO:)
 	RPL_METADATAEND       = "762" // IRCv3 - Not Implemented (TODO:)
@@ -292,15 +314,15 @@ const (
 	ERR_KEYINVALID        = "767" // IRCv3 - Not Implemented (TODO:)
 	ERR_KEYNOTSET         = "768" // IRCv3 - Not Implemented (TODO:)
 	ERR_KEYNOPERMISSION   = "769" // IRCv3 - Not Implemented (TODO:)
-	RPL_LOGGEDIN          = "900" // Charybdis/Atheme,IRCv3 - Not Implemented (TODO:)
-	RPL_LOGGEDOUT         = "901" // Charybdis/Atheme,IRCv3 - Not Implemented (TODO:)
-	ERR_NICKLOCKED        = "902" // Charybdis/Atheme,IRCv3 - Not Implemented (TODO:)
-	RPL_SASLSUCCESS       = "903" // Charybdis/Atheme,IRCv3 - Not Implemented (TODO:)
-	ERR_SASLFAIL          = "904" // Charybdis/Atheme,IRCv3 - Not Implemented (TODO:)
-	ERR_SASLTOOLONG       = "905" // Charybdis/Atheme,IRCv3 - Not Implemented (TODO:)
-	ERR_SASLABORTED       = "906" // Charybdis/Atheme,IRCv3 - Not Implemented (TODO:)
-	ERR_SASLALREADY       = "907" // Charybdis/Atheme,IRCv3 - Not Implemented (TODO:)
-	RPL_SASLMECHS         = "908" // Charybdis/Atheme,IRCv3 - Not Implemented (TODO:)
+	RPL_LOGGEDIN          = "900" // Charybdis/Atheme,IRCv3 - Implemented
+	RPL_LOGGEDOUT         = "901" // Charybdis/Atheme,IRCv3 - Implemented
+	ERR_NICKLOCKED        = "902" // Charybdis/Atheme,IRCv3 - Implemented
+	RPL_SASLSUCCESS       = "903" // Charybdis/Atheme,IRCv3 - Implemented
+	ERR_SASLFAIL          = "904" // Charybdis/Atheme,IRCv3 - Implemented
+	ERR_SASLTOOLONG       = "905" // Charybdis/Atheme,IRCv3 - Implemented
+	ERR_SASLABORTED       = "906" // Charybdis/Atheme,IRCv3 - Implemented
+	ERR_SASLALREADY       = "907" // Charybdis/Atheme,IRCv3 - Implemented
+	RPL_SASLMECHS         = "908" // Charybdis/Atheme,IRCv3 - Implemented
 )
 
 /* --- RPL_ISUPPORT Parameters --- */
@@ -309,63 +331,65 @@ var Features = map[string]bool{
 	// The AWAYLEN parameter indicates the maximum length for the <reason> of an AWAY command.
 	// If an AWAY <reason> has more characters than this parameter, it may be silently truncated by the server before being passed on to other clients.
 	// Clients MAY receive an AWAY <reason> that has more characters than this parameter
-	"AWAYLEN":     true,
-	"BOT":         false,
-	"CALLERID":    false,
-	"CASEMAPPING": false,
-	"CHANLIMIT":   false,
-	"CHANMODES":   false,
-	"CHANNELLEN":  false,
-	"CHANTYPES":   false, // Channel Types. Default is #. Available are #&
-	"CHARSET":     false, // Deprecated but might still be used
-	"CLIENTVER":   false, // Deprecated but might still be used
-	"CNOTICE":     false,
-	"CPRIVMSG":    false,
-	"DEAF":        false,
-	"ELIST":       false,
-	"ESILENCE":    false,
-	"ETRACE":      false,
-	"EXCEPTS":     false,
-	"EXTBAN":      false,
-	"FNC":         false, // Deprecated but might still be used
-	"INVEX":       false,
-	"KEYLEN":      false,
-	"KICKLEN":     true,
-	"KNOCK":       false,
-	"LINELEN":     false, // Proposed
-	"MAP":         false, // Deprecated but might still be used
-	"MAXBANS":     false, // Deprecated but might still be used
-	"MAXCHANNELS": false, // Deprecated but might still be used
-	"MAXLIST":     false,
-	"MAXNICKLEN":  false,
-	"MAXPARA":     false, // Deprecated but might still be used
-	"MAXTARGETS":  false,
-	"METADATA":    false,
-	"MODES":       false,
-	"MONITOR":     false,
-	"NAMESX":      false, // Deprecated but might still be used
-	"NETWORK":     true,
-	"NICKLEN":     true,
-	"OVERRIDE":    false,
-	"PREFIX":      false,
-	"SAFELIST":    false,
-	"SECURELIST":  false,
-	"SILENCE":     false,
-	"SSL":         false, // Deprecated but might still be used
-	"STARTTLS":    false, // Deprecated but might still be used
-	"STATUSMSG":   false,
-	"STD":         false, // Deprecated but might still be used
-	"TARGMAX":     false,
-	"TOPICLEN":    true,
-	"UHNAMES":     false, // Deprecated but might still be used
-	"USERIP":      false,
-	"USERLEN":     false, // Proposed
-	"VBANLIST":    false, // Deprecared but might still be used
-	"VLIST":       false,
-	"WALLCHOPS":   false, // Deprecated but might still be used
-	"WALLVOICES":  false, // Deprecated but might still be used
-	"WATCH":       false,
-	"WHOX":        false,
+	"AWAYLEN":       true,
+	"BOT":           false,
+	"CALLERID":      false,
+	"CASEMAPPING":   true,
+	"CHANLIMIT":     false,
+	"CHANMODES":     false,
+	"CHANNELLEN":    false,
+	"CHANTYPES":     false, // Channel Types. Default is #. Available are #&
+	"CHARSET":       false, // Deprecated but might still be used
+	"CHATHISTORY":   true,
+	"CLIENTTAGDENY": true,
+	"CLIENTVER":     false, // Deprecated but might still be used
+	"CNOTICE":       false,
+	"CPRIVMSG":      false,
+	"DEAF":          false,
+	"ELIST":         false,
+	"ESILENCE":      false,
+	"ETRACE":        false,
+	"EXCEPTS":       false,
+	"EXTBAN":        false,
+	"FNC":           false, // Deprecated but might still be used
+	"INVEX":         false,
+	"KEYLEN":        false,
+	"KICKLEN":       true,
+	"KNOCK":         false,
+	"LINELEN":       false, // Proposed
+	"MAP":           false, // Deprecated but might still be used
+	"MAXBANS":       false, // Deprecated but might still be used
+	"MAXCHANNELS":   false, // Deprecated but might still be used
+	"MAXLIST":       false,
+	"MAXNICKLEN":    false,
+	"MAXPARA":       false, // Deprecated but might still be used
+	"MAXTARGETS":    false,
+	"METADATA":      false,
+	"MODES":         false,
+	"MONITOR":       true,
+	"NAMESX":        false, // Deprecated but might still be used
+	"NETWORK":       true,
+	"NICKLEN":       true,
+	"OVERRIDE":      false,
+	"PREFIX":        false,
+	"SAFELIST":      false,
+	"SECURELIST":    false,
+	"SILENCE":       false,
+	"SSL":           false, // Deprecated but might still be used
+	"STARTTLS":      false, // Deprecated but might still be used
+	"STATUSMSG":     false,
+	"STD":           false, // Deprecated but might still be used
+	"TARGMAX":       false,
+	"TOPICLEN":      true,
+	"UHNAMES":       false, // Deprecated but might still be used
+	"USERIP":        false,
+	"USERLEN":       false, // Proposed
+	"VBANLIST":      false, // Deprecared but might still be used
+	"VLIST":         false,
+	"WALLCHOPS":     false, // Deprecated but might still be used
+	"WALLVOICES":    false, // Deprecated but might still be used
+	"WATCH":         false,
+	"WHOX":          true,
 }
 
 var Capabilities = map[string]bool{
@@ -374,36 +398,48 @@ var Capabilities = map[string]bool{
 	"twitch.tv/commands":   false,
 	"twitch.tv/tags":       false,
 
+	// soju-specific capabilities
+	"soju.im/bouncer-networks":        true,
+	"soju.im/bouncer-networks-notify": true,
+
+	// ZNC-specific capabilities
+	"znc.in/playback":     true,
+	"znc.in/self-message": true,
+
 	// Solanum-specific capabilities
 	"solanum.chat/identify-msg": false,
 	"solanum.chat/oper":         false,
 	"solanum.chat/realhost":     false,
 
 	// IRCv3 capabilities
-	"account-notify":       false,
+	"account-notify":       true,
 	"account-registration": false, // Draft
-	"account-tag":          false,
-	"away-notify":          false,
-	"batch":                false,
+	"account-tag":          true,
+	"away-notify":          true,
+	"batch":                true,
 	"cap-notify":           true,
 	"channel-rename":       false, // Draft
 	"chathistory":          false, // Draft
-	"chghost":              false,
-	"echo-message":         false,
+	"draft/chathistory":    true,
+	"chghost":              true,
+	"echo-message":         true,
 	"event-playback":       false, // Draft
-	"extended-join":        false,
-	"extended-monitor":     false,
+	"draft/event-playback": true,
+	"extended-join":        true,
+	"extended-monitor":     true,
 	"invite-notify":        false,
-	"labeled-response":     false,
-	"message-tags":         false,
+	"labeled-response":     true,
+	"message-tags":         true,
 	"metadata":             false,
 	"monitor":              false,
 	"multi-prefix":         false,
 	"multiline":            false, // Draft
+	"draft/multiline":      true,
 	"read-marker":          false, // Draft
-	"sasl":                 false,
+	"draft/read-marker":    true,
+	"sasl":                 true,
 	"server-time":          true,
-	"setname":              false,
+	"setname":              true,
 	"tls":                  false, // Deprecated
 	"userhost-in-names":    false,
 }
@@ -0,0 +1,226 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// What a slash command's argument completes to
+type ArgKind int
+
+const (
+	// nicks, or channels if the word starts like one
+	ArgAny ArgKind = iota
+	ArgNick
+	ArgChannel
+)
+
+// SlashCommand is what tab completion knows about a slash command.
+type SlashCommand struct {
+	Name string
+
+	// What the first argument can be if the command has subcommands
+	Subcommands []string
+
+	// What the arguments after the subcommand complete to, the last one goes for the rest of them
+	Args []ArgKind
+}
+
+// Completion is the word before the cursor and what it can be completed to, suffixes included.
+type Completion struct {
+	// rune offsets of the word in the line
+	Start int
+	End   int
+
+	Matches []string
+}
+
+// Spoke remembers when nick last said something in channel so they're completed first.
+func (c *Client) Spoke(channel *Channel, nick string, at time.Time) {
+	name := nick
+	if _, ok := channel.Users[name]; !ok {
+		// the nick is usually spelled the same as in the channel's list, only look further if it isn't
+		name = ""
+		for other := range channel.Users {
+			if c.Casefold(other) == c.Casefold(nick) {
+				name = other
+				break
+			}
+		}
+	}
+
+	// history can be older than what they last said
+	user, ok := channel.Users[name]
+	if ok && at.After(user.LastSpoke) {
+		user.LastSpoke = at
+		channel.Users[name] = user
+	}
+}
+
+// Complete finds what the word before the cursor can be completed to.
+// Commands and their subcommands come from slash, channels from our buffers and nicks from the active buffer.
+func (c *Client) Complete(line string, cursor int, slash []SlashCommand) Completion {
+	runes := []rune(line)
+	cursor = max(0, min(cursor, len(runes)))
+
+	start := cursor
+	for start > 0 && runes[start-1] != ' ' {
+		start--
+	}
+
+	completion := c.complete(runes, start, cursor, slash)
+
+	// there's already a space after the word
+	if cursor < len(runes) && runes[cursor] == ' ' {
+		for i, match := range completion.Matches {
+			completion.Matches[i] = strings.TrimSuffix(match, " ")
+		}
+	}
+
+	return completion
+}
+
+func (c *Client) complete(runes []rune, start int, cursor int, slash []SlashCommand) Completion {
+	line := string(runes)
+	completion := Completion{Start: start, End: cursor}
+	word := string(runes[start:cursor])
+	before := strings.Fields(string(runes[:start]))
+
+	if !strings.HasPrefix(line, "/") {
+		// a tab on an empty word moves focus instead
+		if word == "" {
+			return completion
+		}
+
+		suffix := " "
+		if start == 0 {
+			suffix = ": "
+		}
+
+		completion.Matches = c.completeWord(word, ArgAny, suffix)
+		return completion
+	}
+
+	if len(before) == 0 {
+		// the cursor is before the /
+		if word == "" {
+			return completion
+		}
+
+		for _, command := range slash {
+			if strings.HasPrefix(command.Name, strings.ToLower(word[1:])) {
+				completion.Matches = append(completion.Matches, "/"+command.Name+" ")
+			}
+		}
+		return completion
+	}
+
+	var command *SlashCommand
+	for i := range slash {
+		if strings.EqualFold(slash[i].Name, before[0][1:]) {
+			command = &slash[i]
+		}
+	}
+
+	arg, kind := len(before)-1, ArgAny
+	if command != nil && len(command.Subcommands) > 0 {
+		if arg == 0 {
+			for _, sub := range command.Subcommands {
+				if strings.HasPrefix(sub, strings.ToLower(word)) {
+					completion.Matches = append(completion.Matches, sub+" ")
+				}
+			}
+			return completion
+		}
+		arg--
+	}
+
+	if command != nil && len(command.Args) > 0 {
+		kind = command.Args[min(arg, len(command.Args)-1)]
+	}
+
+	completion.Matches = c.completeWord(word, kind, " ")
+	return completion
+}
+
+func (c *Client) completeWord(word string, kind ArgKind, suffix string) []string {
+	if kind == ArgAny && word != "" && strings.ContainsAny(word[:1], "#&") {
+		kind = ArgChannel
+	}
+
+	var matches []string
+	if kind == ArgChannel {
+		matches = c.completeChannels(word)
+	} else {
+		matches = c.completeNicks(word)
+	}
+
+	for i := range matches {
+		matches[i] += suffix
+	}
+
+	return matches
+}
+
+// completeNicks returns the nicks of the active buffer starting with prefix, whoever spoke last first.
+func (c *Client) completeNicks(prefix string) []string {
+	if c.ActiveChannel == nil {
+		return nil
+	}
+
+	prefix = c.Casefold(prefix)
+
+	var nicks []string
+	for nick := range c.ActiveChannel.Users {
+		if !c.IsMe(nick) && strings.HasPrefix(c.Casefold(nick), prefix) {
+			nicks = append(nicks, nick)
+		}
+	}
+
+	users := c.ActiveChannel.Users
+	sort.Slice(nicks, func(i, j int) bool {
+		a, b := users[nicks[i]].LastSpoke, users[nicks[j]].LastSpoke
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return c.Casefold(nicks[i]) < c.Casefold(nicks[j])
+	})
+
+	return nicks
+}
+
+// completeChannels returns the channels we have buffers for starting with prefix, the # can be left out.
+func (c *Client) completeChannels(prefix string) []string {
+	prefix = c.Casefold(prefix)
+
+	var channels []string
+	for _, buffer := range c.Buffers.All() {
+		if buffer == c.RootChannel || buffer.Name == "" || !strings.ContainsAny(buffer.Name[:1], "#&") {
+			continue
+		}
+
+		name := c.Casefold(buffer.Name)
+		if strings.HasPrefix(name, prefix) || strings.HasPrefix(name[1:], prefix) {
+			channels = append(channels, buffer.Name)
+		}
+	}
+
+	return channels
+}
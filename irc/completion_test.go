@@ -0,0 +1,143 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompletion(t *testing.T) {
+	slash := []SlashCommand{
+		{Name: "cap", Subcommands: []string{"list", "ls", "req"}},
+		{Name: "join", Args: []ArgKind{ArgChannel}},
+		{Name: "kick", Args: []ArgKind{ArgChannel, ArgNick}},
+		{Name: "me"},
+	}
+
+	newClient := func(t *testing.T) *Client {
+		client, _ := newTestClient(t)
+		client.Nickname = "Me"
+
+		channel := client.AppendChannel(NewChannel("#gorc"))
+		client.AppendChannel(NewChannel("#go-nuts"))
+		client.AppendChannel(NewChannel("alice"))
+		client.ActiveChannel = channel
+
+		for _, nick := range []string{"alice", "Alan", "bob", "me"} {
+			channel.Users[nick] = User{}
+		}
+
+		return client
+	}
+
+	complete := func(client *Client, line string) []string {
+		return client.Complete(line, len([]rune(line)), slash).Matches
+	}
+
+	t.Run("Test completing nicks", func(t *testing.T) {
+		client := newClient(t)
+
+		if matches := complete(client, "al"); !reflect.DeepEqual(matches, []string{"Alan: ", "alice: "}) {
+			t.Fatal("Wrong matches at the start of the line:", matches)
+		}
+
+		if matches := complete(client, "hi B"); !reflect.DeepEqual(matches, []string{"bob "}) {
+			t.Fatal("Wrong matches in the middle of the line:", matches)
+		}
+
+		if matches := complete(client, "m"); matches != nil {
+			t.Fatal("Completed our own nick:", matches)
+		}
+
+		if matches := complete(client, ""); matches != nil {
+			t.Fatal("Completed an empty line:", matches)
+		}
+	})
+
+	t.Run("Test most recent speaker first", func(t *testing.T) {
+		client := newClient(t)
+		now := time.Now()
+
+		client.Spoke(client.ActiveChannel, "ALICE", now)
+		client.Spoke(client.ActiveChannel, "alan", now.Add(-time.Minute))
+
+		if matches := complete(client, "a"); !reflect.DeepEqual(matches, []string{"alice: ", "Alan: "}) {
+			t.Fatal("Wrong order:", matches)
+		}
+
+		// older history doesn't change when they last spoke
+		client.Spoke(client.ActiveChannel, "alice", now.Add(-time.Hour))
+		if !client.ActiveChannel.Users["alice"].LastSpoke.Equal(now) {
+			t.Fatal("Older message moved the time back")
+		}
+	})
+
+	t.Run("Test completing channels", func(t *testing.T) {
+		client := newClient(t)
+
+		if matches := complete(client, "see #go"); !reflect.DeepEqual(matches, []string{"#gorc ", "#go-nuts "}) {
+			t.Fatal("Wrong channels:", matches)
+		}
+
+		if matches := complete(client, "/join go-"); !reflect.DeepEqual(matches, []string{"#go-nuts "}) {
+			t.Fatal("Wrong channels without the #:", matches)
+		}
+	})
+
+	t.Run("Test completing commands", func(t *testing.T) {
+		client := newClient(t)
+
+		if matches := complete(client, "/"); len(matches) != len(slash) {
+			t.Fatal("Wrong commands:", matches)
+		}
+
+		if matches := client.Complete("/join", 0, slash).Matches; matches != nil {
+			t.Fatal("Completed before the /:", matches)
+		}
+
+		if matches := complete(client, "/J"); !reflect.DeepEqual(matches, []string{"/join "}) {
+			t.Fatal("Wrong command:", matches)
+		}
+
+		if matches := complete(client, "/cap l"); !reflect.DeepEqual(matches, []string{"list ", "ls "}) {
+			t.Fatal("Wrong subcommands:", matches)
+		}
+
+		if matches := complete(client, "/kick #gorc b"); !reflect.DeepEqual(matches, []string{"bob "}) {
+			t.Fatal("Wrong nick argument:", matches)
+		}
+
+		if matches := complete(client, "/kick g"); !reflect.DeepEqual(matches, []string{"#gorc ", "#go-nuts "}) {
+			t.Fatal("Wrong channel argument:", matches)
+		}
+
+		if matches := complete(client, "/me waves at b"); !reflect.DeepEqual(matches, []string{"bob "}) {
+			t.Fatal("Wrong nick in a command's text:", matches)
+		}
+	})
+
+	t.Run("Test completing in the middle of the line", func(t *testing.T) {
+		client := newClient(t)
+
+		completion := client.Complete("hi bo there", 5, slash)
+		if completion.Start != 3 || completion.End != 5 || !reflect.DeepEqual(completion.Matches, []string{"bob"}) {
+			t.Fatal("Wrong completion:", completion)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// What we answer CTCP VERSION and SOURCE with
+const (
+	ClientVersion = "gorc"
+	ClientSource  = "https://github.com/illusionman1212/gorc"
+)
+
+// Every source gets at most CTCPBurst replies per CTCPWindow so we can't be used to flood someone.
+var (
+	CTCPBurst  = 3
+	CTCPWindow = 10 * time.Second
+)
+
+const ctcpDelim = "\x01"
+
+// The CTCP queries we answer, and ACTION which isn't a query but is still supported
+var ctcpQueries = []string{"ACTION", "CLIENTINFO", "PING", "SOURCE", "TIME", "VERSION"}
+
+// CTCP is a client-to-client query or reply that's framed inside a PRIVMSG or NOTICE.
+// (https://modern.ircdocs.horse/ctcp)
+type CTCP struct {
+	Command string
+	Params  string
+}
+
+// ParseCTCP returns the CTCP message in text if it's one,
+// the closing delimiter is optional since some clients leave it out.
+func ParseCTCP(text string) (CTCP, bool) {
+	if !strings.HasPrefix(text, ctcpDelim) {
+		return CTCP{}, false
+	}
+
+	text = strings.TrimPrefix(text, ctcpDelim)
+	text = strings.TrimSuffix(text, ctcpDelim)
+
+	command, params, _ := strings.Cut(text, " ")
+	if command == "" {
+		return CTCP{}, false
+	}
+
+	return CTCP{Command: strings.ToUpper(command), Params: params}, true
+}
+
+// FormatCTCP frames a CTCP command and its parameters so it can be sent in a PRIVMSG or NOTICE.
+func FormatCTCP(command string, params string) string {
+	if params == "" {
+		return ctcpDelim + command + ctcpDelim
+	}
+
+	return ctcpDelim + command + " " + params + ctcpDelim
+}
+
+// FormatPrivMsg returns how a message from nick is shown, actions are shown as "* nick waves".
+func FormatPrivMsg(nick string, text string) string {
+	if ctcp, ok := ParseCTCP(text); ok && ctcp.Command == "ACTION" {
+		return "* " + nick + " " + ctcp.Params
+	}
+
+	return nick + ": " + text
+}
+
+type ctcpLimiter struct {
+	mu      sync.Mutex
+	replies map[string][]time.Time
+}
+
+// allow reports whether source can be sent another reply and records it if so.
+func (l *ctcpLimiter) allow(source string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.replies == nil {
+		l.replies = make(map[string][]time.Time)
+	}
+
+	// forget everything older than the window so the map doesn't grow with every nick that ever asked
+	for key, times := range l.replies {
+		i := 0
+		for i < len(times) && now.Sub(times[i]) >= CTCPWindow {
+			i++
+		}
+
+		if i == len(times) {
+			delete(l.replies, key)
+		} else {
+			l.replies[key] = times[i:]
+		}
+	}
+
+	if len(l.replies[source]) >= CTCPBurst {
+		return false
+	}
+
+	l.replies[source] = append(l.replies[source], now)
+	return true
+}
+
+// ctcpReply returns what we answer a CTCP query with and false for queries we don't answer.
+func ctcpReply(query CTCP, now time.Time) (string, bool) {
+	switch query.Command {
+	case "VERSION":
+		return ClientVersion, true
+	case "SOURCE":
+		return ClientSource, true
+	case "PING":
+		return query.Params, true
+	case "TIME":
+		return now.Format(time.RFC1123Z), true
+	case "CLIENTINFO":
+		return strings.Join(ctcpQueries, " "), true
+	}
+
+	return "", false
+}
+
+// ReplyCTCP answers a CTCP query from nick with a NOTICE unless it's rate limited or one we don't answer.
+// It returns whether a reply was sent.
+func (c *Client) ReplyCTCP(nick string, query CTCP, now time.Time) bool {
+	reply, ok := ctcpReply(query, now)
+	if !ok || !c.ctcpLimiter.allow(c.Casefold(nick), now) {
+		return false
+	}
+
+	c.SendCommand(commands.NOTICE, nick, FormatCTCP(query.Command, reply))
+	return true
+}
+
+// SendCTCP sends a CTCP query to target, a PING without parameters gets the current time
+// so the round trip can be worked out from the reply.
+func (c *Client) SendCTCP(target string, command string, params string) {
+	command = strings.ToUpper(command)
+	if command == "PING" && params == "" {
+		params = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	}
+
+	c.SendCommand(commands.PRIVMSG, target, FormatCTCP(command, params))
+}
+
+// SendAction sends an ACTION to the buffer's target, e.g. "/me waves".
+func (c *Client) SendAction(buffer *Channel, text string) {
+	if buffer.IsDirect() {
+		c.sendDirect(buffer, FormatCTCP("ACTION", text))
+		return
+	}
+
+	c.sendPrivMsg(buffer, FormatCTCP("ACTION", text), nil)
+}
+
+// PingTime returns the round trip of a CTCP PING reply to one of our own pings.
+func PingTime(reply CTCP, now time.Time) (time.Duration, bool) {
+	sent, err := strconv.ParseInt(reply.Params, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return now.Sub(time.UnixMilli(sent)), true
+}
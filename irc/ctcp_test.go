@@ -0,0 +1,87 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCTCP(t *testing.T) {
+	t.Run("Test parsing", func(t *testing.T) {
+		ctcp, ok := ParseCTCP("\x01action waves at you\x01")
+		if !ok || ctcp.Command != "ACTION" || ctcp.Params != "waves at you" {
+			t.Fatal("CTCP wasn't parsed properly:", ctcp)
+		}
+
+		// some clients don't close it
+		if ctcp, ok := ParseCTCP("\x01VERSION"); !ok || ctcp.Command != "VERSION" || ctcp.Params != "" {
+			t.Fatal("Unterminated CTCP wasn't parsed:", ctcp)
+		}
+
+		if _, ok := ParseCTCP("hello"); ok {
+			t.Fatal("A normal message isn't a CTCP")
+		}
+
+		if FormatPrivMsg("alice", FormatCTCP("ACTION", "waves")) != "* alice waves" {
+			t.Fatal("Action wasn't formatted properly")
+		}
+	})
+
+	t.Run("Test rate limiting", func(t *testing.T) {
+		var limiter ctcpLimiter
+		now := time.Now()
+
+		for i := 0; i < CTCPBurst; i++ {
+			if !limiter.allow("alice", now) {
+				t.Fatal("Reply within the burst was denied")
+			}
+		}
+
+		if limiter.allow("alice", now) {
+			t.Fatal("Reply past the burst was allowed")
+		}
+
+		if !limiter.allow("bob", now) {
+			t.Fatal("Other sources shouldn't be limited")
+		}
+
+		if !limiter.allow("alice", now.Add(CTCPWindow)) {
+			t.Fatal("Reply should be allowed once the window has passed")
+		}
+	})
+
+	t.Run("Test replying", func(t *testing.T) {
+		client, server := newTestClient(t)
+
+		go client.ReplyCTCP("alice", CTCP{Command: "PING", Params: "123"}, time.Now())
+
+		line, err := server.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.TrimSuffix(line, CRLF) != "NOTICE alice :\x01PING 123\x01" {
+			t.Fatalf("Wrong reply: %q", line)
+		}
+
+		if client.ReplyCTCP("alice", CTCP{Command: "FINGER"}, time.Now()) {
+			t.Fatal("Unknown queries shouldn't be answered")
+		}
+	})
+}
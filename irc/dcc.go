@@ -0,0 +1,1119 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+var (
+	// How long an offer waits for the other side to connect before it's given up
+	DCCTimeout = 2 * time.Minute
+
+	// How long a transfer can stall before it's given up
+	DCCIdleTimeout = time.Minute
+
+	// How often the progress of a running transfer is redrawn
+	DCCProgressInterval = 500 * time.Millisecond
+)
+
+const dccBlockSize = 16 * 1024
+
+var ErrNoDCCAddress = errors.New("don't know which address to offer, set dcc-address or use /dcc send -passive")
+var errInvalidDCC = errors.New("invalid DCC request")
+
+type TransferStatus int
+
+const (
+	// Waiting to be accepted, or for the other side to connect
+	TransferOffered TransferStatus = iota
+	TransferActive
+	TransferDone
+	TransferFailed
+	TransferRejected
+)
+
+// Transfer is a file sent or received over DCC.
+// (https://modern.ircdocs.horse/dcc)
+type Transfer struct {
+	ID   int
+	Nick string
+
+	// The name the file was offered as and where it's read from or saved to
+	Filename string
+	Path     string
+
+	// 0 if the sender didn't tell us
+	Size int64
+
+	Sending bool
+
+	// Passive (or reverse) transfers are set up by the receiver listening,
+	// for when the sender can't accept connections. The token tells them apart.
+	Passive bool
+	Token   string
+
+	mu          sync.Mutex
+	status      TransferStatus
+	transferred int64
+	err         error
+
+	// Whether we asked the sender to resume and are waiting for them to accept
+	resuming bool
+
+	// address the other side listens on, and our listener for them to connect to
+	addr     string
+	listener net.Listener
+
+	// the line showing the transfer and when it was last redrawn
+	buffer    *Channel
+	line      *Line
+	lastShown time.Time
+}
+
+func (t *Transfer) Status() TransferStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status
+}
+
+// Transferred returns how much of the file is done, including anything we resumed from.
+func (t *Transfer) Transferred() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.transferred
+}
+
+func (t *Transfer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.err
+}
+
+func (t *Transfer) advance(n int64) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.transferred += n
+	return t.transferred
+}
+
+func (t *Transfer) complete() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.Size > 0 && t.transferred >= t.Size
+}
+
+// formatBytes formats a size with a binary unit, e.g. 1.5 MB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n)
+	units := []string{"KB", "MB", "GB", "TB"}
+	i := -1
+	for value >= unit && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+// String describes the transfer as it's shown in its buffer.
+func (t *Transfer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	size := "unknown size"
+	if t.Size > 0 {
+		size = formatBytes(t.Size)
+	}
+
+	verb, preposition := "Receiving", "from"
+	if t.Sending {
+		verb, preposition = "Sending", "to"
+	}
+
+	prefix := fmt.Sprintf("DCC #%d: ", t.ID)
+
+	switch t.status {
+	case TransferOffered:
+		if t.Sending {
+			return prefix + fmt.Sprintf("Offered %s (%s) to %s, waiting for them to accept", t.Filename, size, t.Nick)
+		}
+
+		if t.resuming {
+			return prefix + fmt.Sprintf("Asked %s to resume %s at %s", t.Nick, t.Filename, formatBytes(t.transferred))
+		}
+
+		return prefix + fmt.Sprintf("%s offers %s (%s), /dcc accept %d or /dcc reject %d", t.Nick, t.Filename, size, t.ID, t.ID)
+	case TransferActive:
+		progress := formatBytes(t.transferred)
+		if t.Size > 0 {
+			progress += fmt.Sprintf(" of %s (%d%%)", size, t.transferred*100/t.Size)
+		}
+
+		return prefix + fmt.Sprintf("%s %s %s %s: %s", verb, t.Filename, preposition, t.Nick, progress)
+	case TransferDone:
+		if t.Sending {
+			return prefix + fmt.Sprintf("Sent %s to %s (%s)", t.Filename, t.Nick, formatBytes(t.transferred))
+		}
+
+		return prefix + fmt.Sprintf("Received %s from %s (%s), saved to %s", t.Filename, t.Nick, formatBytes(t.transferred), t.Path)
+	case TransferRejected:
+		return prefix + fmt.Sprintf("%s %s %s %s was rejected", verb, t.Filename, preposition, t.Nick)
+	default:
+		return prefix + fmt.Sprintf("%s %s %s %s failed: %v", verb, t.Filename, preposition, t.Nick, t.err)
+	}
+}
+
+// Question asks whether to accept the offered file.
+func (t *Transfer) Question() string {
+	return fmt.Sprintf("Accept %s from %s?", t.Filename, t.Nick)
+}
+
+// Transfers keeps every DCC transfer of a network by id.
+type Transfers struct {
+	mu     sync.Mutex
+	list   []*Transfer
+	nextID int
+}
+
+func NewTransfers() *Transfers {
+	return &Transfers{}
+}
+
+func (ts *Transfers) add(t *Transfer) *Transfer {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.nextID++
+	t.ID = ts.nextID
+	ts.list = append(ts.list, t)
+
+	return t
+}
+
+// Get returns the transfer with the given id or nil if there's none.
+func (ts *Transfers) Get(id int) *Transfer {
+	return ts.find(func(t *Transfer) bool { return t.ID == id })
+}
+
+func (ts *Transfers) All() []*Transfer {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return append([]*Transfer(nil), ts.list...)
+}
+
+func (ts *Transfers) find(match func(t *Transfer) bool) *Transfer {
+	for _, t := range ts.All() {
+		if match(t) {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// splitDCC splits the arguments of a DCC request, the filename can be quoted if it has spaces.
+func splitDCC(params string) []string {
+	var args []string
+
+	for {
+		params = strings.TrimLeft(params, " ")
+		if params == "" {
+			return args
+		}
+
+		if params[0] == '"' {
+			if end := strings.IndexByte(params[1:], '"'); end >= 0 {
+				args = append(args, params[1:end+1])
+				params = params[end+2:]
+				continue
+			}
+		}
+
+		arg, rest, _ := strings.Cut(params, " ")
+		args = append(args, arg)
+		params = rest
+	}
+}
+
+func quoteFilename(name string) string {
+	if strings.Contains(name, " ") {
+		return `"` + name + `"`
+	}
+
+	return name
+}
+
+// formatDCCIP formats an address the way DCC expects it, IPv4 as a single integer.
+func formatDCCIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(v4)), 10)
+	}
+
+	return ip.String()
+}
+
+func parseDCCIP(value string) net.IP {
+	if n, err := strconv.ParseUint(value, 10, 32); err == nil {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		return ip
+	}
+
+	return net.ParseIP(value)
+}
+
+// safeName strips everything from an offered filename that could put it outside the download directory.
+func safeName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == ".." || name == "/" || name == "" {
+		return "download"
+	}
+
+	return name
+}
+
+// partSuffix marks a file that's still being downloaded, only those are resumed.
+const partSuffix = ".part"
+
+// uniquePath adds a number to the filename if something already exists at path, e.g. "log (1).txt".
+// Paths that would have any of the suffixes added count as taken if that file exists.
+func uniquePath(path string, suffixes ...string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return !errors.Is(err, os.ErrNotExist)
+	}
+
+	for i := 1; ; i++ {
+		free := !exists(path)
+		for _, suffix := range suffixes {
+			free = free && !exists(path+suffix)
+		}
+
+		if free {
+			return path
+		}
+
+		path = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// DefaultDownloadDir is where files are saved unless download-dir is set, ~/Downloads if it exists.
+func DefaultDownloadDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return os.TempDir()
+	}
+
+	downloads := filepath.Join(home, "Downloads")
+	if info, err := os.Stat(downloads); err == nil && info.IsDir() {
+		return downloads
+	}
+
+	return home
+}
+
+// ExpandHome replaces a leading ~ in a path with the home directory.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[1:])
+}
+
+// dccAddress returns the address we tell others to connect to.
+func (c *Client) dccAddress() (net.IP, error) {
+	if c.DCCAddress != "" {
+		if ip := net.ParseIP(c.DCCAddress); ip != nil {
+			return ip, nil
+		}
+
+		ips, err := net.LookupIP(c.DCCAddress)
+		if err != nil || len(ips) == 0 {
+			return nil, fmt.Errorf("couldn't resolve dcc-address %q", c.DCCAddress)
+		}
+
+		return ips[0], nil
+	}
+
+	if c.TCPConn != nil {
+		if addr, ok := c.TCPConn.LocalAddr().(*net.TCPAddr); ok && !addr.IP.IsUnspecified() {
+			return addr.IP, nil
+		}
+	}
+
+	return nil, ErrNoDCCAddress
+}
+
+func (c *Client) sendDCC(nick string, args ...string) {
+	c.SendCommand(commands.PRIVMSG, nick, FormatCTCP("DCC", strings.Join(args, " ")))
+}
+
+// showTransfer draws the transfer's line in its buffer, it's added the first time and updated after that.
+func (c *Client) showTransfer(t *Transfer) {
+	text := t.String()
+
+	t.mu.Lock()
+	t.lastShown = time.Now()
+	line, buffer := t.line, t.buffer
+	t.mu.Unlock()
+
+	if line == nil {
+		line = buffer.AppendMsg(time.Now(), text, MsgFmtOpts{WithTimestamp: true, AsServerMsg: true})
+
+		t.mu.Lock()
+		t.line = line
+		t.mu.Unlock()
+	} else {
+		buffer.History.Update(line, func(line *Line) {
+			line.Content = text
+		})
+	}
+
+	c.notify()
+}
+
+// progress redraws a running transfer, at most once every DCCProgressInterval.
+func (c *Client) progress(t *Transfer) {
+	t.mu.Lock()
+	due := time.Since(t.lastShown) >= DCCProgressInterval
+	t.mu.Unlock()
+
+	if due {
+		c.showTransfer(t)
+	}
+}
+
+func (c *Client) finish(t *Transfer, err error) {
+	t.mu.Lock()
+	if err != nil {
+		t.status = TransferFailed
+		t.err = err
+	} else {
+		t.status = TransferDone
+	}
+
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	t.mu.Unlock()
+
+	c.showTransfer(t)
+}
+
+// Offer is a file or a chat someone offered us over DCC.
+type Offer interface {
+	// Question asks whether to accept the offer
+	Question() string
+}
+
+// AcceptOffer accepts a file or chat offered over DCC.
+func (c *Client) AcceptOffer(offer Offer) error {
+	switch offer := offer.(type) {
+	case *Transfer:
+		return c.AcceptTransfer(offer)
+	case *DirectChat:
+		return c.AcceptChat(offer)
+	}
+
+	return nil
+}
+
+// RejectOffer turns down a file or chat offered over DCC.
+func (c *Client) RejectOffer(offer Offer) {
+	switch offer := offer.(type) {
+	case *Transfer:
+		c.RejectTransfer(offer)
+	case *DirectChat:
+		c.RejectChat(offer)
+	}
+}
+
+// HandleDCC handles a DCC request from nick. It returns the offer if it's a new file or chat
+// that should be accepted or rejected, the rest of the handshake is taken care of here.
+func (c *Client) HandleDCC(nick string, params string) (Offer, error) {
+	args := splitDCC(params)
+	if len(args) == 0 {
+		return nil, errInvalidDCC
+	}
+
+	switch strings.ToUpper(args[0]) {
+	// a nil pointer would make a non-nil Offer
+	case "SEND":
+		t, err := c.handleDCCSend(nick, args[1:])
+		if t == nil {
+			return nil, err
+		}
+
+		return t, err
+	case "CHAT":
+		chat, err := c.handleDCCChat(nick, args[1:])
+		if chat == nil {
+			return nil, err
+		}
+
+		return chat, err
+	case "RESUME":
+		return nil, c.handleDCCResume(nick, args[1:])
+	case "ACCEPT":
+		return nil, c.handleDCCAccept(nick, args[1:])
+	case "REJECT":
+		c.handleDCCReject(nick, args[1:])
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unsupported DCC request %s from %s", args[0], nick)
+}
+
+// DCC SEND <filename> <ip> <port> <size> [token]
+func (c *Client) handleDCCSend(nick string, args []string) (*Transfer, error) {
+	if len(args) < 3 {
+		return nil, errInvalidDCC
+	}
+
+	ip := parseDCCIP(args[1])
+	port, err := strconv.Atoi(args[2])
+	if ip == nil || err != nil {
+		return nil, errInvalidDCC
+	}
+
+	var size int64
+	if len(args) > 3 {
+		size, _ = strconv.ParseInt(args[3], 10, 64)
+	}
+
+	token := ""
+	if len(args) > 4 {
+		token = args[4]
+	}
+
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+
+	// the receiver of a passive offer we made telling us where to connect
+	if port != 0 && token != "" {
+		t := c.Transfers.find(func(t *Transfer) bool {
+			return t.Sending && t.Passive && t.Token == token && c.Casefold(t.Nick) == c.Casefold(nick)
+		})
+
+		if t != nil && t.Status() == TransferOffered {
+			t.mu.Lock()
+			t.addr = addr
+			t.mu.Unlock()
+
+			go c.connectAndSend(t)
+			return nil, nil
+		}
+	}
+
+	if port == 0 && token == "" {
+		return nil, errInvalidDCC
+	}
+
+	t := c.Transfers.add(&Transfer{
+		Nick:     nick,
+		Filename: args[0],
+		Size:     size,
+		Passive:  port == 0,
+		Token:    token,
+		addr:     addr,
+		buffer:   c.RootChannel,
+	})
+	c.showTransfer(t)
+
+	return t, nil
+}
+
+// matchResume finds the transfer a RESUME or ACCEPT is about, by token for passive transfers and by port otherwise.
+func (c *Client) matchResume(nick string, sending bool, args []string) *Transfer {
+	if len(args) < 3 {
+		return nil
+	}
+
+	token := ""
+	if len(args) > 3 {
+		token = args[3]
+	}
+
+	return c.Transfers.find(func(t *Transfer) bool {
+		if t.Sending != sending || c.Casefold(t.Nick) != c.Casefold(nick) || t.Status() != TransferOffered {
+			return false
+		}
+
+		if t.Passive {
+			return t.Token == token
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		port := ""
+		if t.listener != nil {
+			_, port, _ = net.SplitHostPort(t.listener.Addr().String())
+		} else {
+			_, port, _ = net.SplitHostPort(t.addr)
+		}
+
+		return port == args[1]
+	})
+}
+
+// DCC RESUME <filename> <port> <position> [token]
+func (c *Client) handleDCCResume(nick string, args []string) error {
+	t := c.matchResume(nick, true, args)
+	if t == nil {
+		return errInvalidDCC
+	}
+
+	position, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || position < 0 || position >= t.Size {
+		return errInvalidDCC
+	}
+
+	t.mu.Lock()
+	t.transferred = position
+	t.mu.Unlock()
+
+	c.sendDCC(nick, append([]string{"ACCEPT", quoteFilename(args[0])}, args[1:]...)...)
+	return nil
+}
+
+// DCC ACCEPT <filename> <port> <position> [token]
+func (c *Client) handleDCCAccept(nick string, args []string) error {
+	t := c.matchResume(nick, false, args)
+	if t == nil {
+		return errInvalidDCC
+	}
+
+	position, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || position < 0 {
+		return errInvalidDCC
+	}
+
+	t.mu.Lock()
+	resuming := t.resuming
+	t.resuming = false
+	t.mu.Unlock()
+
+	// the resume already timed out
+	if !resuming {
+		return errInvalidDCC
+	}
+
+	return c.startReceive(t, position)
+}
+
+// DCC REJECT SEND <filename>
+// DCC REJECT CHAT chat
+func (c *Client) handleDCCReject(nick string, args []string) {
+	if len(args) < 2 {
+		return
+	}
+
+	if strings.ToUpper(args[0]) == "CHAT" {
+		c.chatRejected(nick)
+		return
+	}
+
+	if strings.ToUpper(args[0]) != "SEND" {
+		return
+	}
+
+	t := c.Transfers.find(func(t *Transfer) bool {
+		return t.Sending && t.Filename == args[1] && c.Casefold(t.Nick) == c.Casefold(nick) && t.Status() == TransferOffered
+	})
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.status = TransferRejected
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	t.mu.Unlock()
+
+	c.showTransfer(t)
+}
+
+// AcceptTransfer starts receiving an offered file into the download directory.
+// If an earlier download of it didn't finish the sender is asked to resume it.
+func (c *Client) AcceptTransfer(t *Transfer) error {
+	t.mu.Lock()
+	waiting := !t.Sending && t.status == TransferOffered && !t.resuming
+	t.mu.Unlock()
+
+	if !waiting {
+		return fmt.Errorf("DCC #%d isn't waiting to be accepted", t.ID)
+	}
+
+	dir := ExpandHome(c.DownloadDir)
+	if dir == "" {
+		dir = DefaultDownloadDir()
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, safeName(t.Filename))
+
+	// only a download of ours that didn't finish is resumed, never a file that just has the same name
+	info, err := os.Stat(path + partSuffix)
+	inUse := c.Transfers.find(func(other *Transfer) bool {
+		return other != t && !other.Sending && other.Path == path && other.Status() == TransferActive
+	}) != nil
+
+	if err == nil && info.Size() > 0 && info.Size() < t.Size && !inUse {
+		port := "0"
+		if !t.Passive {
+			_, port, _ = net.SplitHostPort(t.addr)
+		}
+
+		t.mu.Lock()
+		t.Path = path
+		t.resuming = true
+		t.transferred = info.Size()
+		t.mu.Unlock()
+
+		args := []string{"RESUME", quoteFilename(t.Filename), port, strconv.FormatInt(info.Size(), 10)}
+		if t.Token != "" {
+			args = append(args, t.Token)
+		}
+
+		c.sendDCC(t.Nick, args...)
+		c.showTransfer(t)
+
+		// not every client answers a RESUME
+		time.AfterFunc(DCCTimeout, func() {
+			t.mu.Lock()
+			resuming := t.resuming
+			t.resuming = false
+			t.mu.Unlock()
+
+			if resuming {
+				c.finish(t, errors.New("timed out waiting for them to resume"))
+			}
+		})
+
+		return nil
+	}
+
+	t.mu.Lock()
+	t.Path = uniquePath(path, partSuffix)
+	t.mu.Unlock()
+
+	return c.startReceive(t, 0)
+}
+
+// RejectTransfer turns down an offered file and lets the sender know.
+func (c *Client) RejectTransfer(t *Transfer) {
+	if t.Sending || t.Status() != TransferOffered {
+		return
+	}
+
+	t.mu.Lock()
+	t.status = TransferRejected
+	t.mu.Unlock()
+
+	c.SendCommand(commands.NOTICE, t.Nick, FormatCTCP("DCC", "REJECT SEND "+quoteFilename(t.Filename)))
+	c.showTransfer(t)
+}
+
+// startReceive connects to the sender, or listens for them to connect if the offer was passive.
+func (c *Client) startReceive(t *Transfer, offset int64) error {
+	t.mu.Lock()
+	t.status = TransferActive
+	t.transferred = offset
+	t.mu.Unlock()
+
+	if !t.Passive {
+		go func() {
+			conn, err := net.DialTimeout("tcp", t.addr, DCCTimeout)
+			if err != nil {
+				c.finish(t, err)
+				return
+			}
+
+			c.runReceive(t, conn, offset)
+		}()
+
+		c.showTransfer(t)
+		return nil
+	}
+
+	ip, err := c.dccAddress()
+	if err != nil {
+		c.finish(t, err)
+		return err
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		c.finish(t, err)
+		return err
+	}
+
+	t.mu.Lock()
+	t.listener = listener
+	t.mu.Unlock()
+
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	c.sendDCC(t.Nick, "SEND", quoteFilename(t.Filename), formatDCCIP(ip), port, strconv.FormatInt(t.Size, 10), t.Token)
+
+	go func() {
+		conn, err := acceptDCC(listener)
+		if err != nil {
+			c.finish(t, err)
+			return
+		}
+
+		c.runReceive(t, conn, offset)
+	}()
+
+	c.showTransfer(t)
+	return nil
+}
+
+// acceptDCC waits for the other side to connect to our listener and closes it after.
+func acceptDCC(listener net.Listener) (net.Conn, error) {
+	defer listener.Close()
+
+	if tcp, ok := listener.(*net.TCPListener); ok {
+		tcp.SetDeadline(time.Now().Add(DCCTimeout))
+	}
+
+	return listener.Accept()
+}
+
+func (c *Client) runReceive(t *Transfer, conn net.Conn, offset int64) {
+	defer conn.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	// the file is downloaded next to where it goes and only moved there once it's complete
+	part := t.Path + partSuffix
+
+	file, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		c.finish(t, err)
+		return
+	}
+
+	// anything past where we resumed from is thrown away
+	if offset > 0 {
+		err = file.Truncate(offset)
+	}
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		err = c.receive(t, conn, file)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		// something might have been saved under the same name meanwhile
+		t.mu.Lock()
+		t.Path = uniquePath(t.Path)
+		t.mu.Unlock()
+
+		err = os.Rename(part, t.Path)
+	}
+
+	c.finish(t, err)
+}
+
+// receive copies the file from conn to w, acknowledging every block with how much we've got so far.
+func (c *Client) receive(t *Transfer, conn net.Conn, w io.Writer) error {
+	buf := make([]byte, dccBlockSize)
+	ack := make([]byte, 4)
+
+	for !t.complete() {
+		conn.SetReadDeadline(time.Now().Add(DCCIdleTimeout))
+
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			// acks are the total modulo 2^32 for files over 4 GB
+			total := t.advance(int64(n))
+			binary.BigEndian.PutUint32(ack, uint32(total))
+			if _, err := conn.Write(ack); err != nil && !t.complete() {
+				return err
+			}
+
+			c.progress(t)
+		}
+
+		if err == io.EOF {
+			if t.Size > 0 && !t.complete() {
+				return io.ErrUnexpectedEOF
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SendFile offers a file to nick. A passive offer has them listen for us instead,
+// for when we're behind a NAT and can't accept connections.
+func (c *Client) SendFile(nick string, path string, passive bool) (*Transfer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	ip, err := c.dccAddress()
+	if err != nil && !passive {
+		return nil, err
+	}
+
+	// the receiver connects to the address in their reply, ours isn't used
+	address := "0"
+	if ip != nil {
+		address = formatDCCIP(ip)
+	}
+
+	t := c.Transfers.add(&Transfer{
+		Nick:     nick,
+		Filename: filepath.Base(path),
+		Path:     path,
+		Size:     info.Size(),
+		Sending:  true,
+		Passive:  passive,
+		buffer:   c.ActiveChannel,
+	})
+
+	size := strconv.FormatInt(t.Size, 10)
+
+	if passive {
+		t.Token = strconv.Itoa(t.ID)
+		c.sendDCC(nick, "SEND", quoteFilename(t.Filename), address, "0", size, t.Token)
+		c.showTransfer(t)
+
+		time.AfterFunc(DCCTimeout, func() {
+			if t.Status() == TransferOffered {
+				c.finish(t, errors.New("timed out waiting for them to accept"))
+			}
+		})
+
+		return t, nil
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.listener = listener
+	t.mu.Unlock()
+
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	c.sendDCC(nick, "SEND", quoteFilename(t.Filename), address, port, size)
+	c.showTransfer(t)
+
+	go func() {
+		conn, err := acceptDCC(listener)
+		if err != nil {
+			if t.Status() == TransferOffered {
+				c.finish(t, err)
+			}
+			return
+		}
+
+		c.runSend(t, conn)
+	}()
+
+	return t, nil
+}
+
+func (c *Client) connectAndSend(t *Transfer) {
+	conn, err := net.DialTimeout("tcp", t.addr, DCCTimeout)
+	if err != nil {
+		c.finish(t, err)
+		return
+	}
+
+	c.runSend(t, conn)
+}
+
+func (c *Client) runSend(t *Transfer, conn net.Conn) {
+	defer conn.Close()
+
+	t.mu.Lock()
+	t.status = TransferActive
+	offset := t.transferred
+	t.mu.Unlock()
+	c.showTransfer(t)
+
+	file, err := os.Open(t.Path)
+	if err != nil {
+		c.finish(t, err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		c.finish(t, err)
+		return
+	}
+
+	c.finish(t, c.send(t, conn, file))
+}
+
+// readAck waits for the receiver to acknowledge want bytes.
+func readAck(conn net.Conn, want uint32) error {
+	ack := make([]byte, 4)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(DCCIdleTimeout))
+		if _, err := io.ReadFull(conn, ack); err != nil {
+			return err
+		}
+
+		if binary.BigEndian.Uint32(ack) == want {
+			return nil
+		}
+	}
+}
+
+// send copies the file from r to conn. With DCCWaitAcks every block waits for the receiver to acknowledge it,
+// otherwise we send ahead and only wait for the last acknowledgement so closing doesn't cut the file short.
+func (c *Client) send(t *Transfer, conn net.Conn, r io.Reader) error {
+	var acked atomic.Uint32
+	ackedCh := make(chan struct{}, 1)
+	closed := make(chan struct{})
+
+	if !c.DCCWaitAcks {
+		// acks have to be read as they come or they'd fill up the connection
+		go func() {
+			defer close(closed)
+
+			ack := make([]byte, 4)
+			for {
+				if _, err := io.ReadFull(conn, ack); err != nil {
+					return
+				}
+
+				acked.Store(binary.BigEndian.Uint32(ack))
+				select {
+				case ackedCh <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+
+	buf := make([]byte, dccBlockSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			conn.SetWriteDeadline(time.Now().Add(DCCIdleTimeout))
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			total := t.advance(int64(n))
+			c.progress(t)
+
+			if c.DCCWaitAcks {
+				if err := readAck(conn, uint32(total)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.DCCWaitAcks {
+		return nil
+	}
+
+	want := uint32(t.Transferred())
+	timeout := time.After(DCCIdleTimeout)
+	for acked.Load() != want {
+		select {
+		case <-ackedCh:
+		case <-closed:
+			// some clients close once they have everything instead of acknowledging it
+			return nil
+		case <-timeout:
+			return errors.New("timed out waiting for the receiver to acknowledge everything")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,308 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readDCC reads the next DCC request a client sent and returns its parameters.
+func readDCC(t *testing.T, server *bufio.Reader) string {
+	t.Helper()
+
+	line, err := server.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := strings.Index(line, ctcpDelim)
+	if i < 0 {
+		t.Fatalf("Expected a CTCP, got %q", line)
+	}
+
+	ctcp, ok := ParseCTCP(strings.TrimSuffix(line[i:], CRLF))
+	if !ok || ctcp.Command != "DCC" {
+		t.Fatalf("Expected a DCC request, got %q", line)
+	}
+
+	return ctcp.Params
+}
+
+// waitTransfer waits for a transfer to finish and fails if it didn't go through.
+func waitTransfer(t *testing.T, transfer *Transfer) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for transfer.Status() == TransferOffered || transfer.Status() == TransferActive {
+		if time.Now().After(deadline) {
+			t.Fatal("Transfer didn't finish:", transfer)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if transfer.Status() != TransferDone {
+		t.Fatal("Transfer failed:", transfer)
+	}
+}
+
+// newDCCClients returns a sender and a receiver that offer the loopback address,
+// and a file for the sender to send.
+func newDCCClients(t *testing.T) (*Client, *bufio.Reader, *Client, *bufio.Reader, string, []byte) {
+	sender, senderServer := newTestClient(t)
+	sender.Nickname = "alice"
+	sender.DCCAddress = "127.0.0.1"
+
+	receiver, receiverServer := newTestClient(t)
+	receiver.Nickname = "bob"
+	receiver.DCCAddress = "127.0.0.1"
+	receiver.DownloadDir = t.TempDir()
+
+	content := bytes.Repeat([]byte("gorc dcc test "), 10000)
+	path := filepath.Join(t.TempDir(), "log file.txt")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return sender, senderServer, receiver, receiverServer, path, content
+}
+
+// receiveOffer hands a DCC request to a client and returns the file it offers.
+func receiveOffer(t *testing.T, client *Client, nick string, params string) *Transfer {
+	t.Helper()
+
+	offer, err := client.HandleDCC(nick, params)
+	transfer, ok := offer.(*Transfer)
+	if err != nil || !ok {
+		t.Fatal("Offer wasn't parsed:", err)
+	}
+
+	return transfer
+}
+
+func checkDownload(t *testing.T, transfer *Transfer, content []byte) {
+	t.Helper()
+
+	received, err := os.ReadFile(transfer.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received, content) {
+		t.Fatalf("Received file differs, got %d bytes of %d", len(received), len(content))
+	}
+}
+
+func TestDCC(t *testing.T) {
+	t.Run("Test parsing", func(t *testing.T) {
+		args := splitDCC(`SEND "log file.txt" 2130706433 5000 1024 7`)
+		if len(args) != 6 || args[1] != "log file.txt" || args[5] != "7" {
+			t.Fatal("Quoted filename wasn't parsed:", args)
+		}
+
+		ip := parseDCCIP("2130706433")
+		if ip.String() != "127.0.0.1" || formatDCCIP(ip) != "2130706433" {
+			t.Fatal("Wrong IPv4 conversion:", ip)
+		}
+
+		if parseDCCIP("::1").String() != "::1" {
+			t.Fatal("IPv6 addresses should be passed as is")
+		}
+
+		if safeName("../../.bashrc") != ".bashrc" || safeName(`C:\evil\file.txt`) != "file.txt" || safeName("..") != "download" {
+			t.Fatal("Offered filenames should be kept in the download directory")
+		}
+	})
+
+	t.Run("Test send and receive", func(t *testing.T) {
+		sender, senderServer, receiver, _, path, content := newDCCClients(t)
+
+		go sender.SendFile("bob", path, false)
+		offer := receiveOffer(t, receiver, "alice", readDCC(t, senderServer))
+
+		if offer.Filename != "log file.txt" || offer.Size != int64(len(content)) {
+			t.Fatal("Wrong offer:", offer)
+		}
+
+		if err := receiver.AcceptTransfer(offer); err != nil {
+			t.Fatal(err)
+		}
+
+		waitTransfer(t, offer)
+		checkDownload(t, offer, content)
+
+		sent := sender.Transfers.Get(1)
+		waitTransfer(t, sent)
+		if sent.Transferred() != int64(len(content)) {
+			t.Fatal("Sender didn't send everything:", sent)
+		}
+	})
+
+	t.Run("Test resume", func(t *testing.T) {
+		sender, senderServer, receiver, receiverServer, path, content := newDCCClients(t)
+		sender.DCCWaitAcks = true
+
+		partial := filepath.Join(receiver.DownloadDir, "log file.txt.part")
+		if err := os.WriteFile(partial, content[:5000], 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		// saved under the same name since, the download doesn't replace it
+		unrelated := filepath.Join(receiver.DownloadDir, "log file.txt")
+		if err := os.WriteFile(unrelated, []byte("notes"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		go sender.SendFile("bob", path, false)
+		offer := receiveOffer(t, receiver, "alice", readDCC(t, senderServer))
+
+		accepted := make(chan error)
+		go func() { accepted <- receiver.AcceptTransfer(offer) }()
+
+		resume := readDCC(t, receiverServer)
+		if err := <-accepted; err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(resume, `RESUME "log file.txt"`) || !strings.HasSuffix(resume, " 5000") {
+			t.Fatal("Wrong resume request:", resume)
+		}
+
+		go sender.HandleDCC("bob", resume)
+		accept := readDCC(t, senderServer)
+		if _, err := receiver.HandleDCC("alice", accept); err != nil {
+			t.Fatal("Accept wasn't handled:", err)
+		}
+
+		waitTransfer(t, offer)
+		checkDownload(t, offer, content)
+
+		if filepath.Base(offer.Path) != "log file (1).txt" {
+			t.Fatal("Download wasn't saved under a new name:", offer.Path)
+		}
+
+		if notes, _ := os.ReadFile(unrelated); string(notes) != "notes" {
+			t.Fatal("Existing file was changed")
+		}
+
+		if _, err := os.Stat(partial); !errors.Is(err, os.ErrNotExist) {
+			t.Fatal("Partial file was left behind")
+		}
+	})
+
+	t.Run("Test not resuming other files", func(t *testing.T) {
+		sender, senderServer, receiver, _, path, content := newDCCClients(t)
+
+		existing := filepath.Join(receiver.DownloadDir, "log file.txt")
+		if err := os.WriteFile(existing, []byte("notes"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		go sender.SendFile("bob", path, false)
+		offer := receiveOffer(t, receiver, "alice", readDCC(t, senderServer))
+
+		if err := receiver.AcceptTransfer(offer); err != nil {
+			t.Fatal(err)
+		}
+
+		waitTransfer(t, offer)
+		checkDownload(t, offer, content)
+
+		if notes, _ := os.ReadFile(existing); string(notes) != "notes" {
+			t.Fatal("Existing file was resumed")
+		}
+	})
+
+	t.Run("Test resume timeout", func(t *testing.T) {
+		timeout := DCCTimeout
+		DCCTimeout = 50 * time.Millisecond
+		t.Cleanup(func() { DCCTimeout = timeout })
+
+		_, _, receiver, receiverServer, _, content := newDCCClients(t)
+
+		partial := filepath.Join(receiver.DownloadDir, "log file.txt.part")
+		if err := os.WriteFile(partial, content[:5000], 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		// nobody is sending, the offer is only there to be resumed
+		offer := receiveOffer(t, receiver, "alice", fmt.Sprintf(`SEND "log file.txt" 2130706433 5000 %d`, len(content)))
+
+		accepted := make(chan error)
+		go func() { accepted <- receiver.AcceptTransfer(offer) }()
+
+		readDCC(t, receiverServer)
+		if err := <-accepted; err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for offer.Status() != TransferFailed {
+			if time.Now().After(deadline) {
+				t.Fatal("Resume never timed out:", offer)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("Test passive send", func(t *testing.T) {
+		sender, senderServer, receiver, receiverServer, path, content := newDCCClients(t)
+
+		go sender.SendFile("bob", path, true)
+		params := readDCC(t, senderServer)
+		if fields := strings.Fields(params); fields[4] != "0" {
+			t.Fatal("Passive offer should have port 0:", params)
+		}
+
+		offer := receiveOffer(t, receiver, "alice", params)
+		if !offer.Passive {
+			t.Fatal("Offer should be passive")
+		}
+
+		go receiver.AcceptTransfer(offer)
+		reply := readDCC(t, receiverServer)
+
+		if transfer, err := sender.HandleDCC("bob", reply); transfer != nil || err != nil {
+			t.Fatal("The reply to a passive offer should start the transfer, not make a new one")
+		}
+
+		waitTransfer(t, offer)
+		checkDownload(t, offer, content)
+	})
+
+	t.Run("Test reject", func(t *testing.T) {
+		sender, senderServer, receiver, receiverServer, path, _ := newDCCClients(t)
+
+		go sender.SendFile("bob", path, false)
+		offer := receiveOffer(t, receiver, "alice", readDCC(t, senderServer))
+
+		go receiver.RejectTransfer(offer)
+		reply := readDCC(t, receiverServer)
+		sender.HandleDCC("bob", reply)
+
+		if sender.Transfers.Get(1).Status() != TransferRejected || offer.Status() != TransferRejected {
+			t.Fatal("Both sides should see the transfer as rejected")
+		}
+	})
+}
@@ -0,0 +1,450 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+var ErrChatNotConnected = errors.New("the direct chat isn't connected")
+
+type ChatStatus int
+
+const (
+	// Waiting to be accepted, or for the other side to connect
+	ChatOffered ChatStatus = iota
+	ChatConnected
+	ChatClosed
+)
+
+// DirectChat is a chat with someone over DCC, messages go straight to them instead of through the server.
+// (https://modern.ircdocs.horse/dcc#dcc-chat)
+type DirectChat struct {
+	Nick string
+
+	// Whether they offered the chat
+	Incoming bool
+
+	// Passive chats are set up by whoever accepts listening, the token tells them apart
+	Passive bool
+	Token   string
+
+	mu       sync.Mutex
+	status   ChatStatus
+	conn     net.Conn
+	addr     string
+	listener net.Listener
+
+	// the buffer the chat is shown in once it's accepted
+	buffer *Channel
+}
+
+func (d *DirectChat) Status() ChatStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.status
+}
+
+// Question asks whether to accept the chat.
+func (d *DirectChat) Question() string {
+	return fmt.Sprintf("Accept a direct chat with %s?", d.Nick)
+}
+
+type directChats struct {
+	mu     sync.Mutex
+	list   []*DirectChat
+	tokens int
+}
+
+func (dc *directChats) add(d *DirectChat) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.list = append(dc.list, d)
+}
+
+func (dc *directChats) find(match func(d *DirectChat) bool) *DirectChat {
+	dc.mu.Lock()
+	list := append([]*DirectChat(nil), dc.list...)
+	dc.mu.Unlock()
+
+	for i := len(list) - 1; i >= 0; i-- {
+		if match(list[i]) {
+			return list[i]
+		}
+	}
+
+	return nil
+}
+
+func (dc *directChats) remove(d *DirectChat) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	for i, chat := range dc.list {
+		if chat == d {
+			dc.list = append(dc.list[:i], dc.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// IsDirect reports whether the buffer is a DCC chat rather than a channel or query on the server.
+func (c *Channel) IsDirect() bool {
+	return c.Direct != nil
+}
+
+// DirectName is the name of the buffer of a direct chat with nick, it can't clash with a query.
+func DirectName(nick string) string {
+	return "=" + nick
+}
+
+func (c *Client) notify() {
+	if c.Notify != nil {
+		c.Notify()
+	}
+}
+
+// PendingChat returns the chat nick offered us that we haven't answered yet, or nil if there's none.
+func (c *Client) PendingChat(nick string) *DirectChat {
+	return c.chats.find(func(d *DirectChat) bool {
+		return d.Incoming && c.Casefold(d.Nick) == c.Casefold(nick) && d.Status() == ChatOffered && d.buffer == nil
+	})
+}
+
+// directBuffer opens the buffer of a chat, a buffer left over from an earlier chat with the same nick is reused.
+func (c *Client) directBuffer(d *DirectChat) *Channel {
+	name := DirectName(d.Nick)
+
+	buffer := c.Buffers.Get(name)
+	if buffer == nil {
+		buffer = NewChannel(name)
+		buffer.Users[d.Nick] = User{}
+		c.AppendChannel(buffer)
+	}
+
+	buffer.Direct = d
+
+	d.mu.Lock()
+	d.buffer = buffer
+	d.mu.Unlock()
+
+	return buffer
+}
+
+func (c *Client) showChat(d *DirectChat, text string, opts MsgFmtOpts) {
+	d.mu.Lock()
+	buffer := d.buffer
+	d.mu.Unlock()
+
+	if buffer == nil {
+		buffer = c.RootChannel
+	}
+
+	opts.WithTimestamp = true
+	buffer.AppendMsg(time.Now(), text, opts)
+	c.notify()
+}
+
+// OfferChat offers nick a direct chat and opens its buffer. A passive offer has them listen for us instead.
+func (c *Client) OfferChat(nick string, passive bool) (*Channel, error) {
+	ip, err := c.dccAddress()
+	if err != nil && !passive {
+		return nil, err
+	}
+
+	address := "0"
+	if ip != nil {
+		address = formatDCCIP(ip)
+	}
+
+	d := &DirectChat{Nick: nick, Passive: passive}
+	c.chats.add(d)
+
+	if passive {
+		c.chats.mu.Lock()
+		c.chats.tokens++
+		d.Token = "c" + strconv.Itoa(c.chats.tokens)
+		c.chats.mu.Unlock()
+
+		buffer := c.directBuffer(d)
+		c.sendDCC(nick, "CHAT", "chat", address, "0", d.Token)
+		c.showChat(d, "Offered a direct chat to "+nick+", waiting for them to accept", MsgFmtOpts{AsServerMsg: true})
+
+		time.AfterFunc(DCCTimeout, func() {
+			if d.Status() == ChatOffered {
+				c.closeChat(d, nick+" didn't accept the direct chat")
+			}
+		})
+
+		return buffer, nil
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	d.listener = listener
+
+	buffer := c.directBuffer(d)
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	c.sendDCC(nick, "CHAT", "chat", address, port)
+	c.showChat(d, "Offered a direct chat to "+nick+", waiting for them to accept", MsgFmtOpts{AsServerMsg: true})
+
+	go func() {
+		conn, err := acceptDCC(listener)
+		if err != nil {
+			if d.Status() == ChatOffered {
+				c.closeChat(d, "Direct chat with "+nick+" wasn't accepted: "+err.Error())
+			}
+			return
+		}
+
+		c.chatConnected(d, conn)
+	}()
+
+	return buffer, nil
+}
+
+// DCC CHAT chat <ip> <port> [token]
+func (c *Client) handleDCCChat(nick string, args []string) (*DirectChat, error) {
+	if len(args) < 3 || !strings.EqualFold(args[0], "chat") {
+		return nil, errInvalidDCC
+	}
+
+	ip := parseDCCIP(args[1])
+	port, err := strconv.Atoi(args[2])
+	if ip == nil || err != nil {
+		return nil, errInvalidDCC
+	}
+
+	token := ""
+	if len(args) > 3 {
+		token = args[3]
+	}
+
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+
+	// the answer to a passive offer we made telling us where to connect
+	if port != 0 && token != "" {
+		d := c.chats.find(func(d *DirectChat) bool {
+			return !d.Incoming && d.Passive && d.Token == token && c.Casefold(d.Nick) == c.Casefold(nick)
+		})
+
+		if d != nil && d.Status() == ChatOffered {
+			go c.dialChat(d, addr)
+			return nil, nil
+		}
+	}
+
+	if port == 0 && token == "" {
+		return nil, errInvalidDCC
+	}
+
+	d := &DirectChat{
+		Nick:     nick,
+		Incoming: true,
+		Passive:  port == 0,
+		Token:    token,
+		addr:     addr,
+	}
+	c.chats.add(d)
+
+	c.showChat(d, nick+" offers a direct chat, /dcc chat "+nick+" to accept it", MsgFmtOpts{AsServerMsg: true})
+
+	return d, nil
+}
+
+// AcceptChat opens the buffer of a chat we were offered and connects to them,
+// or listens for them to connect if the offer was passive.
+func (c *Client) AcceptChat(d *DirectChat) error {
+	d.mu.Lock()
+	waiting := d.Incoming && d.status == ChatOffered && d.buffer == nil
+	d.mu.Unlock()
+
+	if !waiting {
+		return fmt.Errorf("the direct chat with %s isn't waiting to be accepted", d.Nick)
+	}
+
+	c.directBuffer(d)
+	c.ActiveChannel = d.buffer
+
+	if !d.Passive {
+		c.showChat(d, "Connecting to "+d.Nick+"…", MsgFmtOpts{AsServerMsg: true})
+		go c.dialChat(d, d.addr)
+		return nil
+	}
+
+	ip, err := c.dccAddress()
+	if err != nil {
+		c.closeChat(d, "Couldn't accept the direct chat: "+err.Error())
+		return err
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		c.closeChat(d, "Couldn't accept the direct chat: "+err.Error())
+		return err
+	}
+
+	d.mu.Lock()
+	d.listener = listener
+	d.mu.Unlock()
+
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	c.sendDCC(d.Nick, "CHAT", "chat", formatDCCIP(ip), port, d.Token)
+	c.showChat(d, "Waiting for "+d.Nick+" to connect…", MsgFmtOpts{AsServerMsg: true})
+
+	go func() {
+		conn, err := acceptDCC(listener)
+		if err != nil {
+			if d.Status() == ChatOffered {
+				c.closeChat(d, d.Nick+" didn't connect: "+err.Error())
+			}
+			return
+		}
+
+		c.chatConnected(d, conn)
+	}()
+
+	return nil
+}
+
+// RejectChat turns down a chat we were offered and lets them know.
+func (c *Client) RejectChat(d *DirectChat) {
+	if !d.Incoming || d.Status() != ChatOffered {
+		return
+	}
+
+	d.mu.Lock()
+	d.status = ChatClosed
+	d.mu.Unlock()
+	c.chats.remove(d)
+
+	c.SendCommand(commands.NOTICE, d.Nick, FormatCTCP("DCC", "REJECT CHAT chat"))
+	c.showChat(d, "Rejected the direct chat with "+d.Nick, MsgFmtOpts{AsServerMsg: true})
+}
+
+// chatRejected closes the chat we offered nick after they turned it down.
+func (c *Client) chatRejected(nick string) {
+	d := c.chats.find(func(d *DirectChat) bool {
+		return !d.Incoming && c.Casefold(d.Nick) == c.Casefold(nick) && d.Status() == ChatOffered
+	})
+
+	if d != nil {
+		c.closeChat(d, nick+" rejected the direct chat")
+	}
+}
+
+func (c *Client) dialChat(d *DirectChat, addr string) {
+	conn, err := net.DialTimeout("tcp", addr, DCCTimeout)
+	if err != nil {
+		c.closeChat(d, "Couldn't connect to "+d.Nick+": "+err.Error())
+		return
+	}
+
+	c.chatConnected(d, conn)
+}
+
+// chatConnected starts reading the chat's messages into its buffer.
+func (c *Client) chatConnected(d *DirectChat, conn net.Conn) {
+	d.mu.Lock()
+	if d.status != ChatOffered {
+		d.mu.Unlock()
+		conn.Close()
+		return
+	}
+
+	d.status = ChatConnected
+	d.conn = conn
+	d.mu.Unlock()
+
+	c.showChat(d, "Connected to "+d.Nick+" ("+conn.RemoteAddr().String()+")", MsgFmtOpts{AsServerMsg: true})
+
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			text := strings.TrimSuffix(scanner.Text(), "\r")
+			c.showChat(d, FormatPrivMsg(d.Nick, text), MsgFmtOpts{})
+		}
+
+		c.closeChat(d, d.Nick+" closed the direct chat")
+	}()
+}
+
+// closeChat closes the chat's connection and says why in its buffer, the buffer itself stays open.
+func (c *Client) closeChat(d *DirectChat, reason string) {
+	d.mu.Lock()
+	if d.status == ChatClosed {
+		d.mu.Unlock()
+		return
+	}
+
+	d.status = ChatClosed
+	if d.conn != nil {
+		d.conn.Close()
+	}
+	if d.listener != nil {
+		d.listener.Close()
+	}
+	d.mu.Unlock()
+
+	c.chats.remove(d)
+	c.showChat(d, reason, MsgFmtOpts{AsServerMsg: true})
+}
+
+// CloseChat ends the direct chat of a buffer.
+func (c *Client) CloseChat(buffer *Channel) {
+	if buffer.Direct != nil {
+		c.closeChat(buffer.Direct, "Closed the direct chat with "+buffer.Direct.Nick)
+	}
+}
+
+// sendDirect sends a message over the buffer's DCC chat, a line at a time.
+func (c *Client) sendDirect(buffer *Channel, text string) {
+	d := buffer.Direct
+
+	d.mu.Lock()
+	conn := d.conn
+	connected := d.status == ChatConnected
+	d.mu.Unlock()
+
+	if !connected {
+		buffer.AppendMsg(time.Now(), ErrChatNotConnected.Error(), MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true})
+		return
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		conn.SetWriteDeadline(time.Now().Add(DCCIdleTimeout))
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			c.closeChat(d, "Lost the direct chat with "+d.Nick+": "+err.Error())
+			return
+		}
+
+		buffer.AppendMsg(time.Now(), FormatPrivMsg(c.Nickname, line), MsgFmtOpts{WithTimestamp: true})
+	}
+}
@@ -0,0 +1,146 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+// waitFor polls until check is true and fails after a few seconds.
+func waitFor(t *testing.T, what string, check func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// receiveChat hands a DCC request to a client and returns the chat it offers.
+func receiveChat(t *testing.T, client *Client, nick string, params string) *DirectChat {
+	t.Helper()
+
+	offer, err := client.HandleDCC(nick, params)
+	chat, ok := offer.(*DirectChat)
+	if err != nil || !ok {
+		t.Fatal("Chat offer wasn't parsed:", err)
+	}
+
+	return chat
+}
+
+func lastLine(buffer *Channel) string {
+	line := buffer.History.At(buffer.History.Len() - 1)
+	if line == nil {
+		return ""
+	}
+
+	return line.Content
+}
+
+func TestDirectChat(t *testing.T) {
+	t.Run("Test chatting", func(t *testing.T) {
+		alice, aliceServer, bob, _, _, _ := newDCCClients(t)
+
+		buffers := make(chan *Channel, 1)
+		go func() {
+			buffer, _ := alice.OfferChat("bob", false)
+			buffers <- buffer
+		}()
+		chat := receiveChat(t, bob, "alice", readDCC(t, aliceServer))
+		aliceBuffer := <-buffers
+
+		if bob.PendingChat("ALICE") != chat {
+			t.Fatal("Offer should be pending until it's answered")
+		}
+
+		if err := bob.AcceptChat(chat); err != nil {
+			t.Fatal(err)
+		}
+
+		bobBuffer := bob.Buffers.Get(DirectName("alice"))
+		if bobBuffer == nil || !bobBuffer.IsDirect() || bob.ActiveChannel != bobBuffer {
+			t.Fatal("Accepting should open a direct buffer")
+		}
+
+		waitFor(t, "the chat to connect", func() bool {
+			return chat.Status() == ChatConnected && aliceBuffer.Direct.Status() == ChatConnected
+		})
+
+		alice.SendPrivMsg(aliceBuffer, "hi bob")
+		waitFor(t, "the message", func() bool { return lastLine(bobBuffer) == "alice: hi bob" })
+
+		bob.SendAction(bobBuffer, "waves")
+		waitFor(t, "the action", func() bool { return lastLine(aliceBuffer) == "* bob waves" })
+
+		alice.CloseChat(aliceBuffer)
+		waitFor(t, "the chat to close", func() bool { return chat.Status() == ChatClosed })
+
+		bob.SendPrivMsg(bobBuffer, "still there?")
+		if lastLine(bobBuffer) != ErrChatNotConnected.Error() {
+			t.Fatal("Sending to a closed chat should fail:", lastLine(bobBuffer))
+		}
+	})
+
+	t.Run("Test passive chat", func(t *testing.T) {
+		alice, aliceServer, bob, bobServer, _, _ := newDCCClients(t)
+
+		buffers := make(chan *Channel, 1)
+		go func() {
+			buffer, _ := alice.OfferChat("bob", true)
+			buffers <- buffer
+		}()
+		chat := receiveChat(t, bob, "alice", readDCC(t, aliceServer))
+		aliceBuffer := <-buffers
+		if !chat.Passive {
+			t.Fatal("Offer should be passive")
+		}
+
+		go bob.AcceptChat(chat)
+		if offer, err := alice.HandleDCC("bob", readDCC(t, bobServer)); offer != nil || err != nil {
+			t.Fatal("The answer to a passive offer should connect, not make a new offer")
+		}
+
+		waitFor(t, "the chat to connect", func() bool {
+			return chat.Status() == ChatConnected && aliceBuffer.Direct.Status() == ChatConnected
+		})
+	})
+
+	t.Run("Test reject", func(t *testing.T) {
+		alice, aliceServer, bob, bobServer, _, _ := newDCCClients(t)
+
+		buffers := make(chan *Channel, 1)
+		go func() {
+			buffer, _ := alice.OfferChat("bob", false)
+			buffers <- buffer
+		}()
+		chat := receiveChat(t, bob, "alice", readDCC(t, aliceServer))
+		aliceBuffer := <-buffers
+
+		go bob.RejectChat(chat)
+		alice.HandleDCC("bob", readDCC(t, bobServer))
+
+		if aliceBuffer.Direct.Status() != ChatClosed || bob.PendingChat("alice") != nil {
+			t.Fatal("Rejecting should close the chat on both sides")
+		}
+	})
+}
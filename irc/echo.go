@@ -0,0 +1,209 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// pendingEcho is a message we sent that's shown as pending until the server echoes it back.
+// (https://ircv3.net/specs/extensions/echo-message)
+type pendingEcho struct {
+	Label  string
+	Target string
+	Text   string
+	Buffer *Channel
+	Line   *Line
+}
+
+type echoes struct {
+	mu      sync.Mutex
+	pending []*pendingEcho
+}
+
+func (e *echoes) add(echo *pendingEcho) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.pending = append(e.pending, echo)
+}
+
+// take removes and returns the first pending message matching the given function.
+func (e *echoes) take(match func(echo *pendingEcho) bool) *pendingEcho {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, echo := range e.pending {
+		if match(echo) {
+			e.pending = append(e.pending[:i], e.pending[i+1:]...)
+			return echo
+		}
+	}
+
+	return nil
+}
+
+// takeOldest removes and returns the oldest pending message if it matches the given function.
+func (e *echoes) takeOldest(match func(echo *pendingEcho) bool) *pendingEcho {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.pending) == 0 || !match(e.pending[0]) {
+		return nil
+	}
+
+	echo := e.pending[0]
+	e.pending = e.pending[1:]
+
+	return echo
+}
+
+// SendPrivMsg sends a message to the buffer's target and shows it in the buffer.
+// With echo-message the line is shown as pending until the server echoes it back.
+// Messages with several lines or that are too long for one PRIVMSG are sent as a multiline batch
+// if the server supports it and split into several messages otherwise.
+func (c *Client) SendPrivMsg(buffer *Channel, text string) {
+	if buffer.IsDirect() {
+		c.sendDirect(buffer, text)
+		return
+	}
+
+	c.sendMessage(buffer, text, nil)
+}
+
+// sendMessage sends text with the given tags on every message it's sent as,
+// split or batched like SendPrivMsg does. It returns the lines shown for it.
+func (c *Client) sendMessage(buffer *Channel, text string, tags MessageTags) []*Line {
+	if strings.Contains(text, "\n") || len(text) > c.maxTextLength(buffer.Name) {
+		return c.sendLines(buffer, text, tags)
+	}
+
+	return []*Line{c.sendPrivMsg(buffer, text, tags)}
+}
+
+func (c *Client) sendPrivMsg(buffer *Channel, text string, tags MessageTags) *Line {
+	line, tags := c.showPrivMsg(buffer, text, tags)
+	c.SendTagged(tags, commands.PRIVMSG, buffer.Name, text)
+
+	return line
+}
+
+// showPrivMsg shows a message we're about to send in its buffer and returns the tags to send it with.
+func (c *Client) showPrivMsg(buffer *Channel, text string, tags MessageTags) (*Line, MessageTags) {
+	// the message itself tells others we're done typing
+	buffer.ourTyping = TypingDone
+
+	line := buffer.AppendMsg(time.Now(), FormatPrivMsg(c.Nickname, text), MsgFmtOpts{WithTimestamp: true})
+
+	if _, ok := c.EnabledCapabilities["echo-message"]; !ok {
+		return line, tags
+	}
+
+	buffer.History.Update(line, func(line *Line) {
+		line.Status = LinePending
+	})
+
+	echo := &pendingEcho{
+		Target: buffer.Name,
+		Text:   text,
+		Buffer: buffer,
+		Line:   line,
+	}
+
+	if _, ok := c.EnabledCapabilities["labeled-response"]; ok {
+		echo.Label = c.Requests.add(buffer, false).Label
+		tags = withTag(tags, "label", echo.Label)
+	}
+
+	c.echoes.add(echo)
+
+	return line, tags
+}
+
+// withTag returns a copy of the tags with one more tag set.
+func withTag(tags MessageTags, key string, value string) MessageTags {
+	tagged := MessageTags{key: value}
+	for k, v := range tags {
+		tagged[k] = v
+	}
+
+	return tagged
+}
+
+// matchEcho returns a function matching the pending message a reply is about,
+// by label if the reply has one and by target (and text if given) otherwise.
+func (c *Client) matchEcho(msg Message, target string, text *string) func(echo *pendingEcho) bool {
+	label := msg.Tags["label"]
+
+	return func(echo *pendingEcho) bool {
+		if label != "" && echo.Label != "" {
+			return echo.Label == label
+		}
+
+		if c.Casefold(echo.Target) != c.Casefold(target) {
+			return false
+		}
+
+		return text == nil || echo.Text == *text
+	}
+}
+
+// ConfirmEcho makes the server's echo of our message the authoritative record of it,
+// with the server's time and msgid. It returns false if we weren't waiting for this message.
+func (c *Client) ConfirmEcho(msg Message) bool {
+	text := msg.Parameters[1]
+
+	echo := c.echoes.take(c.matchEcho(msg, msg.Parameters[0], &text))
+	if echo == nil {
+		return false
+	}
+
+	echo.Buffer.History.Update(echo.Line, func(line *Line) {
+		line.Status = LineSent
+		line.DateTime = msg.DateTime
+		line.MsgID = msg.Tags["msgid"]
+	})
+
+	return true
+}
+
+// FailEcho marks the pending message an error is about as not sent, the one with the error's label
+// or without one the oldest we're waiting for since the server answers in order.
+// It returns false if that's not a message to target.
+func (c *Client) FailEcho(msg Message, target string, reason string) bool {
+	var echo *pendingEcho
+	if label := msg.Tags["label"]; label != "" {
+		echo = c.echoes.take(func(echo *pendingEcho) bool { return echo.Label == label })
+	} else {
+		echo = c.echoes.takeOldest(func(echo *pendingEcho) bool { return c.Casefold(echo.Target) == c.Casefold(target) })
+	}
+
+	if echo == nil {
+		return false
+	}
+
+	echo.Buffer.History.Update(echo.Line, func(line *Line) {
+		line.Status = LineFailed
+		line.FailReason = reason
+	})
+
+	return true
+}
@@ -0,0 +1,120 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestEcho(t *testing.T) {
+	t.Run("Test echo by content", func(t *testing.T) {
+		client, server := newTestClient(t)
+		go io.Copy(io.Discard, server)
+
+		client.Nickname = "bob"
+		client.EnabledCapabilities = Capabilities{"echo-message": ""}
+		channel := client.AppendChannel(NewChannel("#gorc"))
+
+		client.SendPrivMsg(channel, "hello")
+		line := channel.History.At(0)
+		if line.Status != LinePending {
+			t.Fatal("Line should be pending until it's echoed")
+		}
+
+		serverTime := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC)
+		echo := Message{
+			DateTime:   serverTime,
+			Tags:       MessageTags{"msgid": "abc"},
+			Source:     "bob!bob@host",
+			Command:    "PRIVMSG",
+			Parameters: []string{"#GORC", "hello"},
+		}
+
+		if !client.ConfirmEcho(echo) {
+			t.Fatal("Echo wasn't matched")
+		}
+
+		if line.Status != LineSent || line.MsgID != "abc" || !line.DateTime.Equal(serverTime) {
+			t.Fatal("Echo didn't update the line:", line)
+		}
+
+		if client.ConfirmEcho(echo) {
+			t.Fatal("The same echo shouldn't be matched twice")
+		}
+	})
+
+	t.Run("Test failure by label", func(t *testing.T) {
+		client, server := newTestClient(t)
+		go io.Copy(io.Discard, server)
+
+		client.EnabledCapabilities = Capabilities{"echo-message": "", "labeled-response": ""}
+		channel := client.AppendChannel(NewChannel("#gorc"))
+
+		client.SendPrivMsg(channel, "first")
+		client.SendPrivMsg(channel, "second")
+
+		err := Message{Command: "404", Tags: MessageTags{"label": "gorc2"}, Parameters: []string{"bob", "#gorc", "Cannot send to channel"}}
+		if !client.FailEcho(err, "#gorc", "Cannot send to channel") {
+			t.Fatal("Error wasn't matched")
+		}
+
+		if channel.History.At(0).Status != LinePending || channel.History.At(1).Status != LineFailed {
+			t.Fatal("The wrong line was marked as failed")
+		}
+	})
+
+	t.Run("Test failure without a label", func(t *testing.T) {
+		client, server := newTestClient(t)
+		go io.Copy(io.Discard, server)
+
+		client.EnabledCapabilities = Capabilities{"echo-message": ""}
+		first := client.AppendChannel(NewChannel("#first"))
+		second := client.AppendChannel(NewChannel("#second"))
+
+		client.SendPrivMsg(first, "hello")
+		client.SendPrivMsg(second, "hello")
+
+		err := Message{Command: "404", Parameters: []string{"bob", "#second", "Cannot send to channel"}}
+		if client.FailEcho(err, "#second", "Cannot send to channel") {
+			t.Fatal("Error matched a line that isn't the oldest pending one")
+		}
+
+		err.Parameters[1] = "#first"
+		if !client.FailEcho(err, "#first", "Cannot send to channel") || first.History.At(0).Status != LineFailed {
+			t.Fatal("Error wasn't matched to the oldest pending line")
+		}
+
+		if second.History.At(0).Status != LinePending {
+			t.Fatal("The wrong line was marked as failed")
+		}
+	})
+
+	t.Run("Test without echo-message", func(t *testing.T) {
+		client, server := newTestClient(t)
+		go io.Copy(io.Discard, server)
+
+		client.EnabledCapabilities = Capabilities{}
+		channel := client.AppendChannel(NewChannel("#gorc"))
+
+		client.SendPrivMsg(channel, "hello")
+		if channel.History.At(0).Status != LineSent {
+			t.Fatal("Line shouldn't be pending without echo-message")
+		}
+	})
+}
@@ -0,0 +1,264 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Show formatting like bold and italics but none of the colors people put in their messages
+var StripColors bool
+
+// mIRC formatting codes
+// (https://modern.ircdocs.horse/formatting)
+const (
+	formatBold          = '\x02'
+	formatColor         = '\x03'
+	formatHexColor      = '\x04'
+	formatReset         = '\x0F'
+	formatMonospace     = '\x11'
+	formatReverse       = '\x16'
+	formatItalic        = '\x1D'
+	formatStrikethrough = '\x1E'
+	formatUnderline     = '\x1F'
+)
+
+const formatCodes = "\x02\x03\x04\x0F\x11\x16\x1D\x1E\x1F"
+
+// The colors \x03 codes refer to, 99 is the default color
+var ircColors = [99]string{
+	// the original 16
+	"#FFFFFF", "#000000", "#00007F", "#009300", "#FF0000", "#7F0000", "#9C009C", "#FC7F00",
+	"#FFFF00", "#00FC00", "#009393", "#00FFFF", "#0000FC", "#FF00FF", "#7F7F7F", "#D2D2D2",
+
+	// the extended ones
+	"#470000", "#472100", "#474700", "#324700", "#004700", "#00472C", "#004747", "#002747", "#000047", "#2E0047", "#470047", "#47002A",
+	"#740000", "#743A00", "#747400", "#517400", "#007400", "#007449", "#007474", "#004074", "#000074", "#4B0074", "#740074", "#740045",
+	"#B50000", "#B56300", "#B5B500", "#7DB500", "#00B500", "#00B571", "#00B5B5", "#0063B5", "#0000B5", "#7500B5", "#B500B5", "#B5006B",
+	"#FF0000", "#FF8C00", "#FFFF00", "#B2FF00", "#00FF00", "#00FFA0", "#00FFFF", "#008CFF", "#0000FF", "#A500FF", "#FF00FF", "#FF0098",
+	"#FF5959", "#FFB459", "#FFFF71", "#CFFF60", "#6FFF6F", "#65FFC9", "#6DFFFF", "#59B4FF", "#5959FF", "#C459FF", "#FF66FF", "#FF59BC",
+	"#FF9C9C", "#FFD39C", "#FFFF9C", "#E2FF9C", "#9CFF9C", "#9CFFDB", "#9CFFFF", "#9CD3FF", "#9C9CFF", "#DC9CFF", "#FF9CFF", "#FF94D3",
+	"#000000", "#131313", "#282828", "#363636", "#4D4D4D", "#656565", "#818181", "#9F9F9F", "#BCBCBC", "#E2E2E2", "#FFFFFF",
+}
+
+// ColorCount is how many colors \x03 codes can pick from
+const ColorCount = len(ircColors)
+
+// ColorHex returns the hex of the nth mIRC color, or nothing if there's no such color.
+func ColorHex(n int) string {
+	if n < 0 || n >= ColorCount {
+		return ""
+	}
+
+	return ircColors[n]
+}
+
+// formatState is the formatting in effect at some point of a message, colors are hex and empty for the default.
+type formatState struct {
+	Bold          bool
+	Italic        bool
+	Underline     bool
+	Strikethrough bool
+	Reverse       bool
+	Fg            string
+	Bg            string
+}
+
+type formatSpan struct {
+	Text  string
+	State formatState
+}
+
+// parseDigits parses a color number of up to 2 digits at the start of s.
+func parseDigits(s string) (int, int) {
+	n := 0
+	for n < 2 && n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+
+	if n == 0 {
+		return -1, 0
+	}
+
+	value, _ := strconv.Atoi(s[:n])
+	return value, n
+}
+
+func isHexColor(s string) bool {
+	if len(s) < 6 {
+		return false
+	}
+
+	_, err := strconv.ParseUint(s[:6], 16, 32)
+	return err == nil
+}
+
+func colorAt(i int) string {
+	if i >= 0 && i < len(ircColors) {
+		return ircColors[i]
+	}
+
+	// 99 and anything out of range is the default color
+	return ""
+}
+
+// parseColor applies a \x03 color code, s is what follows the code. It returns how much of s the code used.
+func (f *formatState) parseColor(s string) int {
+	fg, n := parseDigits(s)
+	if fg < 0 {
+		// a lone \x03 resets the colors
+		f.Fg, f.Bg = "", ""
+		return 0
+	}
+	f.Fg = colorAt(fg)
+
+	// the comma is only part of the code if a background color follows it
+	if n < len(s) && s[n] == ',' {
+		if bg, m := parseDigits(s[n+1:]); bg >= 0 {
+			f.Bg = colorAt(bg)
+			n += 1 + m
+		}
+	}
+
+	return n
+}
+
+// parseHexColor applies a \x04 color code, s is what follows the code. It returns how much of s the code used.
+func (f *formatState) parseHexColor(s string) int {
+	if !isHexColor(s) {
+		f.Fg, f.Bg = "", ""
+		return 0
+	}
+	f.Fg = "#" + strings.ToUpper(s[:6])
+	n := 6
+
+	if n < len(s) && s[n] == ',' && isHexColor(s[n+1:]) {
+		f.Bg = "#" + strings.ToUpper(s[n+1:n+7])
+		n += 7
+	}
+
+	return n
+}
+
+// parseFormatting splits text into spans of the same formatting, without the formatting codes.
+func parseFormatting(text string) []formatSpan {
+	var spans []formatSpan
+	var state formatState
+	start := 0
+
+	flush := func(end int) {
+		if end > start {
+			spans = append(spans, formatSpan{Text: text[start:end], State: state})
+		}
+	}
+
+	for i := 0; i < len(text); {
+		code := text[i]
+		if !strings.ContainsRune(formatCodes, rune(code)) {
+			i++
+			continue
+		}
+
+		flush(i)
+		i++
+
+		switch code {
+		case formatBold:
+			state.Bold = !state.Bold
+		case formatItalic:
+			state.Italic = !state.Italic
+		case formatUnderline:
+			state.Underline = !state.Underline
+		case formatStrikethrough:
+			state.Strikethrough = !state.Strikethrough
+		case formatReverse:
+			state.Reverse = !state.Reverse
+		case formatColor:
+			i += state.parseColor(text[i:])
+		case formatHexColor:
+			i += state.parseHexColor(text[i:])
+		case formatReset:
+			state = formatState{}
+		case formatMonospace:
+			// everything's monospace in a terminal already
+		}
+
+		start = i
+	}
+
+	flush(len(text))
+
+	return spans
+}
+
+// StripFormatting removes every formatting code from text.
+func StripFormatting(text string) string {
+	if !strings.ContainsAny(text, formatCodes) {
+		return text
+	}
+
+	var stripped strings.Builder
+	for _, span := range parseFormatting(text) {
+		stripped.WriteString(span.Text)
+	}
+
+	return stripped.String()
+}
+
+func (f formatState) style(base lipgloss.Style) lipgloss.Style {
+	style := base.
+		Bold(f.Bold || base.GetBold()).
+		Italic(f.Italic || base.GetItalic()).
+		Underline(f.Underline || base.GetUnderline()).
+		Strikethrough(f.Strikethrough || base.GetStrikethrough())
+
+	if StripColors {
+		return style
+	}
+
+	if f.Fg != "" {
+		style = style.Foreground(lipgloss.Color(f.Fg))
+	}
+
+	if f.Bg != "" {
+		style = style.Background(lipgloss.Color(f.Bg))
+	}
+
+	if f.Reverse {
+		style = style.Reverse(true)
+	}
+
+	return style
+}
+
+// RenderFormatting renders text with style, its formatting codes become styled spans on top of it.
+// Hex colors are brought down to what the terminal supports by lipgloss.
+func RenderFormatting(text string, style lipgloss.Style) string {
+	if !strings.ContainsAny(text, formatCodes) {
+		return style.Render(text)
+	}
+
+	var rendered strings.Builder
+	for _, span := range parseFormatting(text) {
+		rendered.WriteString(span.State.style(style).Render(span.Text))
+	}
+
+	return rendered.String()
+}
@@ -0,0 +1,92 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+func TestFormatting(t *testing.T) {
+	t.Run("Test toggles and reset", func(t *testing.T) {
+		spans := parseFormatting("a\x02b\x1Dc\x02d\x0Fe")
+		if len(spans) != 5 {
+			t.Fatal("Wrong spans:", spans)
+		}
+
+		if spans[0].State.Bold || !spans[1].State.Bold || !spans[2].State.Italic || spans[3].State.Bold || !spans[3].State.Italic {
+			t.Fatal("Toggles weren't applied:", spans)
+		}
+
+		if spans[4].State != (formatState{}) {
+			t.Fatal("\\x0F should reset everything:", spans[4])
+		}
+	})
+
+	t.Run("Test colors", func(t *testing.T) {
+		spans := parseFormatting("\x034red\x0312,1blue on black\x03,plain comma\x0399default")
+
+		if spans[0].Text != "red" || spans[0].State.Fg != "#FF0000" {
+			t.Fatal("Foreground wasn't parsed:", spans[0])
+		}
+
+		if spans[1].Text != "blue on black" || spans[1].State.Fg != "#0000FC" || spans[1].State.Bg != "#000000" {
+			t.Fatal("Background wasn't parsed:", spans[1])
+		}
+
+		// a lone \x03 resets the colors and a comma without a color after it is text
+		if spans[2].Text != ",plain comma" || spans[2].State.Fg != "" || spans[2].State.Bg != "" {
+			t.Fatal("Colors weren't reset:", spans[2])
+		}
+
+		if spans[3].Text != "default" || spans[3].State.Fg != "" {
+			t.Fatal("99 should be the default color:", spans[3])
+		}
+
+		spans = parseFormatting("\x04ff8800,000000orange\x04 reset")
+		if spans[0].State.Fg != "#FF8800" || spans[0].State.Bg != "#000000" || spans[1].State.Fg != "" {
+			t.Fatal("Hex colors weren't parsed:", spans)
+		}
+
+		// only two digits are part of the code
+		if spans := parseFormatting("\x03123"); spans[0].Text != "3" || spans[0].State.Fg != "#0000FC" {
+			t.Fatal("Color code took too many digits:", spans)
+		}
+	})
+
+	t.Run("Test stripping", func(t *testing.T) {
+		if StripFormatting("\x02bold\x02 \x0304,05red\x03 \x1Fu\x1F") != "bold red u" {
+			t.Fatal("Codes weren't stripped")
+		}
+	})
+
+	t.Run("Test rendering keeps widths", func(t *testing.T) {
+		text := "\x02\x0304hello\x0F \x1Dworld\x1D"
+		rendered := RenderFormatting(text, lipgloss.NewStyle())
+
+		if strings.ContainsAny(rendered, formatCodes) {
+			t.Fatal("Formatting codes were left in the output")
+		}
+
+		if ansi.StringWidth(rendered) != len("hello world") {
+			t.Fatal("Rendered width doesn't match the text:", ansi.StringWidth(rendered))
+		}
+	})
+}
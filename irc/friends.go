@@ -0,0 +1,366 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// How often friends are polled with ISON when the server can't MONITOR them for us
+var FriendsPollInterval = time.Minute
+
+// Longest list of nicks sent in a single MONITOR or ISON so the line stays under 512 bytes
+const maxNickListLength = 400
+
+// Friend is a nick we want to know the presence of even if we don't share a channel with them.
+type Friend struct {
+	Nick   string
+	Online bool
+
+	// Whether we've heard about them since connecting, whether they're online is unknown until then
+	Known bool
+
+	// Whether the server tells us about them with MONITOR, they're polled with ISON otherwise
+	Monitored bool
+}
+
+// friendList guards the friends since they're polled in the background and listed by the UI.
+type friendList struct {
+	mu       sync.Mutex
+	list     []*Friend
+	stopPoll chan struct{}
+}
+
+// isonQueries remembers the nicks of the ISON queries we sent since RPL_ISON only lists the online ones.
+type isonQueries struct {
+	mu      sync.Mutex
+	pending [][]string
+}
+
+// splitNicks groups nicks into lists that fit in one command.
+func splitNicks(nicks []string, sep string) [][]string {
+	var groups [][]string
+	var group []string
+	length := 0
+
+	for _, nick := range nicks {
+		if len(group) > 0 && length+len(sep)+len(nick) > maxNickListLength {
+			groups = append(groups, group)
+			group, length = nil, 0
+		}
+
+		group = append(group, nick)
+		length += len(sep) + len(nick)
+	}
+
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// SendIson asks which of the nicks are online.
+func (c *Client) SendIson(nicks ...string) {
+	for _, group := range splitNicks(nicks, " ") {
+		c.ison.mu.Lock()
+		c.ison.pending = append(c.ison.pending, group)
+		c.ison.mu.Unlock()
+
+		c.SendCommand(commands.ISON, group...)
+	}
+}
+
+// IsonQuery returns the nicks of the oldest ISON query we're waiting for a reply to.
+// It returns false if we didn't send any, e.g. it was typed by the user.
+func (c *Client) IsonQuery() ([]string, bool) {
+	c.ison.mu.Lock()
+	defer c.ison.mu.Unlock()
+
+	if len(c.ison.pending) == 0 {
+		return nil, false
+	}
+
+	nicks := c.ison.pending[0]
+	c.ison.pending = c.ison.pending[1:]
+
+	return nicks, true
+}
+
+// find returns the friend with the given nick, the caller must hold friends.mu.
+func (c *Client) find(nick string) *Friend {
+	for _, friend := range c.friends.list {
+		if c.Casefold(friend.Nick) == c.Casefold(nick) {
+			return friend
+		}
+	}
+
+	return nil
+}
+
+// Friend returns a copy of the friend with the given nick or nil if they're not a friend.
+func (c *Client) Friend(nick string) *Friend {
+	c.friends.mu.Lock()
+	defer c.friends.mu.Unlock()
+
+	friend := c.find(nick)
+	if friend == nil {
+		return nil
+	}
+
+	copied := *friend
+	return &copied
+}
+
+// Friends returns a copy of our friends, online ones first.
+func (c *Client) Friends() []Friend {
+	c.friends.mu.Lock()
+	friends := make([]Friend, 0, len(c.friends.list))
+	for _, friend := range c.friends.list {
+		friends = append(friends, *friend)
+	}
+	c.friends.mu.Unlock()
+
+	slices.SortStableFunc(friends, func(a, b Friend) int {
+		switch {
+		case a.Online == b.Online:
+			return strings.Compare(c.Casefold(a.Nick), c.Casefold(b.Nick))
+		case a.Online:
+			return -1
+		default:
+			return 1
+		}
+	})
+
+	return friends
+}
+
+// AddFriend starts watching a nick, it returns false if they already were a friend.
+func (c *Client) AddFriend(nick string) bool {
+	c.friends.mu.Lock()
+	if c.find(nick) != nil {
+		c.friends.mu.Unlock()
+		return false
+	}
+
+	friend := &Friend{Nick: nick}
+	c.friends.list = append(c.friends.list, friend)
+	c.friends.mu.Unlock()
+
+	if c.Ready {
+		c.watchFriends([]*Friend{friend})
+	}
+
+	return true
+}
+
+// RemoveFriend stops watching a nick, it returns false if they weren't a friend.
+func (c *Client) RemoveFriend(nick string) bool {
+	c.friends.mu.Lock()
+	friend := c.find(nick)
+	if friend == nil {
+		c.friends.mu.Unlock()
+		return false
+	}
+
+	c.friends.list = slices.DeleteFunc(c.friends.list, func(f *Friend) bool { return f == friend })
+	monitored, nick := friend.Monitored, friend.Nick
+	c.friends.mu.Unlock()
+
+	if monitored && c.Ready {
+		c.SendCommand(commands.MONITOR, "-", nick)
+	}
+
+	return true
+}
+
+// monitorLimit returns how many more nicks the server lets us MONITOR, -1 means there's no limit.
+// The caller must hold friends.mu.
+func (c *Client) monitorLimit() int {
+	limit, err := strconv.Atoi(c.EnabledFeatures["MONITOR"])
+	if err != nil || limit <= 0 {
+		return -1
+	}
+
+	for _, friend := range c.friends.list {
+		if friend.Monitored {
+			limit--
+		}
+	}
+
+	// the primary nick we're waiting for takes up a slot too
+	if c.regaining && c.stopRegain == nil {
+		limit--
+	}
+
+	return max(0, limit)
+}
+
+// watchFriends MONITORs as many of the friends as the server allows and polls the rest with ISON.
+func (c *Client) watchFriends(friends []*Friend) {
+	var monitored, polled []string
+
+	c.friends.mu.Lock()
+	if _, ok := c.EnabledFeatures["MONITOR"]; ok {
+		limit := c.monitorLimit()
+		for _, friend := range friends {
+			if limit >= 0 && len(monitored) >= limit {
+				polled = append(polled, friend.Nick)
+				continue
+			}
+
+			friend.Monitored = true
+			monitored = append(monitored, friend.Nick)
+		}
+	} else {
+		for _, friend := range friends {
+			polled = append(polled, friend.Nick)
+		}
+	}
+	c.friends.mu.Unlock()
+
+	for _, group := range splitNicks(monitored, ",") {
+		c.SendCommand(commands.MONITOR, "+", strings.Join(group, ","))
+	}
+
+	if len(polled) == 0 {
+		return
+	}
+
+	c.SendIson(polled...)
+
+	c.startFriendsPoll()
+}
+
+// startFriendsPoll polls the friends the server doesn't MONITOR every FriendsPollInterval.
+func (c *Client) startFriendsPoll() {
+	c.friends.mu.Lock()
+	defer c.friends.mu.Unlock()
+
+	if c.friends.stopPoll != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.friends.stopPoll = stop
+
+	go func() {
+		ticker := time.NewTicker(FriendsPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				var nicks []string
+				c.friends.mu.Lock()
+				for _, friend := range c.friends.list {
+					if !friend.Monitored {
+						nicks = append(nicks, friend.Nick)
+					}
+				}
+				c.friends.mu.Unlock()
+
+				if len(nicks) > 0 {
+					c.SendIson(nicks...)
+				}
+			}
+		}
+	}()
+}
+
+// StartFriends starts watching every friend once we're registered.
+func (c *Client) StartFriends() {
+	c.friends.mu.Lock()
+	friends := slices.Clone(c.friends.list)
+	c.friends.mu.Unlock()
+
+	if len(friends) > 0 {
+		c.watchFriends(friends)
+	}
+}
+
+// StopFriends forgets whether friends are online and stops polling, for when we disconnect.
+func (c *Client) StopFriends() {
+	c.friends.mu.Lock()
+	for _, friend := range c.friends.list {
+		friend.Online = false
+		friend.Known = false
+		friend.Monitored = false
+	}
+
+	if c.friends.stopPoll != nil {
+		close(c.friends.stopPoll)
+		c.friends.stopPoll = nil
+	}
+	c.friends.mu.Unlock()
+
+	c.ison.mu.Lock()
+	c.ison.pending = nil
+	c.ison.mu.Unlock()
+}
+
+// SetFriendOnline updates a friend's presence.
+// It returns a copy of the friend and whether that's news worth telling, nil if the nick isn't a friend.
+func (c *Client) SetFriendOnline(nick string, online bool) (*Friend, bool) {
+	c.friends.mu.Lock()
+	defer c.friends.mu.Unlock()
+
+	friend := c.find(nick)
+	if friend == nil {
+		return nil, false
+	}
+
+	// going offline is only news if we knew they were online
+	news := friend.Online != online && (online || friend.Known)
+
+	friend.Nick = nick
+	friend.Online = online
+	friend.Known = true
+
+	copied := *friend
+	return &copied, news
+}
+
+// FriendsFull moves friends the server refused to MONITOR to ISON polling.
+func (c *Client) FriendsFull(nicks []string) {
+	var polled []string
+
+	c.friends.mu.Lock()
+	for _, nick := range nicks {
+		if friend := c.find(nick); friend != nil && friend.Monitored {
+			friend.Monitored = false
+			polled = append(polled, friend.Nick)
+		}
+	}
+	c.friends.mu.Unlock()
+
+	if len(polled) == 0 {
+		return
+	}
+
+	c.SendIson(polled...)
+
+	c.startFriendsPoll()
+}
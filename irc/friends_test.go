@@ -0,0 +1,114 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+func TestFriends(t *testing.T) {
+	t.Run("Test splitting nick lists", func(t *testing.T) {
+		var nicks []string
+		for i := 0; i < 100; i++ {
+			nicks = append(nicks, strings.Repeat("a", 9))
+		}
+
+		groups := splitNicks(nicks, ",")
+		if len(groups) != 3 || len(groups[0])+len(groups[1])+len(groups[2]) != 100 {
+			t.Fatal("Wrong groups:", len(groups))
+		}
+	})
+
+	t.Run("Test MONITOR limit", func(t *testing.T) {
+		client, server := newTestClient(t)
+		client.EnabledFeatures = Features{"MONITOR": "2"}
+		for _, nick := range []string{"alice", "bob", "carol"} {
+			client.AddFriend(nick)
+		}
+
+		done := make(chan struct{})
+		var sent []string
+		go func() {
+			for i := 0; i < 2; i++ {
+				line, _ := server.ReadString('\n')
+				sent = append(sent, line)
+			}
+			close(done)
+		}()
+
+		client.StartFriends()
+		<-done
+		t.Cleanup(client.StopFriends)
+
+		if sent[0] != "MONITOR + alice,bob\r\n" || sent[1] != "ISON carol\r\n" {
+			t.Fatalf("Wrong commands: %q", sent)
+		}
+
+		if nicks, ok := client.IsonQuery(); !ok || nicks[0] != "carol" {
+			t.Fatal("ISON query wasn't remembered")
+		}
+	})
+
+	t.Run("Test regaining a friend's nick", func(t *testing.T) {
+		client, server := newTestClient(t)
+		client.Registered = true
+		client.Profile.Nickname = "bob"
+		client.regaining = true
+		client.AddFriend("Bob")
+
+		done := make(chan string)
+		go func() {
+			line, _ := server.ReadString('\n')
+			done <- line
+		}()
+
+		client.StopRegain()
+		client.SendCommand(commands.PING, "x")
+
+		if line := <-done; line != "PING x\r\n" {
+			t.Fatalf("Friend stopped being monitored: %q", line)
+		}
+	})
+
+	t.Run("Test presence news", func(t *testing.T) {
+		client := &Client{}
+		client.AddFriend("alice")
+
+		if _, news := client.SetFriendOnline("alice", false); news {
+			t.Fatal("Being offline when we first hear of them isn't news")
+		}
+
+		if _, news := client.SetFriendOnline("ALICE", true); !news {
+			t.Fatal("Coming online is news")
+		}
+
+		if _, news := client.SetFriendOnline("alice", true); news {
+			t.Fatal("Still being online isn't news")
+		}
+
+		if friend, news := client.SetFriendOnline("alice", false); !news || friend.Online {
+			t.Fatal("Going offline is news")
+		}
+
+		if friend, _ := client.SetFriendOnline("bob", true); friend != nil {
+			t.Fatal("bob isn't a friend")
+		}
+	})
+}
@@ -0,0 +1,242 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/illusionman1212/gorc/cmds"
+	"github.com/illusionman1212/gorc/irc"
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// How many nicks a netsplit summary lists before it just counts the rest
+const maxNetsplitNicks = 10
+
+func handleBatch(msg irc.Message, client *irc.Client) {
+	if len(msg.Parameters) == 0 || msg.Parameters[0] == "" {
+		return
+	}
+
+	ref := msg.Parameters[0]
+
+	if ref[0] == '+' {
+		client.Batches.Start(msg)
+		return
+	}
+
+	batch := client.Batches.End(ref[1:])
+	if batch == nil {
+		return
+	}
+
+	label := batch.Tags["label"]
+	if label == "" {
+		handleCompletedBatch(batch, client)
+		return
+	}
+
+	if client.Requests.Deliver(label, batch.Messages()) {
+		return
+	}
+
+	handleCompletedBatch(batch, client)
+	client.Requests.Remove(label)
+}
+
+// withLabel adds the label of a labeled batch to the tags of a message or batch inside it
+// so its handler knows which request it's a reply to.
+func withLabel(tags irc.MessageTags, label string) irc.MessageTags {
+	if label == "" || tags["label"] != "" {
+		return tags
+	}
+
+	labeled := make(irc.MessageTags, len(tags)+1)
+	for key, value := range tags {
+		labeled[key] = value
+	}
+	labeled["label"] = label
+
+	return labeled
+}
+
+// handleCompletedBatch hands a batch to the handler for its type,
+// messages of batches we don't treat specially are handled one by one.
+func handleCompletedBatch(batch *irc.Batch, client *irc.Client) {
+	switch batch.Type {
+	case "netsplit", "netjoin":
+		handleNetsplit(batch, client)
+	case "chathistory":
+		handleChathistory(batch, client)
+	case "draft/multiline":
+		if msg, ok := batch.Multiline(); ok {
+			msg.Playback = isPlayback(batch)
+			handleMessage(msg, client)
+		}
+	default:
+		label := batch.Tags["label"]
+
+		for _, item := range batch.Items {
+			if item.Batch != nil {
+				item.Batch.Tags = withLabel(item.Batch.Tags, label)
+				handleCompletedBatch(item.Batch, client)
+			} else {
+				msg := *item.Message
+				msg.Tags = withLabel(msg.Tags, label)
+				msg.Playback = isPlayback(batch)
+				handleMessage(msg, client)
+			}
+		}
+	}
+}
+
+// isPlayback reports whether a batch is, or is nested in, ZNC playing back what we missed.
+func isPlayback(batch *irc.Batch) bool {
+	for ; batch != nil; batch = batch.Parent {
+		if batch.Type == "znc.in/playback" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleNetsplit shows a netsplit or netjoin as one line in every affected buffer
+// instead of a line for every user that quit or joined.
+func handleNetsplit(batch *irc.Batch, client *irc.Client) {
+	affected := make(map[*irc.Channel][]string)
+	var order []*irc.Channel
+	datetime := time.Now()
+
+	add := func(channel *irc.Channel, nick string) {
+		if _, ok := affected[channel]; !ok {
+			order = append(order, channel)
+		}
+		affected[channel] = append(affected[channel], nick)
+	}
+
+	for _, msg := range batch.Messages() {
+		nick := strings.SplitN(msg.Source, "!", 2)[0]
+		datetime = msg.DateTime
+
+		switch msg.Command {
+		case commands.QUIT:
+			for _, current := range client.Buffers.All() {
+				if current == client.RootChannel {
+					continue
+				}
+
+				if _, ok := current.Users[nick]; ok {
+					delete(current.Users, nick)
+					add(current, nick)
+				}
+			}
+
+			client.Users.Remove(nick)
+		case commands.JOIN:
+			if current := client.Buffers.Get(msg.Parameters[0]); current != nil {
+				if _, exists := current.Users[nick]; !exists {
+					current.Users[nick] = irc.User{}
+				}
+				client.Users.Add(nick)
+				add(current, nick)
+			}
+		}
+	}
+
+	servers := strings.Join(batch.Params, " <-> ")
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	for _, channel := range order {
+		nicks := affected[channel]
+
+		list := strings.Join(nicks[:min(len(nicks), maxNetsplitNicks)], ", ")
+		if len(nicks) > maxNetsplitNicks {
+			list += fmt.Sprintf(" and %d more", len(nicks)-maxNetsplitNicks)
+		}
+
+		message := fmt.Sprintf("Netsplit %s: %d users quit (%s)", servers, len(nicks), list)
+		if batch.Type == "netjoin" {
+			message = fmt.Sprintf("Netjoin %s: %d users joined (%s)", servers, len(nicks), list)
+		}
+
+		channel.AppendMsg(datetime, message, msgOpts)
+	}
+
+	client.Tea.Send(cmds.SwitchChannels())
+}
+
+// handleChathistory puts history where it belongs by time instead of appending it like live messages,
+// skipping messages we already have. (https://ircv3.net/specs/extensions/chathistory)
+func handleChathistory(batch *irc.Batch, client *irc.Client) {
+	if len(batch.Params) < 1 {
+		return
+	}
+
+	target := batch.Params[0]
+	channel := client.Buffers.Get(target)
+	inserted := 0
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+	}
+
+	for _, msg := range batch.Combined() {
+		// reactions only show up with event-playback, their messages come before them
+		if msg.Command == commands.TAGMSG && channel != nil {
+			client.HandleReaction(channel, msg)
+			continue
+		}
+
+		if msg.Command != commands.PRIVMSG && msg.Command != commands.NOTICE {
+			continue
+		}
+
+		if channel == nil {
+			channel = client.AppendChannel(irc.NewChannel(target))
+			client.Tea.Send(cmds.UpdateTabBar())
+		}
+
+		id := msg.Tags["msgid"]
+		if id != "" && channel.History.HasMsgID(id) {
+			continue
+		}
+
+		source := strings.SplitN(msg.Source, "!", 2)[0]
+		line := channel.History.Insert(msg.DateTime, irc.FormatPrivMsg(source, msg.Parameters[1]), msgOpts)
+		channel.History.SetTags(line, msg.Tags)
+
+		inserted++
+	}
+
+	if channel == nil {
+		return
+	}
+
+	channel.HistoryLoading = false
+
+	// paging back only stops once the server has nothing we don't already have
+	if inserted == 0 {
+		channel.HistoryExhausted = true
+	}
+}
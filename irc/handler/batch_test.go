@@ -0,0 +1,146 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package handler
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/illusionman1212/gorc/irc"
+	"github.com/illusionman1212/gorc/irc/parser"
+)
+
+// newTestClient returns a client whose ui updates go nowhere.
+func newTestClient(t *testing.T) *irc.Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client := &irc.Client{
+		Nickname:            "me",
+		Buffers:             irc.NewBuffers(),
+		Users:               irc.NewUsers(),
+		Batches:             irc.NewBatches(),
+		Requests:            irc.NewRequests(),
+		Transfers:           irc.NewTransfers(),
+		EnabledCapabilities: irc.Capabilities{},
+		EnabledFeatures:     irc.Features{},
+		Tea:                 tea.NewProgram(nil, tea.WithContext(ctx)),
+	}
+	client.RootChannel = client.AppendChannel(irc.NewChannel("server"))
+	client.ActiveChannel = client.RootChannel
+
+	return client
+}
+
+func handleLines(t *testing.T, client *irc.Client, lines ...string) {
+	for _, line := range lines {
+		msg, ok := parser.ParseIRCMessage(line)
+		if !ok {
+			t.Fatal("Couldn't parse:", line)
+		}
+		HandleCommand(msg, client)
+	}
+}
+
+func TestNestedBatches(t *testing.T) {
+	t.Run("Test multiline in chathistory", func(t *testing.T) {
+		client := newTestClient(t)
+		channel := client.AppendChannel(irc.NewChannel("#gorc"))
+
+		handleLines(t, client,
+			"BATCH +history chathistory #gorc",
+			"@batch=history;msgid=a BATCH +ml draft/multiline #gorc",
+			"@batch=ml :alice!a@host PRIVMSG #gorc :first",
+			"@batch=ml :alice!a@host PRIVMSG #gorc :second",
+			"BATCH -ml",
+			"@batch=history;msgid=b :bob!b@host PRIVMSG #gorc :single",
+			"BATCH -history",
+		)
+
+		if channel.History.Len() != 2 {
+			t.Fatal("Wrong number of lines:", channel.History.Len())
+		}
+
+		if line := channel.History.At(0); line.Content != "alice: first\nsecond" || line.MsgID != "a" {
+			t.Fatal("Wrong multiline message:", line.Content, line.MsgID)
+		}
+
+		if line := channel.History.At(1); line.Content != "bob: single" {
+			t.Fatal("Wrong message:", line.Content)
+		}
+	})
+
+	t.Run("Test chathistory in labeled response", func(t *testing.T) {
+		client := newTestClient(t)
+		channel := client.AppendChannel(irc.NewChannel("#gorc"))
+
+		handleLines(t, client,
+			"@label=1 BATCH +labeled labeled-response",
+			"@batch=labeled BATCH +history chathistory #gorc",
+			"@batch=history;msgid=a :alice!a@host PRIVMSG #gorc :hello",
+			"BATCH -history",
+			"BATCH -labeled",
+		)
+
+		if channel.History.Len() != 1 || channel.History.At(0).Content != "alice: hello" {
+			t.Fatal("History wasn't handled")
+		}
+
+		if channel.HistoryLoading {
+			t.Fatal("Buffer is still loading history")
+		}
+	})
+
+	t.Run("Test malformed BATCH", func(t *testing.T) {
+		client := newTestClient(t)
+
+		handleLines(t, client, "BATCH", "BATCH :", "BATCH +")
+	})
+
+	t.Run("Test queries in playback", func(t *testing.T) {
+		client := newTestClient(t)
+		conn, server := net.Pipe()
+		client.TCPConn = conn
+		t.Cleanup(func() { conn.Close() })
+
+		sent := make(chan string, 2)
+		go func() {
+			reader := bufio.NewReader(server)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				sent <- line
+			}
+		}()
+
+		handleLines(t, client,
+			"BATCH +play znc.in/playback #gorc",
+			"@batch=play :alice!a@host PRIVMSG me :\x01PING 1\x01",
+			"BATCH -play",
+			":alice!a@host PRIVMSG me :\x01PING 2\x01",
+		)
+
+		if line := <-sent; line != "NOTICE alice :\x01PING 2\x01\r\n" {
+			t.Fatalf("A played back query was answered: %q", line)
+		}
+	})
+}
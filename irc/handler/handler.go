@@ -18,17 +18,20 @@ package handler
 
 import (
 	"bufio"
+	"cmp"
 	"fmt"
 	"io"
-	"log"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/illusionman1212/gorc/cmds"
 	"github.com/illusionman1212/gorc/irc"
 	"github.com/illusionman1212/gorc/irc/commands"
 	"github.com/illusionman1212/gorc/irc/parser"
+	"github.com/illusionman1212/gorc/logging"
 )
 
 func ReadLoop(client *irc.Client) {
@@ -40,9 +43,21 @@ func ReadLoop(client *irc.Client) {
 		msg, err := r.ReadString('\n')
 		if err != nil {
 			if err != io.EOF {
-				log.Println(err)
+				logging.Errorf("%s: %v", client.Host, err)
 			}
 			client.TCPConn.Close()
+			client.StopRegain()
+			client.ResetWho()
+			client.StopFriends()
+			client.Registered = false
+			client.Ready = false
+
+			msgOpts := irc.MsgFmtOpts{
+				WithTimestamp: true,
+				AsErrorMsg:    true,
+			}
+			client.RootChannel.AppendMsg(time.Now(), "Disconnected from "+client.Host, msgOpts)
+			client.Tea.Send(cmds.ReceivedIRCMsg())
 			return
 		}
 
@@ -58,72 +73,198 @@ func handlePing(msg irc.Message, client *irc.Client) {
 	client.SendCommand(commands.PONG, token)
 }
 
+// appendMessage adds a message to a buffer and keeps its msgid so history doesn't show it twice.
+func appendMessage(channel *irc.Channel, msg irc.Message, content string, opts irc.MsgFmtOpts) {
+	line := channel.AppendMsg(msg.DateTime, content, opts)
+	channel.History.SetTags(line, msg.Tags)
+}
+
 func handlePrivMsg(msg irc.Message, client *irc.Client) {
+	client.SeeMessage(msg.DateTime)
+
 	source := strings.ToLower(strings.SplitN(msg.Source, "!", 2)[0])
 	targets := strings.Split(msg.Parameters[0], ",")
 	msgContent := msg.Parameters[1]
-	privMsg := fmt.Sprintf("%s: %s", source, msgContent)
+	privMsg := irc.FormatPrivMsg(source, msgContent)
 
 	msgOpts := irc.MsgFmtOpts{
 		WithTimestamp: true,
 	}
 
-targetsLoop:
+	// the server echoing back a message we sent from here, it's already in its buffer
+	isMe := client.IsMe(source)
+	if isMe && client.ConfirmEcho(msg) {
+		return
+	}
+
+	if ctcp, ok := irc.ParseCTCP(msgContent); ok && ctcp.Command != "ACTION" {
+		// our own queries echoed back
+		if isMe {
+			return
+		}
+
+		handleCTCPQuery(msg, ctcp, client)
+		return
+	}
+
 	for _, target := range targets {
-		current := client.RootChannel
-		for {
-			if current.Value.Name == target || current.Value.Name == source {
-				current.Value.AppendMsg(msg.DateTime, privMsg, msgOpts)
-				continue targetsLoop
-			}
+		// private messages go in the sender's buffer
+		if client.IsMe(target) {
+			target = source
+		}
 
-			current = current.Next
-			if current == client.RootChannel {
-				break
-			}
+		if channel := client.Buffers.Get(target); channel != nil {
+			channel.Typing.Set(source, irc.TypingDone, msg.DateTime)
+			client.Spoke(channel, strings.SplitN(msg.Source, "!", 2)[0], msg.DateTime)
+			appendMessage(channel, msg, privMsg, msgOpts)
+			continue
 		}
 
-		if target == client.Nickname {
-			newChannel := irc.Channel{
-				Name:  source,
-				Users: map[string]irc.User{source: {}},
-			}
+		// messages we sent to a user from another client go in that user's buffer
+		if target == source || (isMe && !strings.ContainsAny(target[:1], "#&")) {
+			newChannel := irc.NewChannel(target)
+			newChannel.Users[target] = irc.User{}
 
-			newChannel.AppendMsg(msg.DateTime, privMsg, msgOpts)
+			appendMessage(newChannel, msg, privMsg, msgOpts)
 			client.AppendChannel(newChannel)
+			client.Tea.Send(cmds.UpdateTabBar())
+		}
+	}
+}
+
+// handleCTCPQuery answers a CTCP query and lets us know someone sent one.
+func handleCTCPQuery(msg irc.Message, ctcp irc.CTCP, client *irc.Client) {
+	source := strings.SplitN(msg.Source, "!", 2)[0]
+
+	// answering old queries or offering to download what was sent while we were away makes no sense
+	if msg.Playback {
+		return
+	}
+
+	if ctcp.Command == "DCC" {
+		handleDCC(msg, ctcp, client)
+		return
+	}
+
+	message := fmt.Sprintf("%s sent a CTCP %s", source, ctcp.Command)
+	if !client.ReplyCTCP(source, ctcp, time.Now()) {
+		message += " (not answered)"
+	}
+
+	client.RootChannel.AppendMsg(msg.DateTime, message, irc.MsgFmtOpts{WithTimestamp: true, AsServerMsg: true})
+}
+
+// handleDCC passes a DCC request on to the client and asks whether to accept new offers.
+func handleDCC(msg irc.Message, ctcp irc.CTCP, client *irc.Client) {
+	source := strings.SplitN(msg.Source, "!", 2)[0]
+
+	offer, err := client.HandleDCC(source, ctcp.Params)
+	if err != nil {
+		client.RootChannel.AppendMsg(msg.DateTime, fmt.Sprintf("DCC from %s: %v", source, err), irc.MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true})
+		return
+	}
+
+	if offer == nil {
+		return
+	}
+
+	client.Tea.Send(cmds.Ask(offer.Question(), func(yes bool) tea.Cmd {
+		if !yes {
+			client.RejectOffer(offer)
+			return nil
+		}
+
+		if err := client.AcceptOffer(offer); err != nil {
+			client.RootChannel.AppendMsg(time.Now(), err.Error(), irc.MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true})
+		}
+		return nil
+	}))
+}
+
+// handleCTCPReply shows the reply to a CTCP query we sent in the active buffer.
+func handleCTCPReply(msg irc.Message, ctcp irc.CTCP, client *irc.Client) {
+	source := strings.SplitN(msg.Source, "!", 2)[0]
+
+	message := fmt.Sprintf("CTCP %s reply from %s: %s", ctcp.Command, source, ctcp.Params)
+	if ctcp.Command == "PING" {
+		if rtt, ok := irc.PingTime(ctcp, time.Now()); ok {
+			message = fmt.Sprintf("CTCP PING reply from %s: %s", source, rtt.Round(time.Millisecond))
 		}
 	}
+
+	client.ActiveChannel.AppendMsg(msg.DateTime, message, irc.MsgFmtOpts{WithTimestamp: true, AsServerMsg: true})
+}
+
+// tagMsgBuffer returns the buffer a TAGMSG is about or nil if we don't have one open.
+func tagMsgBuffer(msg irc.Message, client *irc.Client) *irc.Channel {
+	if len(msg.Parameters) == 0 {
+		return nil
+	}
+
+	target := msg.Parameters[0]
+	if client.IsMe(target) {
+		target = strings.SplitN(msg.Source, "!", 2)[0]
+	}
+
+	return client.Buffers.Get(target)
+}
+
+func handleTagMsg(msg irc.Message, client *irc.Client) {
+	channel := tagMsgBuffer(msg, client)
+	if channel == nil {
+		return
+	}
+
+	if client.HandleTyping(channel, msg) {
+		// redraw once the state times out so the indicator goes away without new messages
+		if msg.Tags["+typing"] == irc.TypingActive {
+			time.AfterFunc(irc.ActiveTypingTimeout, func() {
+				client.Tea.Send(cmds.TypingChanged())
+			})
+		}
+
+		return
+	}
+
+	client.HandleReaction(channel, msg)
 }
 
 func handleNotice(msg irc.Message, client *irc.Client) {
+	client.SeeMessage(msg.DateTime)
+
 	source := strings.SplitN(msg.Source, "!", 2)[0]
 	targets := strings.Split(msg.Parameters[0], ",")
 	msgContent := msg.Parameters[1]
 	notice := fmt.Sprintf("%s: %s", source, msgContent)
 
+	if ctcp, ok := irc.ParseCTCP(msgContent); ok {
+		if client.IsMe(source) {
+			return
+		}
+
+		// a DCC REJECT is sent as a reply
+		if ctcp.Command == "DCC" {
+			handleDCC(msg, ctcp, client)
+		} else {
+			handleCTCPReply(msg, ctcp, client)
+		}
+		return
+	}
+
 	msgOpts := irc.MsgFmtOpts{
 		WithTimestamp: true,
 	}
 
 	for _, target := range targets {
-		if target == "*" || target == client.Nickname {
-			client.RootChannel.Value.AppendMsg(msg.DateTime, notice, msgOpts)
+		if target == "*" || client.IsMe(target) {
+			client.RootChannel.AppendMsg(msg.DateTime, notice, msgOpts)
 			continue
 		}
 
-		current := client.RootChannel
-		for {
-			if current.Value.Name == targets[0] {
-				current.Value.AppendMsg(msg.DateTime, notice, msgOpts)
-			}
-
-			current = current.Next
-			if current == client.RootChannel {
-				break
-			}
+		if channel := client.Buffers.Get(target); channel != nil {
+			appendMessage(channel, msg, notice, msgOpts)
 		}
 	}
-
 }
 
 func handleJoin(msg irc.Message, client *irc.Client) {
@@ -137,37 +278,38 @@ func handleJoin(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	if nick == client.Nickname {
-		newChannel := irc.Channel{
-			Name:  channel,
-			Users: make(map[string]irc.User),
-		}
+	client.Users.Add(nick)
+	client.TrackUser(msg)
 
-		client.ActiveChannel = client.AppendChannel(newChannel)
-		client.ActiveChannel.Value.AppendMsg(msg.DateTime, joinMsg, msgOpts)
+	// extended-join tells us the account and realname right away
+	if len(msg.Parameters) > 2 {
+		user := client.Users.Get(nick)
+		user.Account = irc.ParseAccount(msg.Parameters[1])
+		user.Realname = msg.Parameters[2]
+	}
+
+	if client.IsMe(nick) {
+		client.ActiveChannel = client.AppendChannel(irc.NewChannel(channel))
+		client.ActiveChannel.AppendMsg(msg.DateTime, joinMsg, msgOpts)
 
-		if _, exists := client.ActiveChannel.Value.Users[nick]; !exists {
-			client.ActiveChannel.Value.Users[nick] = irc.User{}
+		if _, exists := client.ActiveChannel.Users[nick]; !exists {
+			client.ActiveChannel.Users[nick] = irc.User{}
 		}
 		client.Tea.Send(cmds.UpdateTabBar())
-	} else {
-		current := client.RootChannel
-		for {
-			if current.Value.Name == channel {
-				current.Value.AppendMsg(msg.DateTime, joinMsg, msgOpts)
-				if _, exists := current.Value.Users[nick]; !exists {
-					current.Value.Users[nick] = irc.User{}
-				}
-			}
 
-			current = current.Next
-			if current == client.RootChannel {
-				break
-			}
+		// catch up on what was said before we joined
+		client.RequestLatestHistory(client.ActiveChannel)
+
+		// find out who's away and logged in
+		client.Who(channel)
+	} else if current := client.Buffers.Get(channel); current != nil {
+		current.AppendMsg(msg.DateTime, joinMsg, msgOpts)
+		if _, exists := current.Users[nick]; !exists {
+			current.Users[nick] = irc.User{}
 		}
 	}
 
-	if channel == client.ActiveChannel.Value.Name {
+	if client.Buffers.Get(channel) == client.ActiveChannel {
 		client.Tea.Send(cmds.SwitchChannels())
 	}
 }
@@ -177,8 +319,7 @@ func handleNick(msg irc.Message, client *irc.Client) {
 	oldNick := strings.SplitN(msg.Source, "!", 2)[0]
 	newNick := msg.Parameters[0]
 
-	// TODO: the actual case-sensitivity is determined by the CASEMAPPING feature
-	isMe := strings.EqualFold(oldNick, client.Nickname)
+	isMe := client.IsMe(oldNick)
 
 	msgOpts := irc.MsgFmtOpts{
 		WithTimestamp: true,
@@ -193,38 +334,29 @@ func handleNick(msg irc.Message, client *irc.Client) {
 		message = fmt.Sprintf("%v changed their nickname to %v", oldNick, newNick)
 	}
 
-	current := client.RootChannel
-	for {
-		// Rename this user in every channel we can find
-		if user, ok := current.Value.Users[oldNick]; ok {
-			delete(current.Value.Users, oldNick)
-			current.Value.Users[newNick] = user
-			current.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	// Rename this user in every channel we can find
+	for _, current := range client.Buffers.All() {
+		if user, ok := current.Users[oldNick]; ok {
+			delete(current.Users, oldNick)
+			current.Users[newNick] = user
+			current.AppendMsg(msg.DateTime, message, msgOpts)
 		}
+	}
 
-		// If we have a private channel open with this user, rename it as well.
-		if strings.EqualFold(current.Value.Name, oldNick) {
-			current.Value.Name = newNick
-
-			if current == client.ActiveChannel {
-				current.Value.Name = newNick
-			}
-
-			// if client.ActiveChannelIndex == i {
-			// 	client.ActiveChannel = newNick
-			// }
-			break
-		}
+	client.Users.Rename(oldNick, newNick)
 
-		current = current.Next
-		if current == client.RootChannel {
-			break
-		}
+	// If we have a private channel open with this user, rename it as well.
+	if !isMe {
+		client.Buffers.Rename(oldNick, newNick)
 	}
 
 	if isMe {
-		client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+		client.RootChannel.AppendMsg(msg.DateTime, message, msgOpts)
 		client.Nickname = newNick
+
+		if client.IsMe(client.Profile.Nickname) {
+			client.StopRegain()
+		}
 	}
 
 	client.Tea.Send(cmds.UpdateNicks())
@@ -246,35 +378,29 @@ func handleKick(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
+	current := client.Buffers.Get(channel)
+	if current == nil {
+		return
+	}
+
 	for _, nick := range nicks {
 		kicker := strings.SplitN(msg.Source, "!", 2)[0]
 		message := fmt.Sprintf("%v kicked %v from %v (%v)", kicker, nick, channel, reason)
 
-		current := client.RootChannel
-		for {
-			if current.Value.Name == channel {
-				if nick == client.Nickname {
-					if current == client.ActiveChannel {
-						client.ActiveChannel = current.Prev
-					}
-
-					client.RemoveChannel(current)
-
-					message = fmt.Sprintf("You were kicked by %v from %v (%v)", kicker, channel, reason)
-					client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+		if client.IsMe(nick) {
+			client.RemoveChannel(current)
+			forgetChannel(current, client)
 
-					return
-				}
-				delete(current.Value.Users, nick)
-
-				current.Value.AppendMsg(msg.DateTime, message, msgOpts)
-			}
+			message = fmt.Sprintf("You were kicked by %v from %v (%v)", kicker, channel, reason)
+			client.RootChannel.AppendMsg(msg.DateTime, message, msgOpts)
 
-			current = current.Next
-			if current == client.RootChannel {
-				break
-			}
+			client.Tea.Send(cmds.SwitchChannels())
+			return
 		}
+		delete(current.Users, nick)
+		client.Forget(nick)
+
+		current.AppendMsg(msg.DateTime, message, msgOpts)
 	}
 
 	client.Tea.Send(cmds.SwitchChannels())
@@ -290,21 +416,20 @@ func handleQuit(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	// skip the server "channel"
-	current := client.RootChannel.Next
-	for {
-		// if i == 0 {
-		// 	continue
-		// }
-		current.Value.AppendMsg(msg.DateTime, quitMsg, msgOpts)
-		delete(current.Value.Users, nick)
-
-		current = current.Next
+	for _, current := range client.Buffers.All() {
+		// skip the server "channel"
 		if current == client.RootChannel {
-			break
+			continue
+		}
+
+		if _, ok := current.Users[nick]; ok {
+			current.AppendMsg(msg.DateTime, quitMsg, msgOpts)
+			delete(current.Users, nick)
 		}
 	}
 
+	client.Users.Remove(nick)
+
 	client.Tea.Send(cmds.SwitchChannels())
 }
 
@@ -323,30 +448,82 @@ func handlePart(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	current := client.RootChannel
-	for {
-		if current.Value.Name == channel {
-			if nick == client.Nickname {
-				if current == client.ActiveChannel {
-					client.ActiveChannel = current.Prev
-				}
+	if current := client.Buffers.Get(channel); current != nil {
+		if client.IsMe(nick) {
+			client.RemoveChannel(current)
+			forgetChannel(current, client)
+		} else {
+			current.AppendMsg(msg.DateTime, partMsg, msgOpts)
+			delete(current.Users, nick)
+			client.Forget(nick)
+		}
+	}
 
-				client.RemoveChannel(current)
-			} else {
-				current.Value.AppendMsg(msg.DateTime, partMsg, msgOpts)
-				delete(current.Value.Users, nick)
-			}
+	client.Tea.Send(cmds.SwitchChannels())
+}
 
-			break
-		}
+// forgetChannel drops the users of a channel we left that we don't see anywhere else.
+func forgetChannel(channel *irc.Channel, client *irc.Client) {
+	for nick := range channel.Users {
+		client.Forget(nick)
+	}
+}
 
-		current = current.Next
-		if current == client.RootChannel {
-			break
-		}
+// Sent when a user we share a channel with goes away or comes back with away-notify.
+// (https://ircv3.net/specs/extensions/away-notify)
+func handleAwayNotify(msg irc.Message, client *irc.Client) {
+	user := client.Users.Get(strings.SplitN(msg.Source, "!", 2)[0])
+	if user == nil {
+		return
 	}
 
-	client.Tea.Send(cmds.SwitchChannels())
+	user.Away = len(msg.Parameters) > 0
+	user.AwayMessage = ""
+	if user.Away {
+		user.AwayMessage = msg.Parameters[0]
+	}
+
+	client.Tea.Send(cmds.UpdateNicks())
+}
+
+// Sent when a user logs in to or out of their account with account-notify.
+// (https://ircv3.net/specs/extensions/account-notify)
+func handleAccount(msg irc.Message, client *irc.Client) {
+	user := client.Users.Get(strings.SplitN(msg.Source, "!", 2)[0])
+	if user == nil || len(msg.Parameters) < 1 {
+		return
+	}
+
+	user.Account = irc.ParseAccount(msg.Parameters[0])
+	client.Tea.Send(cmds.UpdateNicks())
+}
+
+// Sent when a user's username or host changes with chghost.
+// (https://ircv3.net/specs/extensions/chghost)
+func handleChghost(msg irc.Message, client *irc.Client) {
+	user := client.Users.Get(strings.SplitN(msg.Source, "!", 2)[0])
+	if user == nil || len(msg.Parameters) < 2 {
+		return
+	}
+
+	user.Username = msg.Parameters[0]
+	user.Host = msg.Parameters[1]
+}
+
+// Sent when a user changes their realname with setname.
+// (https://ircv3.net/specs/extensions/setname)
+func handleSetname(msg irc.Message, client *irc.Client) {
+	user := client.Users.Get(strings.SplitN(msg.Source, "!", 2)[0])
+	if user == nil || len(msg.Parameters) < 1 {
+		return
+	}
+
+	user.Realname = msg.Parameters[0]
+
+	if client.IsMe(user.Nick) {
+		message := fmt.Sprintf("Your realname was changed to %s", user.Realname)
+		client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, irc.MsgFmtOpts{WithTimestamp: true, AsServerMsg: true})
+	}
 }
 
 func handleTopic(msg irc.Message, client *irc.Client) {
@@ -357,106 +534,213 @@ func handleTopic(msg irc.Message, client *irc.Client) {
 		AsServerMsg: true,
 	}
 
-	current := client.RootChannel
-	for {
-		if current.Value.Name == channel {
-			current.Value.Topic = topic
-			current.Value.AppendMsg(msg.DateTime, fmt.Sprintf("Topic changed: %v", topic), msgOpts)
-			break
-		}
-
-		current = current.Next
-		if current == client.RootChannel {
-			break
-		}
+	if current := client.Buffers.Get(channel); current != nil {
+		current.Topic = topic
+		current.AppendMsg(msg.DateTime, fmt.Sprintf("Topic changed: %v", topic), msgOpts)
 	}
 }
 
 func handleCAP(msg irc.Message, client *irc.Client) {
+	list, more := irc.CapList(msg)
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
 	switch msg.Parameters[1] {
-	case "LIST":
-		fallthrough
-	case "NEW":
-		fallthrough
 	case "LS":
-		caps := strings.Split(msg.Parameters[2], " ")
-		recognizedCaps := make([]irc.Capabilities, 0)
-		capabilities := make(irc.Capabilities, 0)
-		length := 0
+		all, complete := client.Caps.AddPage(list, more)
+		if !complete {
+			return
+		}
 
-		for _, capability := range caps {
-			// NOTE: not sure if I should store the cap value the server advertises or use my own
-			parts := strings.Split(capability, "=")
-			key := parts[0]
-			value := ""
-			if len(parts) > 1 {
-				value = parts[1]
-			}
+		available := irc.ParseCaps(all)
+		for key, value := range available {
+			client.Caps.Available[key] = value
 
-			if capEnabled, ok := commands.Capabilities[key]; ok {
-				if capEnabled {
-					// When the final parameter approaches 510 bytes,
-					// we send multiple REQ commands
-					// (https://ircv3.net/specs/extensions/capability-negotiation.html#the-cap-req-subcommand)
-					if length+len(capability) >= 500 {
-						recognizedCaps = append(recognizedCaps, capabilities)
-						capabilities = make(irc.Capabilities, 0)
-						length = 0
-					}
-
-					capabilities[key] = value
-					length += len(capability)
-				} else {
-					log.Println("Unsupported capability:", capability)
-				}
-			} else {
-				log.Println("Unknown capability:", capability)
+			if _, known := commands.Capabilities[key]; !known {
+				logging.Debugf("Unknown capability: %s", key)
 			}
 		}
-		recognizedCaps = append(recognizedCaps, capabilities)
-
-		for _, capsList := range recognizedCaps {
-			list := make([]string, 0)
-			for key, value := range capsList {
-				if len(value) > 0 {
-					list = append(list, key+"="+value)
-				} else {
-					list = append(list, key)
-				}
-			}
-			client.SendCommand(commands.CAP, "REQ", strings.Join(list, " "))
+
+		// a /cap ls after registration only shows what's available
+		if !client.Caps.Negotiating {
+			client.ActiveChannel.AppendMsg(msg.DateTime, "Available capabilities: "+all, msgOpts)
+			return
 		}
-		client.SendCommand(commands.CAP, "END")
-	case "ACK":
-		if msg.Parameters[2] == "" {
+
+		if mechanisms, ok := available["sasl"]; ok && client.Profile.SASLEnabled() && !client.SASLOffered(available) {
+			message := fmt.Sprintf("Skipping SASL, the server doesn't offer %s, only %s", client.Profile.SASL.Mechanism, strings.ReplaceAll(mechanisms, ",", ", "))
+			client.RootChannel.AppendMsg(msg.DateTime, message, irc.MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true})
+		}
+
+		wanted := client.WantedCaps(available)
+		if slices.Contains(wanted, "sasl") {
+			client.AwaitingSASL = true
+		}
+
+		client.RequestCaps(wanted)
+		endCapNegotiation(client)
+	case "LIST":
+		all, complete := client.Caps.AddPage(list, more)
+		if !complete {
 			return
 		}
-		caps := strings.Split(msg.Parameters[2], " ")
-		for _, capability := range caps {
+
+		if all == "" {
+			all = "none"
+		}
+
+		client.ActiveChannel.AppendMsg(msg.DateTime, "Enabled capabilities: "+all, msgOpts)
+	case "ACK":
+		client.Caps.Pending = max(0, client.Caps.Pending-1)
+
+		var enabled, disabled []string
+		for _, capability := range strings.Fields(list) {
 			if capability[0] == '-' {
 				delete(client.EnabledCapabilities, capability[1:])
-			} else {
-				parts := strings.Split(capability, "=")
-				key := parts[0]
-				value := ""
-				if len(parts) > 1 {
-					value = parts[1]
-				}
-				client.EnabledCapabilities[key] = value
+				disabled = append(disabled, capability[1:])
+				continue
+			}
+
+			key, value, _ := strings.Cut(capability, "=")
+			if value == "" {
+				value = client.Caps.Available[key]
+			}
+			client.EnabledCapabilities[key] = value
+			enabled = append(enabled, key)
+
+			if key == "sasl" && client.AwaitingSASL {
+				client.SendCommand(commands.AUTHENTICATE, "PLAIN")
 			}
 		}
+
+		if client.Registered {
+			if len(enabled) > 0 {
+				client.ActiveChannel.AppendMsg(msg.DateTime, "Enabled capabilities: "+strings.Join(enabled, " "), msgOpts)
+			}
+			if len(disabled) > 0 {
+				client.ActiveChannel.AppendMsg(msg.DateTime, "Disabled capabilities: "+strings.Join(disabled, " "), msgOpts)
+			}
+		}
+
+		endCapNegotiation(client)
 	case "NAK":
-		caps := strings.Split(msg.Parameters[2], " ")
-		msgOpts := irc.MsgFmtOpts{
-			WithTimestamp: true,
-			AsServerMsg:   true,
+		client.Caps.Pending = max(0, client.Caps.Pending-1)
+
+		caps := strings.Fields(list)
+		client.RootChannel.AppendMsg(msg.DateTime, "Capabilities rejected: "+strings.Join(caps, " "), msgOpts)
+
+		if slices.Contains(caps, "sasl") {
+			client.AwaitingSASL = false
 		}
-		client.RootChannel.Value.AppendMsg(msg.DateTime, "Unrecognized capabilities: "+strings.Join(caps, " "), msgOpts)
+
+		endCapNegotiation(client)
+	case "NEW":
+		added := irc.ParseCaps(list)
+		for key, value := range added {
+			client.Caps.Available[key] = value
+		}
+
+		client.RootChannel.AppendMsg(msg.DateTime, "Server added capabilities: "+list, msgOpts)
+		client.RequestCaps(client.WantedCaps(added))
 	case "DEL":
-		caps := strings.Split(msg.Parameters[2], " ")
+		caps := strings.Fields(list)
 		for _, capability := range caps {
+			delete(client.Caps.Available, capability)
 			delete(client.EnabledCapabilities, capability)
 		}
+
+		client.RootChannel.AppendMsg(msg.DateTime, "Server removed capabilities: "+strings.Join(caps, " "), msgOpts)
+	}
+}
+
+// endCapNegotiation lets registration go on once every REQ was answered and SASL is done.
+func endCapNegotiation(client *irc.Client) {
+	if !client.Caps.ShouldEnd(client.AwaitingSASL) {
+		return
+	}
+
+	// binding to an upstream network of a soju bouncer has to happen before registration ends
+	if _, ok := client.Caps.Available["soju.im/bouncer-networks"]; ok && client.Profile.BouncerNetID != "" {
+		client.SendCommand(commands.BOUNCER, "BIND", client.Profile.BouncerNetID)
+	}
+
+	client.Caps.Negotiating = false
+	client.SendCommand(commands.CAP, "END")
+}
+
+func handleAuthenticate(msg irc.Message, client *irc.Client) {
+	if msg.Parameters[0] == "+" && client.AwaitingSASL {
+		for _, chunk := range irc.AuthenticateChunks(client.Profile.PlainCredentials()) {
+			client.SendCommand(commands.AUTHENTICATE, chunk)
+		}
+	}
+}
+
+// finishSASL ends capability negotiation once authentication succeeds or fails.
+func finishSASL(client *irc.Client) {
+	client.AwaitingSASL = false
+	endCapNegotiation(client)
+}
+
+func handleSASLSUCCESS(msg irc.Message, client *irc.Client) {
+	message := msg.Parameters[len(msg.Parameters)-1]
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	client.RootChannel.AppendMsg(msg.DateTime, message, msgOpts)
+	finishSASL(client)
+}
+
+func handleSASLFAIL(msg irc.Message, client *irc.Client) {
+	message := "SASL authentication failed: " + msg.Parameters[len(msg.Parameters)-1]
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsErrorMsg:    true,
+	}
+
+	client.RootChannel.AppendMsg(msg.DateTime, message, msgOpts)
+	if client.AwaitingSASL {
+		finishSASL(client)
+	}
+}
+
+func handleLOGGEDIN(msg irc.Message, client *irc.Client) {
+	message := msg.Parameters[len(msg.Parameters)-1]
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	client.RootChannel.AppendMsg(msg.DateTime, message, msgOpts)
+}
+
+// runConnectCommands joins the autojoin channels and runs the user's commands after registration.
+func runConnectCommands(client *irc.Client) {
+	if params := client.Profile.JoinParams(); params != nil {
+		client.SendCommand(commands.JOIN, params...)
+	}
+
+	for _, command := range client.Profile.Commands {
+		command = strings.TrimSpace(command)
+		if strings.TrimLeft(command, "/ ") == "" {
+			continue
+		}
+
+		if command[0] != '/' {
+			command = "/" + command
+		}
+
+		if cmd := HandleSlashCommand(command, client); cmd != nil {
+			go client.Tea.Send(cmd())
+		}
 	}
 }
 
@@ -472,12 +756,14 @@ func handleWELCOME(msg irc.Message, client *irc.Client) {
 	// set server-registered nickname because the server MAY return a different nickname than
 	// the one the user chose because of length restrictions or otherwise.
 	client.Nickname = nick
-	client.RootChannel.Value.AppendMsg(msg.DateTime, welcomeMsg, msgOpts)
+	client.Registered = true
 
-	// Only join the user-requested channel AFTER registration is complete.
-	if client.InitialChannel != "" {
-		client.SendCommand(commands.JOIN, client.InitialChannel)
-	}
+	// servers that don't know CAP register us without ever replying to it
+	client.Caps.Negotiating = false
+	client.RootChannel.AppendMsg(msg.DateTime, welcomeMsg, msgOpts)
+
+	// Only join the user-requested channels AFTER registration is complete.
+	runConnectCommands(client)
 }
 
 func handleYOURHOST(msg irc.Message, client *irc.Client) {
@@ -488,7 +774,7 @@ func handleYOURHOST(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, host, msgOpts)
+	client.RootChannel.AppendMsg(msg.DateTime, host, msgOpts)
 }
 
 func handleCREATED(msg irc.Message, client *irc.Client) {
@@ -499,7 +785,7 @@ func handleCREATED(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, created, msgOpts)
+	client.RootChannel.AppendMsg(msg.DateTime, created, msgOpts)
 }
 
 func handleMYINFO(msg irc.Message, client *irc.Client) {
@@ -510,7 +796,7 @@ func handleMYINFO(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, info, msgOpts)
+	client.RootChannel.AppendMsg(msg.DateTime, info, msgOpts)
 }
 
 func handleISUPPORT(msg irc.Message, client *irc.Client) {
@@ -530,14 +816,19 @@ func handleISUPPORT(msg irc.Message, client *irc.Client) {
 		if supported, known := commands.Features[key]; known {
 			if supported {
 				client.EnabledFeatures[key] = value
+
+				if key == "CASEMAPPING" {
+					client.Buffers.SetCasemapping(value)
+					client.Users.SetCasemapping(value)
+				}
 			} else {
-				log.Printf("Unsupported feature: \"%v\" with value: \"%v\"\n", key, value)
+				logging.Debugf("Unsupported feature: \"%v\" with value: \"%v\"", key, value)
 			}
 		} else {
-			log.Printf("Unknown feature: \"%v\" with value: \"%v\"\n", key, value)
+			logging.Debugf("Unknown feature: \"%v\" with value: \"%v\"", key, value)
 		}
 
-		client.RootChannel.Value.AppendMsg(msg.DateTime, token, msgOpts)
+		client.RootChannel.AppendMsg(msg.DateTime, token, msgOpts)
 	}
 }
 
@@ -549,7 +840,7 @@ func handleLUSERCLIENT(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleLUSEROP(msg irc.Message, client *irc.Client) {
@@ -560,7 +851,7 @@ func handleLUSEROP(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleLUSERUNKNOWN(msg irc.Message, client *irc.Client) {
@@ -571,7 +862,7 @@ func handleLUSERUNKNOWN(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleLUSERCHANNELS(msg irc.Message, client *irc.Client) {
@@ -582,7 +873,7 @@ func handleLUSERCHANNELS(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleLUSERME(msg irc.Message, client *irc.Client) {
@@ -593,7 +884,7 @@ func handleLUSERME(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleLOCALUSERS(msg irc.Message, client *irc.Client) {
@@ -610,7 +901,7 @@ func handleLOCALUSERS(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleGLOBALUSERS(msg irc.Message, client *irc.Client) {
@@ -627,7 +918,7 @@ func handleGLOBALUSERS(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleAWAY(msg irc.Message, client *irc.Client) {
@@ -635,34 +926,50 @@ func handleAWAY(msg irc.Message, client *irc.Client) {
 	reason := msg.Parameters[2]
 	awayMsg := fmt.Sprintf("%s is away (%s)", nick, reason)
 
+	if user := client.Users.Get(nick); user != nil {
+		user.Away = true
+		user.AwayMessage = reason
+	}
+
 	msgOpts := irc.MsgFmtOpts{
 		WithTimestamp: true,
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, awayMsg, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, awayMsg, msgOpts)
 }
 
 func handleUNAWAY(msg irc.Message, client *irc.Client) {
 	message := msg.Parameters[1]
 
+	if me := client.Users.Get(client.Nickname); me != nil {
+		me.Away = false
+		me.AwayMessage = ""
+		client.Tea.Send(cmds.UpdateNicks())
+	}
+
 	msgOpts := irc.MsgFmtOpts{
 		WithTimestamp: true,
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleNOWAWAY(msg irc.Message, client *irc.Client) {
 	message := msg.Parameters[1]
 
+	if me := client.Users.Get(client.Nickname); me != nil {
+		me.Away = true
+		client.Tea.Send(cmds.UpdateNicks())
+	}
+
 	msgOpts := irc.MsgFmtOpts{
 		WithTimestamp: true,
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleWHOISUSER(msg irc.Message, client *irc.Client) {
@@ -671,6 +978,12 @@ func handleWHOISUSER(msg irc.Message, client *irc.Client) {
 	host := msg.Parameters[3]
 	realName := msg.Parameters[5]
 
+	if known := client.Users.Get(nick); known != nil {
+		known.Username = user
+		known.Host = host
+		known.Realname = realName
+	}
+
 	msgOpts := irc.MsgFmtOpts{
 		WithTimestamp: true,
 		AsServerMsg:   true,
@@ -684,8 +997,8 @@ func handleWHOISUSER(msg irc.Message, client *irc.Client) {
 		realName,
 	)
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, "WHOIS Information", msgOpts)
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, "WHOIS Information", msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleWHOISSERVER(msg irc.Message, client *irc.Client) {
@@ -703,7 +1016,7 @@ func handleWHOISSERVER(msg irc.Message, client *irc.Client) {
 		serverInfo,
 	)
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleWHOISIDLE(msg irc.Message, client *irc.Client) {
@@ -712,7 +1025,7 @@ func handleWHOISIDLE(msg irc.Message, client *irc.Client) {
 
 	if err != nil {
 		// TODO: return this err and handle it in the parent
-		log.Println(err)
+		logging.Warnf("%v", err)
 	}
 
 	msgOpts := irc.MsgFmtOpts{
@@ -727,7 +1040,7 @@ func handleWHOISIDLE(msg irc.Message, client *irc.Client) {
 		idleSeconds,
 		since.Format(time.ANSIC),
 	)
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleENDOFWHOIS(msg irc.Message, client *irc.Client) {
@@ -738,7 +1051,7 @@ func handleENDOFWHOIS(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleWHOISCHANNELS(msg irc.Message, client *irc.Client) {
@@ -751,7 +1064,7 @@ func handleWHOISCHANNELS(msg irc.Message, client *irc.Client) {
 
 	message := fmt.Sprintf("channels: %s", chans)
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleNOTOPIC(msg irc.Message, client *irc.Client) {
@@ -762,17 +1075,8 @@ func handleNOTOPIC(msg irc.Message, client *irc.Client) {
 		AsServerMsg: true,
 	}
 
-	current := client.RootChannel
-	for {
-		if current.Value.Name == channel {
-			current.Value.AppendMsg(msg.DateTime, msgStr, msgOpts)
-			break
-		}
-
-		current = current.Next
-		if current == client.RootChannel {
-			break
-		}
+	if current := client.Buffers.Get(channel); current != nil {
+		current.AppendMsg(msg.DateTime, msgStr, msgOpts)
 	}
 }
 
@@ -784,18 +1088,9 @@ func handleTOPIC(msg irc.Message, client *irc.Client) {
 		AsServerMsg: true,
 	}
 
-	current := client.RootChannel
-	for {
-		if current.Value.Name == channel {
-			current.Value.Topic = topic
-			current.Value.AppendMsg(msg.DateTime, fmt.Sprintf("TOPIC: %v", topic), msgOpts)
-			break
-		}
-
-		current = current.Next
-		if current == client.RootChannel {
-			break
-		}
+	if current := client.Buffers.Get(channel); current != nil {
+		current.Topic = topic
+		current.AppendMsg(msg.DateTime, fmt.Sprintf("TOPIC: %v", topic), msgOpts)
 	}
 }
 
@@ -814,7 +1109,7 @@ func handleVERSION(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, versionMsg, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, versionMsg, msgOpts)
 }
 
 func handleNAMREPLY(msg irc.Message, client *irc.Client) {
@@ -824,37 +1119,70 @@ func handleNAMREPLY(msg irc.Message, client *irc.Client) {
 	channel := msg.Parameters[2]
 	nicks := strings.Split(msg.Parameters[3], " ")
 
-	current := client.RootChannel
-	for {
-		if current.Value.Name == channel {
-			for _, nick := range nicks {
-				prefix := ""
-				_nick := nick
-
-				if commands.UserPrefixes[string(nick[0])] {
-					prefix = string(nick[0])
-					_nick = nick[1:]
-				}
+	current := client.Buffers.Get(channel)
+	if current == nil {
+		return
+	}
 
-				current.Value.Users[_nick] = irc.User{
-					Prefix: prefix,
-				}
-			}
+	for _, nick := range nicks {
+		prefix := ""
+		_nick := nick
 
-			break
+		if commands.UserPrefixes[string(nick[0])] {
+			prefix = string(nick[0])
+			_nick = nick[1:]
 		}
 
-		current = current.Next
-		if current == client.RootChannel {
-			break
+		current.Users[_nick] = irc.User{
+			Prefix: prefix,
 		}
+		client.Users.Add(_nick)
 	}
 
-	if channel == client.ActiveChannel.Value.Name {
+	if current == client.ActiveChannel {
 		client.Tea.Send(cmds.SwitchChannels())
 	}
 }
 
+func handleWHOREPLY(msg irc.Message, client *irc.Client) {
+	reply, ok := irc.ParseWhoReply(msg)
+	if !ok {
+		return
+	}
+
+	// replies to our own queries only update the nick list
+	if client.WhoPending(reply.Channel) {
+		client.ApplyWhoReply(reply)
+		return
+	}
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	message := fmt.Sprintf("%s: %s (%s@%s) %s %s", reply.Channel, reply.Nick, reply.Username, reply.Host, reply.Flags, reply.Realname)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
+}
+
+func handleENDOFWHO(msg irc.Message, client *irc.Client) {
+	if len(msg.Parameters) < 3 {
+		return
+	}
+
+	if client.EndOfWho(msg.Parameters[1]) {
+		client.Tea.Send(cmds.UpdateNicks())
+		return
+	}
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, msg.Parameters[2], msgOpts)
+}
+
 func handleINFO(msg irc.Message, client *irc.Client) {
 	message := msg.Parameters[1]
 
@@ -863,7 +1191,7 @@ func handleINFO(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleENDOFINFO(msg irc.Message, client *irc.Client) {
@@ -874,7 +1202,7 @@ func handleENDOFINFO(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleMOTDStart(msg irc.Message, client *irc.Client) {
@@ -885,7 +1213,7 @@ func handleMOTDStart(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleMOTD(msg irc.Message, client *irc.Client) {
@@ -896,7 +1224,7 @@ func handleMOTD(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, messageLine, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, messageLine, msgOpts)
 }
 
 func handleWHOISHOST(msg irc.Message, client *irc.Client) {
@@ -907,7 +1235,7 @@ func handleWHOISHOST(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleWHOISMODES(msg irc.Message, client *irc.Client) {
@@ -918,10 +1246,14 @@ func handleWHOISMODES(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleNOSUCHNICK(msg irc.Message, client *irc.Client) {
+	if client.FailEcho(msg, msg.Parameters[1], msg.Parameters[2]) {
+		return
+	}
+
 	message := msg.Parameters[1] + ": " + msg.Parameters[2]
 
 	msgOpts := irc.MsgFmtOpts{
@@ -929,7 +1261,7 @@ func handleNOSUCHNICK(msg irc.Message, client *irc.Client) {
 		AsErrorMsg:    true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleNOSUCHSERVER(msg irc.Message, client *irc.Client) {
@@ -940,12 +1272,16 @@ func handleNOSUCHSERVER(msg irc.Message, client *irc.Client) {
 		AsErrorMsg:    true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleNOSUCHCHANNEL(msg irc.Message, client *irc.Client) {
 	channel := msg.Parameters[1]
 	message := msg.Parameters[2]
+
+	if client.FailEcho(msg, channel, message) {
+		return
+	}
 	message = fmt.Sprintf("%v: %v", channel, message)
 
 	msgOpts := irc.MsgFmtOpts{
@@ -953,33 +1289,30 @@ func handleNOSUCHCHANNEL(msg irc.Message, client *irc.Client) {
 		AsErrorMsg:    true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleCANNOTSENDTOCHAN(msg irc.Message, client *irc.Client) {
 	channel := msg.Parameters[1]
 	message := msg.Parameters[2]
 
+	// the line we sent is marked as not sent instead
+	if client.FailEcho(msg, channel, message) {
+		return
+	}
+
 	msgOpts := irc.MsgFmtOpts{
 		WithTimestamp: true,
 		AsErrorMsg:    true,
 	}
 
-	current := client.RootChannel
-	for {
-		if current.Value.Name == channel {
-			current.Value.AppendMsg(msg.DateTime, message, msgOpts)
-			return
-		}
-
-		current = current.Next
-		if current == client.RootChannel {
-			break
-		}
+	if current := client.Buffers.Get(channel); current != nil {
+		current.AppendMsg(msg.DateTime, message, msgOpts)
+		return
 	}
 
 	message = fmt.Sprintf("%v: %v", channel, message)
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleTOOMANYCHANNELS(msg irc.Message, client *irc.Client) {
@@ -991,7 +1324,7 @@ func handleTOOMANYCHANNELS(msg irc.Message, client *irc.Client) {
 		AsErrorMsg:    true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, fmt.Sprintf("%v: %v", channel, message), msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, fmt.Sprintf("%v: %v", channel, message), msgOpts)
 }
 
 func handleUNKNOWNCOMMAND(msg irc.Message, client *irc.Client) {
@@ -1002,7 +1335,7 @@ func handleUNKNOWNCOMMAND(msg irc.Message, client *irc.Client) {
 		AsErrorMsg:    true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleNOMOTD(msg irc.Message, client *irc.Client) {
@@ -1013,7 +1346,271 @@ func handleNOMOTD(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.RootChannel.AppendMsg(msg.DateTime, message, msgOpts)
+
+	handleRegistrationEnd(client)
+}
+
+// handleRegistrationEnd runs once the MOTD ends the registration burst,
+// ISUPPORT has been sent by now so we know e.g. whether we can use MONITOR.
+func handleRegistrationEnd(client *irc.Client) {
+	// a /motd later on shouldn't do this again
+	if client.Ready {
+		return
+	}
+	client.Ready = true
+
+	client.StartRegain()
+	client.StartWhoRefresh()
+	client.StartFriends()
+
+	client.Notify = func() {
+		client.Tea.Send(cmds.ReceivedIRCMsg())
+	}
+
+	if client.IsBouncerControl() {
+		client.SendCommand(commands.BOUNCER, "LISTNETWORKS")
+	}
+
+	if client.PlaybackSupported() {
+		client.RequestPlayback()
+	}
+}
+
+// BOUNCER NETWORK <netid> <attributes>
+// sent when listing the upstream networks of a soju bouncer and when they change.
+func handleBouncer(msg irc.Message, client *irc.Client) {
+	if len(msg.Parameters) < 3 || msg.Parameters[0] != "NETWORK" {
+		return
+	}
+
+	id := msg.Parameters[1]
+	attrs := irc.ParseBouncerAttributes(msg.Parameters[2])
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	if attrs == nil {
+		name := client.BouncerNetworks[id]["name"]
+		delete(client.BouncerNetworks, id)
+		client.RootChannel.AppendMsg(msg.DateTime, fmt.Sprintf("Bouncer network %s was removed", cmp.Or(name, id)), msgOpts)
+		return
+	}
+
+	if !client.IsBouncerControl() {
+		return
+	}
+
+	profile, isNew := client.AddBouncerNetwork(id, attrs)
+	if !isNew {
+		return
+	}
+
+	client.RootChannel.AppendMsg(msg.DateTime, fmt.Sprintf("Connecting to bouncer network %s", cmp.Or(attrs["name"], id)), msgOpts)
+	client.Tea.Send(cmds.ConnectNetwork(profile)())
+}
+
+// MARKREAD <target> <timestamp=...|*>
+// where we stopped reading a buffer, here or on another client.
+func handleMarkRead(msg irc.Message, client *irc.Client) {
+	if len(msg.Parameters) < 2 {
+		return
+	}
+
+	channel := client.Buffers.Get(msg.Parameters[0])
+	if channel == nil {
+		return
+	}
+
+	if marker, ok := irc.ParseReadMarker(msg.Parameters[1]); ok && marker.After(channel.ReadMarker) {
+		channel.ReadMarker = marker
+	}
+}
+
+// Standard replies (https://ircv3.net/specs/extensions/standard-replies)
+// FAIL <command> <code> [<context>...] <description>
+func handleFAIL(msg irc.Message, client *irc.Client) {
+	command := msg.Parameters[0]
+	description := msg.Parameters[len(msg.Parameters)-1]
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsErrorMsg:    true,
+	}
+
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, command+": "+description, msgOpts)
+
+	// we don't know which request failed so let them all be retried
+	if command == commands.CHATHISTORY {
+		for _, channel := range client.Buffers.All() {
+			channel.HistoryLoading = false
+		}
+	}
+}
+
+// handles ERR_ERRONEUSNICKNAME, ERR_NICKNAMEINUSE and ERR_NICKCOLLISION
+func handleNickRejected(msg irc.Message, client *irc.Client) {
+	message := msg.Parameters[1] + ": " + msg.Parameters[len(msg.Parameters)-1]
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsErrorMsg:    true,
+	}
+
+	// a /nick we sent after registering was refused, we just keep our current nick
+	if client.Registered {
+		client.ActiveChannel.AppendMsg(msg.DateTime, message, msgOpts)
+		return
+	}
+
+	client.RootChannel.AppendMsg(msg.DateTime, message, msgOpts)
+
+	next := client.NextNick()
+	if next == "" {
+		client.RootChannel.AppendMsg(msg.DateTime, "Ran out of nicknames to try, use /nick to pick another one", msgOpts)
+		return
+	}
+
+	client.RootChannel.AppendMsg(msg.DateTime, "Trying "+next+" instead", irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	})
+	client.SendCommand(commands.NICK, next)
+}
+
+func handleISON(msg irc.Message, client *irc.Client) {
+	online := strings.Fields(msg.Parameters[len(msg.Parameters)-1])
+
+	// a labeled reply is to an /ison typed by the user, the others are our own polls
+	var asked []string
+	ours := false
+	if msg.Tags["label"] == "" {
+		asked, ours = client.IsonQuery()
+	}
+
+	if ours {
+		for _, nick := range asked {
+			isOnline := slices.ContainsFunc(online, func(n string) bool {
+				return client.Casefold(n) == client.Casefold(nick)
+			})
+
+			// our primary nick is free
+			if !isOnline && client.Regaining() && client.Casefold(nick) == client.Casefold(client.Profile.Nickname) {
+				client.SendCommand(commands.NICK, client.Profile.Nickname)
+			}
+
+			friendPresence(client, msg, nick, "", isOnline)
+		}
+
+		return
+	}
+
+	message := "No one is online"
+	if len(online) > 0 {
+		message = "Online: " + strings.Join(online, " ")
+	}
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	client.ActiveChannel.AppendMsg(msg.DateTime, message, msgOpts)
+}
+
+// handles RPL_MONONLINE and RPL_MONOFFLINE
+func handleMonitorStatus(msg irc.Message, client *irc.Client) {
+	online := msg.Command == commands.RPL_MONONLINE
+
+	for _, target := range strings.Split(msg.Parameters[len(msg.Parameters)-1], ",") {
+		nick, userhost, _ := strings.Cut(target, "!")
+
+		// our primary nick is free
+		if !online && client.Regaining() && client.Casefold(nick) == client.Casefold(client.Profile.Nickname) {
+			client.SendCommand(commands.NICK, client.Profile.Nickname)
+		}
+
+		friendPresence(client, msg, nick, userhost, online)
+	}
+}
+
+// friendPresence tells us in the server buffer when a friend comes online or goes offline.
+func friendPresence(client *irc.Client, msg irc.Message, nick string, userhost string, online bool) {
+	friend, news := client.SetFriendOnline(nick, online)
+	if friend == nil {
+		return
+	}
+
+	// keep their away state and account with extended-monitor
+	if online {
+		user := client.Users.Add(nick)
+		if username, host, ok := strings.Cut(userhost, "@"); ok {
+			user.Username = username
+			user.Host = host
+		}
+	} else {
+		client.Forget(nick)
+	}
+
+	client.Tea.Send(cmds.UpdateNicks())
+
+	if !news {
+		return
+	}
+
+	message := fmt.Sprintf("%s is online", nick)
+	if !online {
+		message = fmt.Sprintf("%s went offline", nick)
+	}
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	client.RootChannel.AppendMsg(msg.DateTime, message, msgOpts)
+}
+
+func handleMONLIST(msg irc.Message, client *irc.Client) {
+	message := "Monitoring: " + strings.ReplaceAll(msg.Parameters[len(msg.Parameters)-1], ",", ", ")
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
+}
+
+func handleENDOFMONLIST(msg irc.Message, client *irc.Client) {
+	message := msg.Parameters[len(msg.Parameters)-1]
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsServerMsg:   true,
+	}
+
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
+}
+
+// <client> <limit> <targets> :Monitor list is full.
+func handleMONLISTFULL(msg irc.Message, client *irc.Client) {
+	if len(msg.Parameters) < 4 {
+		return
+	}
+
+	targets := strings.Split(msg.Parameters[2], ",")
+	client.FriendsFull(targets)
+
+	msgOpts := irc.MsgFmtOpts{
+		WithTimestamp: true,
+		AsErrorMsg:    true,
+	}
+
+	message := fmt.Sprintf("%s (limit %s), polling %s instead", msg.Parameters[3], msg.Parameters[1], strings.Join(targets, ", "))
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleNONICKNAMEGIVEN(msg irc.Message, client *irc.Client) {
@@ -1024,7 +1621,7 @@ func handleNONICKNAMEGIVEN(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleNEEDMOREPARAMS(msg irc.Message, client *irc.Client) {
@@ -1035,7 +1632,7 @@ func handleNEEDMOREPARAMS(msg irc.Message, client *irc.Client) {
 		AsErrorMsg:    true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleALREADYREGISTERED(msg irc.Message, client *irc.Client) {
@@ -1046,7 +1643,7 @@ func handleALREADYREGISTERED(msg irc.Message, client *irc.Client) {
 		AsServerMsg:   true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, message, msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, message, msgOpts)
 }
 
 func handleBADCHANMASK(msg irc.Message, client *irc.Client) {
@@ -1058,7 +1655,7 @@ func handleBADCHANMASK(msg irc.Message, client *irc.Client) {
 		AsErrorMsg:    true,
 	}
 
-	client.RootChannel.Value.AppendMsg(msg.DateTime, fmt.Sprintf("%v: %v", channel, message), msgOpts)
+	client.ReplyBuffer(msg).AppendMsg(msg.DateTime, fmt.Sprintf("%v: %v", channel, message), msgOpts)
 }
 
 func handleCHANOPRIVSNEEDED(msg irc.Message, client *irc.Client) {
@@ -1070,29 +1667,72 @@ func handleCHANOPRIVSNEEDED(msg irc.Message, client *irc.Client) {
 		AsErrorMsg:    true,
 	}
 
-	current := client.RootChannel
-	for {
-		if current.Value.Name == channel {
-			current.Value.AppendMsg(msg.DateTime, message, msgOpts)
-			break
+	if current := client.Buffers.Get(channel); current != nil {
+		current.AppendMsg(msg.DateTime, message, msgOpts)
+	}
+}
+
+func HandleCommand(msg irc.Message, client *irc.Client) {
+	// messages that belong to a batch are handled once the batch ends,
+	// BATCH itself goes through so a nested batch is started with its parent.
+	if msg.Command != commands.BATCH && client.Batches.Add(msg) {
+		return
+	}
+
+	// a labeled reply outside of a batch is the whole response to its request,
+	// labeled batches are handled once they end.
+	if label := msg.Tags["label"]; label != "" && msg.Command != commands.BATCH {
+		replies := []irc.Message{msg}
+		if msg.Command == commands.ACK {
+			replies = nil
 		}
 
-		current = current.Next
-		if current == client.RootChannel {
-			break
+		if client.Requests.Deliver(label, replies) {
+			return
 		}
+
+		handleMessage(msg, client)
+		client.Requests.Remove(label)
+	} else {
+		handleMessage(msg, client)
 	}
+
+	// send a receivedIRCmsg tea message so the ui can update
+	// we also use this tea message to scroll the viewport down
+	client.Tea.Send(cmds.ReceivedIRCMsg())
 }
 
-func HandleCommand(msg irc.Message, client *irc.Client) {
+func handleMessage(msg irc.Message, client *irc.Client) {
+	client.TrackUser(msg)
+
 	// TODO: handle different commands
 	switch msg.Command {
 	case commands.PING:
 		handlePing(msg, client)
+	case commands.BATCH:
+		handleBatch(msg, client)
+	case commands.ACK:
+		// the labeled command we sent had no reply, there's nothing to show
+	case commands.FAIL:
+		handleFAIL(msg, client)
+	case commands.BOUNCER:
+		handleBouncer(msg, client)
+	case commands.MARKREAD:
+		handleMarkRead(msg, client)
 	case commands.PRIVMSG:
 		handlePrivMsg(msg, client)
 	case commands.NOTICE:
 		handleNotice(msg, client)
+	case commands.TAGMSG:
+		handleTagMsg(msg, client)
+	case commands.AWAY:
+		handleAwayNotify(msg, client)
+	case commands.ACCOUNT:
+		handleAccount(msg, client)
+	case commands.CHGHOST:
+		handleChghost(msg, client)
+	case commands.SETNAME:
+		handleSetname(msg, client)
 	case commands.JOIN:
 		handleJoin(msg, client)
 	case commands.NICK:
@@ -1107,6 +1747,8 @@ func HandleCommand(msg irc.Message, client *irc.Client) {
 		handleTopic(msg, client)
 	case commands.CAP:
 		handleCAP(msg, client)
+	case commands.AUTHENTICATE:
+		handleAuthenticate(msg, client)
 	case commands.RPL_WELCOME:
 		handleWELCOME(msg, client)
 	case commands.RPL_YOURHOST:
@@ -1137,6 +1779,10 @@ func HandleCommand(msg irc.Message, client *irc.Client) {
 		handleUNAWAY(msg, client)
 	case commands.RPL_NOWAWAY:
 		handleNOWAWAY(msg, client)
+	case commands.RPL_WHOREPLY, commands.RPL_WHOSPCRPL:
+		handleWHOREPLY(msg, client)
+	case commands.RPL_ENDOFWHO:
+		handleENDOFWHO(msg, client)
 	case commands.RPL_WHOISUSER:
 		handleWHOISUSER(msg, client)
 	case commands.RPL_WHOISSERVER:
@@ -1175,6 +1821,8 @@ func HandleCommand(msg irc.Message, client *irc.Client) {
 
 		// start a timeout and update said timeout on every RPL_MOTD
 		// and log an error if timeout ends without receiving this command.
+
+		handleRegistrationEnd(client)
 	case commands.RPL_WHOISHOST:
 		handleWHOISHOST(msg, client)
 	case commands.RPL_WHOISMODES:
@@ -1203,6 +1851,26 @@ func HandleCommand(msg irc.Message, client *irc.Client) {
 		handleBADCHANMASK(msg, client)
 	case commands.ERR_CHANOPRIVSNEEDED:
 		handleCHANOPRIVSNEEDED(msg, client)
+	case commands.RPL_LOGGEDIN, commands.RPL_LOGGEDOUT:
+		handleLOGGEDIN(msg, client)
+	case commands.RPL_SASLSUCCESS:
+		handleSASLSUCCESS(msg, client)
+	case commands.ERR_NICKLOCKED, commands.ERR_SASLFAIL, commands.ERR_SASLTOOLONG, commands.ERR_SASLABORTED, commands.ERR_SASLALREADY:
+		handleSASLFAIL(msg, client)
+	case commands.ERR_ERRONEUSNICKNAME, commands.ERR_NICKNAMEINUSE, commands.ERR_NICKCOLLISION:
+		handleNickRejected(msg, client)
+	case commands.RPL_ISON:
+		handleISON(msg, client)
+	case commands.RPL_MONONLINE, commands.RPL_MONOFFLINE:
+		handleMonitorStatus(msg, client)
+	case commands.RPL_MONLIST:
+		handleMONLIST(msg, client)
+	case commands.RPL_ENDOFMONLIST:
+		handleENDOFMONLIST(msg, client)
+	case commands.ERR_MONLISTFULL:
+		handleMONLISTFULL(msg, client)
+	case commands.RPL_SASLMECHS:
+		// the list of mechanisms comes with ERR_SASLFAIL which already tells the user
 	default:
 		fullMsg := fmt.Sprintf(
 			"%s %s %s %s",
@@ -1217,10 +1885,6 @@ func HandleCommand(msg irc.Message, client *irc.Client) {
 			NotImpl:       true,
 		}
 
-		client.RootChannel.Value.AppendMsg(msg.DateTime, fullMsg, msgOpts)
+		client.ReplyBuffer(msg).AppendMsg(msg.DateTime, fullMsg, msgOpts)
 	}
-
-	// send a receivedIRCmsg tea message so the ui can update
-	// we also use this tea message to scroll the viewport down
-	client.Tea.Send(cmds.ReceivedIRCMsg())
 }
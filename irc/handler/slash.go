@@ -18,6 +18,8 @@ package handler
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,55 +30,35 @@ import (
 )
 
 func handleSlashPrivMsg(params []string, client *irc.Client) tea.Cmd {
-	var batchedCmds []tea.Cmd
-
 	if len(params) < 2 {
 		client.SendCommand(commands.PRIVMSG, params...)
 		return nil
 	}
 
 	target := strings.ToLower(params[0])
+	text := strings.Join(params[1:], " ")
 
-	msgOpts := irc.MsgFmtOpts{
-		WithTimestamp: true,
-	}
-	now := time.Now()
-	msg := fmt.Sprintf("%s: %s", client.Nickname, strings.Join(params[1:], " "))
-
-	c := client.RootChannel
-	for {
-		if c.Value.Name == target {
-			client.ActiveChannel = c
-
-			c.Value.AppendMsg(now, msg, msgOpts)
+	if c := client.Buffers.Get(target); c != nil {
+		client.ActiveChannel = c
+		client.SendPrivMsg(c, text)
 
-			client.SendCommand(commands.PRIVMSG, params...)
-			return cmds.SwitchChannels
-		}
-
-		c = c.Next
-		if c == client.RootChannel {
-			break
-		}
+		return cmds.SwitchChannels
 	}
 
 	// If we're messaging a user and their "channel" wasn't found in the previous loop, then create it and append it
 	if target[0] != '#' && target[0] != '&' {
-		newChannel := irc.Channel{
-			Name:  target,
-			Users: map[string]irc.User{target: {}},
-		}
+		newChannel := irc.NewChannel(target)
+		newChannel.Users[target] = irc.User{}
 
-		newChannel.AppendMsg(now, msg, msgOpts)
 		client.ActiveChannel = client.AppendChannel(newChannel)
+		client.SendPrivMsg(client.ActiveChannel, text)
 
-		batchedCmds = append(batchedCmds, cmds.UpdateTabBar)
+		return tea.Batch(cmds.UpdateTabBar, cmds.SwitchChannels)
 	}
 
-	client.SendCommand(commands.PRIVMSG, params...)
-	batchedCmds = append(batchedCmds, cmds.SwitchChannels)
+	client.SendCommand(commands.PRIVMSG, params[0], text)
 
-	return tea.Batch(batchedCmds...)
+	return cmds.SwitchChannels
 }
 
 func handleSlashJoin(params []string, client *irc.Client) tea.Cmd {
@@ -85,25 +67,424 @@ func handleSlashJoin(params []string, client *irc.Client) tea.Cmd {
 		channel = strings.ToLower(params[0])
 	}
 
-	c := client.RootChannel
-	for {
-		if c.Value.Name == channel {
-			client.ActiveChannel = c
-			return cmds.SwitchChannels
+	if c := client.Buffers.Get(channel); c != nil {
+		client.ActiveChannel = c
+		return cmds.SwitchChannels
+	}
+
+	client.SendCommand(commands.JOIN, params...)
+
+	return cmds.SwitchChannels
+}
+
+// /connect <host>[:port] [-tls] [nickname]
+// /connect <irc url> [-tls] [nickname]
+func handleSlashConnect(params []string, client *irc.Client) tea.Cmd {
+	if len(params) < 1 {
+		client.ActiveChannel.AppendMsg(time.Now(), "Usage: /connect <host>[:port]|<irc url> [-tls] [nickname]", irc.MsgFmtOpts{AsErrorMsg: true})
+		return nil
+	}
+
+	// keep our identity but nothing that's specific to the current network
+	profile := irc.Profile{
+		Host:     params[0],
+		Nickname: client.Profile.Nickname,
+		AltNicks: client.Profile.AltNicks,
+		Username: client.Profile.Username,
+		Realname: client.Profile.Realname,
+	}
+
+	isURL := strings.Contains(params[0], "://")
+	if isURL {
+		u, err := irc.ParseURL(params[0])
+		if err != nil {
+			client.ActiveChannel.AppendMsg(time.Now(), err.Error(), irc.MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true})
+			return cmds.ReceivedIRCMsg
 		}
 
-		c = c.Next
-		if c == client.RootChannel {
-			break
+		profile = u.Profile(profile)
+	}
+
+	for _, param := range params[1:] {
+		if param == "-tls" {
+			profile.TLS = true
+		} else {
+			profile.Nickname = param
 		}
 	}
-	client.SendCommand(commands.JOIN, params...)
 
-	return cmds.SwitchChannels
+	if isURL {
+		return cmds.ConnectNetwork(profile)
+	}
+
+	if host, port, err := net.SplitHostPort(params[0]); err == nil {
+		profile.Host = host
+		profile.Port = port
+	} else if profile.TLS {
+		profile.Port = "6697"
+	} else {
+		profile.Port = "6667"
+	}
+
+	return cmds.ConnectNetwork(profile)
+}
+
+// /disconnect [network] [reason]
+func handleSlashDisconnect(params []string) tea.Cmd {
+	network := ""
+	reason := ""
+
+	if len(params) > 0 {
+		network = params[0]
+	}
+
+	if len(params) > 1 {
+		reason = strings.Join(params[1:], " ")
+	}
+
+	return cmds.DisconnectNetwork(network, reason)
+}
+
+// /cap ls|list|req <caps>|drop <caps>
+func handleSlashCap(params []string, client *irc.Client) {
+	usage := "Usage: /cap ls|list|req <capabilities>|drop <capabilities>"
+
+	if len(params) < 1 {
+		client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	switch strings.ToLower(params[0]) {
+	case "ls":
+		client.SendCommand(commands.CAP, "LS", "302")
+	case "list":
+		client.SendCommand(commands.CAP, "LIST")
+	case "req":
+		if len(params) < 2 {
+			client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+			return
+		}
+
+		client.RequestCaps(params[1:])
+	case "drop":
+		if len(params) < 2 {
+			client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+			return
+		}
+
+		var caps []string
+		for _, capability := range params[1:] {
+			caps = append(caps, "-"+strings.TrimPrefix(capability, "-"))
+		}
+
+		client.RequestCaps(caps)
+	default:
+		client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+	}
+}
+
+// /friend add <nick>
+// /friend remove <nick>
+// /friend list
+func handleSlashFriend(params []string, client *irc.Client) {
+	usage := "Usage: /friend add|remove <nick> or /friend list"
+	msgOpts := irc.MsgFmtOpts{WithTimestamp: true, AsServerMsg: true}
+
+	if len(params) < 1 || (strings.ToLower(params[0]) != "list" && len(params) < 2) {
+		client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	switch strings.ToLower(params[0]) {
+	case "add":
+		message := params[1] + " is already a friend"
+		if client.AddFriend(params[1]) {
+			message = "Added " + params[1] + " to your friends"
+		}
+
+		client.ActiveChannel.AppendMsg(time.Now(), message, msgOpts)
+	case "remove", "del":
+		message := params[1] + " isn't a friend"
+		if client.RemoveFriend(params[1]) {
+			message = "Removed " + params[1] + " from your friends"
+		}
+
+		client.ActiveChannel.AppendMsg(time.Now(), message, msgOpts)
+	case "list":
+		var friends []string
+		for _, friend := range client.Friends() {
+			status := "offline"
+			if friend.Online {
+				status = "online"
+			} else if !friend.Known {
+				status = "unknown"
+			}
+
+			friends = append(friends, fmt.Sprintf("%s (%s)", friend.Nick, status))
+		}
+
+		message := "No friends on this network yet, add some with /friend add <nick>"
+		if len(friends) > 0 {
+			message = "Friends: " + strings.Join(friends, ", ")
+		}
+
+		client.ActiveChannel.AppendMsg(time.Now(), message, msgOpts)
+	default:
+		client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+	}
+}
+
+// /me <action>
+func handleSlashMe(params []string, client *irc.Client) {
+	if len(params) < 1 {
+		client.ActiveChannel.AppendMsg(time.Now(), "Usage: /me <action>", irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	if client.ActiveChannel == client.RootChannel {
+		client.ActiveChannel.AppendMsg(time.Now(), "Can't send an action to the server buffer", irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	client.SendAction(client.ActiveChannel, strings.Join(params, " "))
+}
+
+// /ctcp <nick> <command> [params]
+func handleSlashCTCP(params []string, client *irc.Client) {
+	if len(params) < 2 {
+		client.ActiveChannel.AppendMsg(time.Now(), "Usage: /ctcp <nick> <command> [params], e.g. /ctcp alice VERSION", irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	client.SendCTCP(params[0], params[1], strings.Join(params[2:], " "))
+}
+
+// /dcc chat [-passive] <nick>
+// /dcc close [nick]
+func handleSlashDCCChat(params []string, client *irc.Client) tea.Cmd {
+	usage := "Usage: /dcc chat [-passive] <nick> or /dcc close [nick]"
+	errOpts := irc.MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true}
+
+	if strings.ToLower(params[0]) == "close" {
+		buffer := client.ActiveChannel
+		if len(params) > 1 {
+			buffer = client.Buffers.Get(irc.DirectName(params[1]))
+		}
+
+		if buffer == nil || !buffer.IsDirect() {
+			client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+			return nil
+		}
+
+		// the first close ends the chat and the second one closes its buffer
+		if buffer.Direct.Status() != irc.ChatClosed {
+			client.CloseChat(buffer)
+			return nil
+		}
+
+		client.RemoveChannel(buffer)
+		return tea.Batch(cmds.UpdateTabBar, cmds.SwitchChannels)
+	}
+
+	args := params[1:]
+	passive := len(args) > 0 && args[0] == "-passive"
+	if passive {
+		args = args[1:]
+	}
+
+	if len(args) < 1 {
+		client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+		return nil
+	}
+
+	if buffer := client.Buffers.Get(irc.DirectName(args[0])); buffer != nil && buffer.Direct.Status() != irc.ChatClosed {
+		client.ActiveChannel = buffer
+		return cmds.SwitchChannels
+	}
+
+	// accept their offer if they already made one
+	if chat := client.PendingChat(args[0]); chat != nil {
+		if err := client.AcceptChat(chat); err != nil {
+			client.ActiveChannel.AppendMsg(time.Now(), err.Error(), errOpts)
+			return nil
+		}
+
+		return tea.Batch(cmds.UpdateTabBar, cmds.SwitchChannels)
+	}
+
+	buffer, err := client.OfferChat(args[0], passive)
+	if err != nil {
+		client.ActiveChannel.AppendMsg(time.Now(), err.Error(), errOpts)
+		return nil
+	}
+
+	client.ActiveChannel = buffer
+	return tea.Batch(cmds.UpdateTabBar, cmds.SwitchChannels)
+}
+
+// /dcc send [-passive] <nick> <file>
+// /dcc chat [-passive] <nick>
+// /dcc accept|reject <id>
+// /dcc close [nick]
+// /dcc list
+func handleSlashDCC(params []string, client *irc.Client) tea.Cmd {
+	usage := "Usage: /dcc send [-passive] <nick> <file>, /dcc chat [-passive] <nick>, /dcc accept|reject <id>, /dcc close [nick] or /dcc list"
+	errOpts := irc.MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true}
+
+	if len(params) < 1 {
+		client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+		return nil
+	}
+
+	switch strings.ToLower(params[0]) {
+	case "chat", "close":
+		return handleSlashDCCChat(params, client)
+	case "send":
+		args := params[1:]
+		passive := len(args) > 0 && args[0] == "-passive"
+		if passive {
+			args = args[1:]
+		}
+
+		if len(args) < 2 {
+			client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+			return nil
+		}
+
+		path := irc.ExpandHome(strings.Join(args[1:], " "))
+		if _, err := client.SendFile(args[0], path, passive); err != nil {
+			client.ActiveChannel.AppendMsg(time.Now(), err.Error(), errOpts)
+		}
+	case "accept", "reject":
+		if len(params) < 2 {
+			client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+			return nil
+		}
+
+		id, _ := strconv.Atoi(strings.TrimPrefix(params[1], "#"))
+		transfer := client.Transfers.Get(id)
+		if transfer == nil {
+			client.ActiveChannel.AppendMsg(time.Now(), "No DCC #"+params[1], errOpts)
+			return nil
+		}
+
+		if strings.ToLower(params[0]) == "reject" {
+			client.RejectTransfer(transfer)
+			return nil
+		}
+
+		if err := client.AcceptTransfer(transfer); err != nil {
+			client.ActiveChannel.AppendMsg(time.Now(), err.Error(), errOpts)
+		}
+	case "list":
+		transfers := client.Transfers.All()
+		if len(transfers) == 0 {
+			client.ActiveChannel.AppendMsg(time.Now(), "No DCC transfers", irc.MsgFmtOpts{WithTimestamp: true, AsServerMsg: true})
+		}
+
+		for _, transfer := range transfers {
+			client.ActiveChannel.AppendMsg(time.Now(), transfer.String(), irc.MsgFmtOpts{WithTimestamp: true, AsServerMsg: true})
+		}
+	default:
+		client.ActiveChannel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+	}
+
+	return nil
+}
+
+// parseHistoryTime accepts a date, a date and time or a duration meaning that long ago.
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// /history <from> [to]
+// times are e.g. 2022-03-01, 2022-03-01T18:30 or 2h for two hours ago, to defaults to now.
+func handleSlashHistory(params []string, client *irc.Client) {
+	usage := "Usage: /history <from> [to], e.g. /history 2022-03-01 2022-03-02 or /history 2h"
+	channel := client.ActiveChannel
+
+	if len(params) < 1 || len(params) > 2 {
+		channel.AppendMsg(time.Now(), usage, irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	if !client.HistorySupported() {
+		channel.AppendMsg(time.Now(), "This server doesn't support chathistory", irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	if channel == client.RootChannel {
+		channel.AppendMsg(time.Now(), "There's no history for the server buffer", irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	if channel.IsDirect() {
+		channel.AppendMsg(time.Now(), "Direct chats don't go through the server so it has no history of them", irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	now := time.Now()
+	to := now
+
+	from, err := parseHistoryTime(params[0], now)
+	if err == nil && len(params) == 2 {
+		to, err = parseHistoryTime(params[1], now)
+	}
+
+	if err != nil {
+		channel.AppendMsg(now, err.Error()+". "+usage, irc.MsgFmtOpts{AsErrorMsg: true})
+		return
+	}
+
+	client.RequestHistoryBetween(channel, from, to)
+}
+
+// SlashCommands are the commands tab completion offers and what their arguments complete to.
+// Anything else is still sent to the server as is.
+var SlashCommands = []irc.SlashCommand{
+	{Name: "away"},
+	{Name: "cap", Subcommands: []string{"drop", "list", "ls", "req"}},
+	{Name: "connect"},
+	{Name: "ctcp", Args: []irc.ArgKind{irc.ArgNick}},
+	{Name: "dcc", Subcommands: []string{"accept", "chat", "close", "list", "reject", "send"}, Args: []irc.ArgKind{irc.ArgNick}},
+	{Name: "disconnect"},
+	{Name: "friend", Subcommands: []string{"add", "list", "remove"}, Args: []irc.ArgKind{irc.ArgNick}},
+	{Name: "history"},
+	{Name: "invite", Args: []irc.ArgKind{irc.ArgNick, irc.ArgChannel}},
+	{Name: "join", Args: []irc.ArgKind{irc.ArgChannel}},
+	{Name: "kick", Args: []irc.ArgKind{irc.ArgChannel, irc.ArgNick}},
+	{Name: "list"},
+	{Name: "me"},
+	{Name: "mode"},
+	{Name: "monitor"},
+	{Name: "motd"},
+	{Name: "names", Args: []irc.ArgKind{irc.ArgChannel}},
+	{Name: "nick"},
+	{Name: "notice"},
+	{Name: "part", Args: []irc.ArgKind{irc.ArgChannel}},
+	{Name: "privmsg"},
+	{Name: "quit"},
+	{Name: "topic", Args: []irc.ArgKind{irc.ArgChannel}},
+	{Name: "who"},
+	{Name: "whois", Args: []irc.ArgKind{irc.ArgNick}},
 }
 
 func HandleSlashCommand(msg string, client *irc.Client) tea.Cmd {
 	substrs := strings.Fields(msg[1:])
+	if len(substrs) == 0 {
+		return nil
+	}
+
 	command := strings.ToUpper(substrs[0])
 	var params []string
 	if len(substrs) > 1 {
@@ -116,10 +497,32 @@ func HandleSlashCommand(msg string, client *irc.Client) tea.Cmd {
 	case commands.JOIN:
 		return handleSlashJoin(params, client)
 	case commands.QUIT:
-		client.SendCommand(command, params...)
-		return cmds.Quit(client)
+		return cmds.Quit(client.Session, strings.Join(params, " "))
+	// these manage our own connections and shadow the operator CONNECT command
+	case "CONNECT":
+		return handleSlashConnect(params, client)
+	case "DISCONNECT":
+		return handleSlashDisconnect(params)
+	case commands.CAP:
+		handleSlashCap(params, client)
+		return cmds.ReceivedIRCMsg
+	case "HISTORY":
+		handleSlashHistory(params, client)
+		return cmds.ReceivedIRCMsg
+	case "FRIEND":
+		handleSlashFriend(params, client)
+		return tea.Batch(cmds.ReceivedIRCMsg, cmds.UpdateNicks)
+	case "ME":
+		handleSlashMe(params, client)
+		return cmds.ReceivedIRCMsg
+	case "CTCP":
+		handleSlashCTCP(params, client)
+		return cmds.ReceivedIRCMsg
+	case "DCC":
+		return tea.Batch(cmds.ReceivedIRCMsg, handleSlashDCC(params, client))
 	default:
-		client.SendCommand(command, params...)
+		// replies to labeled commands show up in the buffer the command was typed in
+		client.SendLabeled(command, params...)
 		return nil
 	}
 }
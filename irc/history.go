@@ -0,0 +1,114 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// Number of messages fetched from chathistory at a time unless configured otherwise
+const DefaultHistoryLimit = 100
+
+// FormatTimestamp formats a time the way server-time and chathistory expect it.
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// ParseTimestamp parses a server-time or chathistory timestamp, servers don't all send milliseconds
+// so any RFC 3339 time is accepted.
+func ParseTimestamp(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return t.Local(), nil
+}
+
+// HistorySupported reports whether we can fetch history from the server.
+// (https://ircv3.net/specs/extensions/chathistory)
+func (c *Client) HistorySupported() bool {
+	_, ok := c.EnabledCapabilities["draft/chathistory"]
+	return ok && c.HistoryLimit >= 0
+}
+
+// historyLimit returns how many messages to ask for, the server might allow less than we want.
+func (c *Client) historyLimit() int {
+	limit := c.HistoryLimit
+	if limit == 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	if max, err := strconv.Atoi(c.EnabledFeatures["CHATHISTORY"]); err == nil && max > 0 {
+		limit = min(limit, max)
+	}
+
+	return limit
+}
+
+// RequestLatestHistory fetches the latest messages of a buffer, e.g. right after joining it.
+func (c *Client) RequestLatestHistory(channel *Channel) {
+	if !c.HistorySupported() || channel.HistoryLoading {
+		return
+	}
+
+	channel.HistoryLoading = true
+	c.SendCommand(commands.CHATHISTORY, "LATEST", channel.Name, "*", strconv.Itoa(c.historyLimit()))
+}
+
+// RequestHistoryBefore fetches the messages before the oldest one we have of a buffer.
+// Nothing is requested while a request is still loading or once there's nothing older.
+func (c *Client) RequestHistoryBefore(channel *Channel) {
+	if !c.HistorySupported() || channel.HistoryLoading || channel.HistoryExhausted || channel == c.RootChannel || channel.IsDirect() {
+		return
+	}
+
+	// older history wouldn't be kept, and we'd keep fetching it
+	if channel.History.Full() {
+		return
+	}
+
+	oldest := channel.History.At(0)
+	if oldest == nil {
+		c.RequestLatestHistory(channel)
+		return
+	}
+
+	reference := "timestamp=" + FormatTimestamp(oldest.DateTime)
+	if oldest.MsgID != "" {
+		reference = "msgid=" + oldest.MsgID
+	}
+
+	channel.HistoryLoading = true
+	c.SendCommand(commands.CHATHISTORY, "BEFORE", channel.Name, reference, strconv.Itoa(c.historyLimit()))
+}
+
+// RequestHistoryBetween fetches the messages of a buffer in the given time range.
+func (c *Client) RequestHistoryBetween(channel *Channel, from time.Time, to time.Time) {
+	channel.HistoryLoading = true
+	c.SendCommand(
+		commands.CHATHISTORY,
+		"BETWEEN",
+		channel.Name,
+		"timestamp="+FormatTimestamp(from),
+		"timestamp="+FormatTimestamp(to),
+		strconv.Itoa(c.historyLimit()),
+	)
+}
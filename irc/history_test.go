@@ -0,0 +1,103 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	t.Run("Test paging back", func(t *testing.T) {
+		client, server := newTestClient(t)
+		client.EnabledCapabilities = Capabilities{"draft/chathistory": ""}
+		client.EnabledFeatures = Features{"CHATHISTORY": "50"}
+
+		channel := client.AppendChannel(NewChannel("#gorc"))
+		line := channel.AppendMsg(time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC), "bob: hi", MsgFmtOpts{})
+		channel.History.Update(line, func(line *Line) {
+			line.MsgID = "abc"
+		})
+
+		go client.RequestHistoryBefore(channel)
+		if request, _ := server.ReadString('\n'); request != "CHATHISTORY BEFORE #gorc msgid=abc 50\r\n" {
+			t.Fatalf("Wrong request: %q", request)
+		}
+
+		// nothing is requested while the last request is loading
+		client.RequestHistoryBefore(channel)
+
+		channel.HistoryLoading = false
+		channel.History.Update(line, func(line *Line) {
+			line.MsgID = ""
+		})
+
+		go client.RequestHistoryBefore(channel)
+		if request, _ := server.ReadString('\n'); request != "CHATHISTORY BEFORE #gorc timestamp=2022-03-01T10:00:00.000Z 50\r\n" {
+			t.Fatalf("Wrong request: %q", request)
+		}
+
+		channel.HistoryLoading = false
+		channel.HistoryExhausted = true
+
+		// would block on the pipe if it was sent
+		client.RequestHistoryBefore(channel)
+
+		// nothing older fits in a full scrollback
+		channel.HistoryExhausted = false
+		channel.History.SetMaxLines(1)
+		client.RequestHistoryBefore(channel)
+	})
+
+	t.Run("Test timestamps", func(t *testing.T) {
+		want := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC)
+		for _, value := range []string{"2022-03-01T10:00:00.000Z", "2022-03-01T10:00:00Z", "2022-03-01T10:00:00.000000Z", "2022-03-01T11:00:00+01:00"} {
+			if parsed, err := ParseTimestamp(value); err != nil || !parsed.Equal(want) {
+				t.Fatal("Wrong time for", value, parsed, err)
+			}
+		}
+
+		msg := Message{Tags: MessageTags{"time": "yesterday"}}
+		msg.SetTimestamp()
+		if time.Since(msg.DateTime) > time.Minute {
+			t.Fatal("An invalid server-time should fall back to now")
+		}
+
+		if marker, ok := ParseReadMarker("timestamp=2022-03-01T10:00:00Z"); !ok || !marker.Equal(want) {
+			t.Fatal("Read marker without milliseconds wasn't parsed")
+		}
+	})
+
+	t.Run("Test msgid index", func(t *testing.T) {
+		sb := NewScrollback(10)
+		for i := 0; i < 20; i++ {
+			line := sb.Append(time.Now(), "line", MsgFmtOpts{})
+			id := string(rune('a' + i))
+			sb.Update(line, func(line *Line) {
+				line.MsgID = id
+			})
+		}
+
+		if !sb.HasMsgID("t") {
+			t.Fatal("Newest msgid should be indexed")
+		}
+
+		if sb.HasMsgID("a") {
+			t.Fatal("Evicted msgid should be forgotten")
+		}
+	})
+}
@@ -0,0 +1,155 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+var ErrNoLabeledResponse = errors.New("the server doesn't support labeled-response")
+
+// Request is a command we sent with a label, its replies carry the same label.
+// (https://ircv3.net/specs/extensions/labeled-response)
+type Request struct {
+	Label string
+
+	// The buffer the command was sent from, replies are shown there
+	Buffer *Channel
+
+	// Receives the replies of requests made with Do, nil for commands typed by the user
+	replies chan []Message
+}
+
+// Requests keeps the labeled requests that are still waiting for a reply.
+type Requests struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[string]*Request
+}
+
+func NewRequests() *Requests {
+	return &Requests{
+		pending: make(map[string]*Request),
+	}
+}
+
+func (r *Requests) add(buffer *Channel, wait bool) *Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.next++
+	req := &Request{
+		Label:  "gorc" + strconv.FormatUint(r.next, 10),
+		Buffer: buffer,
+	}
+
+	if wait {
+		req.replies = make(chan []Message, 1)
+	}
+
+	r.pending[req.Label] = req
+
+	return req
+}
+
+// Get returns the pending request with the given label or nil if there's none.
+func (r *Requests) Get(label string) *Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.pending[label]
+}
+
+// Remove forgets about a request once all of its replies were handled.
+func (r *Requests) Remove(label string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pending, label)
+}
+
+// Deliver hands the replies to a request made with Do.
+// It returns false if nobody is waiting for them and they should be handled like any other message.
+func (r *Requests) Deliver(label string, messages []Message) bool {
+	r.mu.Lock()
+	req, ok := r.pending[label]
+	if ok && req.replies != nil {
+		delete(r.pending, label)
+	}
+	r.mu.Unlock()
+
+	if !ok || req.replies == nil {
+		return false
+	}
+
+	req.replies <- messages
+	return true
+}
+
+// SendLabeled sends a command with a label if the server supports labeled-response
+// so its replies are shown in the buffer it was sent from.
+func (c *Client) SendLabeled(cmd string, params ...string) {
+	if _, ok := c.EnabledCapabilities["labeled-response"]; !ok {
+		c.SendCommand(cmd, params...)
+		return
+	}
+
+	req := c.Requests.add(c.ActiveChannel, false)
+	c.SendTagged(MessageTags{"label": req.Label}, cmd, params...)
+}
+
+// Do sends a command and waits for its replies, a labeled batch is returned as its messages.
+// An ACK without any replies returns no messages.
+func (c *Client) Do(ctx context.Context, msg Message) ([]Message, error) {
+	if _, ok := c.EnabledCapabilities["labeled-response"]; !ok {
+		return nil, ErrNoLabeledResponse
+	}
+
+	req := c.Requests.add(nil, true)
+
+	tags := make(MessageTags, len(msg.Tags)+1)
+	for key, value := range msg.Tags {
+		tags[key] = value
+	}
+	tags["label"] = req.Label
+
+	c.SendTagged(tags, msg.Command, msg.Parameters...)
+
+	select {
+	case messages := <-req.replies:
+		return messages, nil
+	case <-ctx.Done():
+		c.Requests.Remove(req.Label)
+		return nil, ctx.Err()
+	}
+}
+
+// ReplyBuffer returns the buffer replies to msg should go in,
+// that's the buffer its request was sent from or the server buffer if we don't know.
+func (c *Client) ReplyBuffer(msg Message) *Channel {
+	if req := c.Requests.Get(msg.Tags["label"]); req != nil && req.Buffer != nil {
+		// the buffer might have been closed since
+		if c.Buffers.Get(req.Buffer.Name) == req.Buffer {
+			return req.Buffer
+		}
+	}
+
+	return c.RootChannel
+}
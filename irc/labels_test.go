@@ -0,0 +1,117 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T) (*Client, *bufio.Reader) {
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	client := &Client{
+		TCPConn:             clientConn,
+		Buffers:             NewBuffers(),
+		Users:               NewUsers(),
+		EnabledCapabilities: Capabilities{"labeled-response": ""},
+		Requests:            NewRequests(),
+		Transfers:           NewTransfers(),
+	}
+	client.RootChannel = client.AppendChannel(NewChannel("server"))
+	client.ActiveChannel = client.RootChannel
+
+	return client, bufio.NewReader(serverConn)
+}
+
+func TestLabels(t *testing.T) {
+	t.Run("Test tags formatting", func(t *testing.T) {
+		tags := FormatTags(MessageTags{"label": "a b;c", "+typing": "active", "draft/flag": ""})
+		if tags != `@+typing=active;draft/flag;label=a\sb\:c ` {
+			t.Fatal("Wrong tags:", tags)
+		}
+	})
+
+	t.Run("Test Do", func(t *testing.T) {
+		client, server := newTestClient(t)
+
+		go func() {
+			line, _ := server.ReadString('\n')
+			label := strings.TrimPrefix(strings.SplitN(line, " ", 2)[0], "@label=")
+
+			client.Requests.Deliver(label, []Message{{Command: "311"}, {Command: "318"}})
+		}()
+
+		replies, err := client.Do(context.Background(), Message{Command: "WHOIS", Parameters: []string{"bob"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(replies) != 2 || replies[1].Command != "318" {
+			t.Fatal("Wrong replies:", replies)
+		}
+	})
+
+	t.Run("Test Do timeout", func(t *testing.T) {
+		client, server := newTestClient(t)
+		go server.ReadString('\n')
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := client.Do(ctx, Message{Command: "WHOIS", Parameters: []string{"bob"}})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("Expected a timeout, got", err)
+		}
+
+		if len(client.Requests.pending) != 0 {
+			t.Fatal("The request should be forgotten after timing out")
+		}
+	})
+
+	t.Run("Test reply buffer", func(t *testing.T) {
+		client, server := newTestClient(t)
+		go server.ReadString('\n')
+
+		channel := client.AppendChannel(NewChannel("#gorc"))
+		client.ActiveChannel = channel
+		client.SendLabeled("WHOIS", "bob")
+
+		reply := Message{Command: "311", Tags: MessageTags{"label": "gorc1"}}
+		if client.ReplyBuffer(reply) != channel {
+			t.Fatal("Labeled reply should go to the buffer the command was sent from")
+		}
+
+		if client.ReplyBuffer(Message{Command: "311"}) != client.RootChannel {
+			t.Fatal("Unlabeled reply should go to the server buffer")
+		}
+
+		client.Buffers.Remove("#gorc")
+		if client.ReplyBuffer(reply) != client.RootChannel {
+			t.Fatal("Reply to a closed buffer should go to the server buffer")
+		}
+	})
+}
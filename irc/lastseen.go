@@ -0,0 +1,102 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LastSeen keeps the time of the last message we saw on each network across sessions.
+type LastSeen struct {
+	mu    sync.Mutex
+	path  string
+	times map[string]time.Time
+}
+
+// LastSeenPath returns where the last seen timestamps are stored.
+func LastSeenPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "gorc", "last-seen.json"), nil
+}
+
+// LoadLastSeen reads the last seen timestamps, a missing file means we haven't seen anything yet.
+// An empty store that overwrites the file when saved is returned along with any error.
+func LoadLastSeen(path string) (*LastSeen, error) {
+	ls := &LastSeen{
+		path:  path,
+		times: make(map[string]time.Time),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ls, nil
+	}
+
+	if err != nil {
+		return ls, err
+	}
+
+	if err := json.Unmarshal(data, &ls.times); err != nil {
+		ls.times = make(map[string]time.Time)
+		return ls, err
+	}
+
+	return ls, nil
+}
+
+func (ls *LastSeen) Get(key string) time.Time {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	return ls.times[key]
+}
+
+// Set updates the last seen time of a network if it's later than the one we have.
+func (ls *LastSeen) Set(key string, t time.Time) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if t.After(ls.times[key]) {
+		ls.times[key] = t
+	}
+}
+
+func (ls *LastSeen) Save() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	data, err := json.MarshalIndent(ls.times, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ls.path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(ls.path, data, 0o600)
+}
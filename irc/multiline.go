@@ -0,0 +1,277 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// Servers without draft/multiline get long and multi-line messages as several PRIVMSGs,
+// the first FloodBurst go out right away and the rest FloodDelay apart so we don't get kicked for flooding.
+var (
+	FloodBurst = 4
+	FloodDelay = time.Second
+)
+
+// Used when the server doesn't say how big a multiline batch can be
+const defaultMultilineBytes = 4096
+
+// MultilineLimits is how big a multiline batch the server accepts, 0 lines means no limit.
+// (https://ircv3.net/specs/extensions/multiline)
+type MultilineLimits struct {
+	MaxBytes int
+	MaxLines int
+}
+
+// ParseMultilineLimits parses the value of the draft/multiline capability, e.g. "max-bytes=4096,max-lines=100".
+func ParseMultilineLimits(value string) MultilineLimits {
+	limits := MultilineLimits{MaxBytes: defaultMultilineBytes}
+
+	for _, token := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(token, "=")
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			continue
+		}
+
+		switch key {
+		case "max-bytes":
+			limits.MaxBytes = n
+		case "max-lines":
+			limits.MaxLines = n
+		}
+	}
+
+	return limits
+}
+
+// multilineLimits returns the server's multiline limits and whether we can send multiline batches at all.
+func (c *Client) multilineLimits() (MultilineLimits, bool) {
+	value, ok := c.EnabledCapabilities["draft/multiline"]
+	if !ok {
+		return MultilineLimits{}, false
+	}
+
+	if _, ok := c.EnabledCapabilities["batch"]; !ok {
+		return MultilineLimits{}, false
+	}
+
+	return ParseMultilineLimits(value), true
+}
+
+// maxTextLength is how much text fits in one PRIVMSG to target once the server
+// prefixes it with our full source, assuming the longest user and host we could have.
+func (c *Client) maxTextLength(target string) int {
+	const maxUser = 10
+	const maxHost = 63
+
+	overhead := len(":!@ "+commands.PRIVMSG+"  :"+CRLF) + len(c.Nickname) + maxUser + maxHost + len(target)
+
+	return max(512-overhead, 1)
+}
+
+// splitText cuts text into chunks of at most limit bytes that join back into the original text.
+// It prefers cutting after a space and never cuts a character in half.
+func splitText(text string, limit int) []string {
+	var chunks []string
+
+	for len(text) > limit {
+		cut := strings.LastIndexByte(text[:limit], ' ') + 1
+		if cut <= 0 {
+			cut = limit
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+
+			// a single character longer than the limit, there's nothing better to do
+			if cut == 0 {
+				_, cut = utf8.DecodeRuneInString(text)
+			}
+		}
+
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+
+	return append(chunks, text)
+}
+
+// multilinePart is one PRIVMSG of a multiline batch,
+// Concat parts continue the previous part instead of starting a new line.
+type multilinePart struct {
+	Text   string
+	Concat bool
+}
+
+// multilineBatches splits text into the parts of as many batches as it takes to stay within the limits,
+// with no part longer than lineLength. A line is moved to the next batch whole if it can be
+// so a batch doesn't start in the middle of a line.
+func multilineBatches(text string, lineLength int, limits MultilineLimits) [][]multilinePart {
+	var batches [][]multilinePart
+	var batch []multilinePart
+	size := 0
+
+	// index in batch of the first part of the line being added
+	lineStart := 0
+
+	sizeOf := func(parts []multilinePart) int {
+		n := 0
+		for i, part := range parts {
+			if i > 0 && !part.Concat {
+				n++
+			}
+			n += len(part.Text)
+		}
+
+		return n
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		lineStart = len(batch)
+
+		for i, chunk := range splitText(line, lineLength) {
+			part := multilinePart{Text: chunk, Concat: i > 0}
+
+			cost := len(part.Text)
+			if len(batch) > 0 && !part.Concat {
+				cost++
+			}
+
+			full := limits.MaxLines > 0 && len(batch) >= limits.MaxLines
+			if len(batch) > 0 && (full || size+cost > limits.MaxBytes) {
+				var carried []multilinePart
+				if part.Concat && lineStart > 0 {
+					moved := batch[lineStart:]
+					fits := sizeOf(moved)+len(part.Text) <= limits.MaxBytes
+					if fits && (limits.MaxLines == 0 || len(moved) < limits.MaxLines) {
+						carried = append(carried, moved...)
+						batch = batch[:lineStart]
+					}
+				}
+
+				batches = append(batches, batch)
+				batch = carried
+				lineStart = 0
+
+				// the line is too big for a batch of its own so it has to be broken up
+				if len(batch) == 0 {
+					part.Concat = false
+				}
+
+				size = sizeOf(batch)
+				cost = len(part.Text)
+				if len(batch) > 0 && !part.Concat {
+					cost++
+				}
+			}
+
+			batch = append(batch, part)
+			size += cost
+		}
+	}
+
+	return append(batches, batch)
+}
+
+// joinParts puts the parts of a batch back together into the text they make up.
+func joinParts(parts []multilinePart) string {
+	var text strings.Builder
+	for i, part := range parts {
+		if i > 0 && !part.Concat {
+			text.WriteByte('\n')
+		}
+		text.WriteString(part.Text)
+	}
+
+	return text.String()
+}
+
+// sendLines sends a message with several lines or that's too long for a single PRIVMSG.
+func (c *Client) sendLines(buffer *Channel, text string, tags MessageTags) []*Line {
+	lineLength := c.maxTextLength(buffer.Name)
+
+	limits, ok := c.multilineLimits()
+	if !ok {
+		return c.sendSplit(buffer, text, lineLength, tags)
+	}
+
+	var lines []*Line
+	for _, parts := range multilineBatches(text, lineLength, limits) {
+		line, tags := c.showPrivMsg(buffer, joinParts(parts), tags)
+		lines = append(lines, line)
+
+		c.batchRef++
+		ref := fmt.Sprintf("ml%d", c.batchRef)
+
+		c.SendTagged(tags, commands.BATCH, "+"+ref, "draft/multiline", buffer.Name)
+		for _, part := range parts {
+			partTags := MessageTags{"batch": ref}
+			if part.Concat {
+				partTags["draft/multiline-concat"] = ""
+			}
+
+			c.SendTagged(partTags, commands.PRIVMSG, buffer.Name, part.Text)
+		}
+		c.SendCommand(commands.BATCH, "-"+ref)
+	}
+
+	return lines
+}
+
+// sendSplit sends every line of text as its own PRIVMSG, splitting long lines and skipping blank ones.
+// Everything is shown in the buffer right away and sent in the background with flood control.
+func (c *Client) sendSplit(buffer *Channel, text string, lineLength int, tags MessageTags) []*Line {
+	type privMsg struct {
+		tags MessageTags
+		text string
+	}
+
+	var msgs []privMsg
+	var lines []*Line
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		for _, chunk := range splitText(line, lineLength) {
+			shown, tags := c.showPrivMsg(buffer, chunk, tags)
+			lines = append(lines, shown)
+			msgs = append(msgs, privMsg{tags, chunk})
+		}
+	}
+
+	go func() {
+		c.floodMu.Lock()
+		defer c.floodMu.Unlock()
+
+		for i, msg := range msgs {
+			if i >= FloodBurst {
+				time.Sleep(FloodDelay)
+			}
+
+			c.SendTagged(msg.tags, commands.PRIVMSG, buffer.Name, msg.text)
+		}
+	}()
+
+	return lines
+}
@@ -0,0 +1,126 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMultiline(t *testing.T) {
+	t.Run("Test splitting text", func(t *testing.T) {
+		text := "hello there general kenobi"
+		chunks := splitText(text, 10)
+
+		if strings.Join(chunks, "") != text {
+			t.Fatal("Chunks don't join back into the text:", chunks)
+		}
+
+		for _, chunk := range chunks {
+			if len(chunk) > 10 {
+				t.Fatal("Chunk is over the limit:", chunk)
+			}
+		}
+
+		if chunks[0] != "hello " {
+			t.Fatal("Text should be cut after a space:", chunks)
+		}
+
+		chunks = splitText("ééééé", 3)
+		if len(chunks) != 5 || chunks[0] != "é" {
+			t.Fatal("A character was cut in half:", chunks)
+		}
+	})
+
+	t.Run("Test batch limits", func(t *testing.T) {
+		limits := MultilineLimits{MaxBytes: 20, MaxLines: 3}
+		batches := multilineBatches("one\ntwo\nthree\nfour", 100, limits)
+
+		if len(batches) != 2 || len(batches[0]) != 3 || joinParts(batches[1]) != "four" {
+			t.Fatal("max-lines wasn't respected:", batches)
+		}
+
+		batches = multilineBatches("aaaa bbbb cccc\ndddd eeee ffff", 10, MultilineLimits{MaxBytes: 20})
+		for _, batch := range batches {
+			if batch[0].Concat {
+				t.Fatal("A batch shouldn't start with a concat line:", batches)
+			}
+
+			if len(joinParts(batch)) > 20 {
+				t.Fatal("max-bytes wasn't respected:", batches)
+			}
+		}
+
+		if joinParts(batches[0]) != "aaaa bbbb cccc" || !batches[0][1].Concat {
+			t.Fatal("Long line wasn't split with concat:", batches)
+		}
+	})
+
+	t.Run("Test receiving a batch", func(t *testing.T) {
+		bs := NewBatches()
+
+		batch := bs.Start(Message{Command: "BATCH", Parameters: []string{"+ml", "draft/multiline", "#gorc"}, Tags: MessageTags{"msgid": "abc"}})
+		bs.Add(Message{Command: "PRIVMSG", Source: "alice", Parameters: []string{"#gorc", "hello "}, Tags: MessageTags{"batch": "ml"}})
+		bs.Add(Message{Command: "PRIVMSG", Source: "alice", Parameters: []string{"#gorc", "world"}, Tags: MessageTags{"batch": "ml", "draft/multiline-concat": ""}})
+		bs.Add(Message{Command: "PRIVMSG", Source: "alice", Parameters: []string{"#gorc", "second line"}, Tags: MessageTags{"batch": "ml"}})
+		bs.End("ml")
+
+		msg, ok := batch.Multiline()
+		if !ok || msg.Parameters[1] != "hello world\nsecond line" {
+			t.Fatal("Lines weren't joined properly:", msg)
+		}
+
+		if msg.Tags["msgid"] != "abc" || msg.Source != "alice" {
+			t.Fatal("Message should have the batch's msgid:", msg)
+		}
+
+		if _, ok := msg.Tags["batch"]; ok {
+			t.Fatal("Joined message shouldn't belong to the batch anymore")
+		}
+	})
+
+	t.Run("Test sending a batch", func(t *testing.T) {
+		client, server := newTestClient(t)
+		client.Nickname = "bob"
+		client.EnabledCapabilities = Capabilities{"batch": "", "draft/multiline": "max-bytes=4096"}
+		channel := client.AppendChannel(NewChannel("#gorc"))
+
+		go client.SendPrivMsg(channel, "one\ntwo")
+
+		expected := []string{
+			"BATCH +ml1 draft/multiline #gorc",
+			"@batch=ml1 PRIVMSG #gorc one",
+			"@batch=ml1 PRIVMSG #gorc two",
+			"BATCH -ml1",
+		}
+
+		for _, want := range expected {
+			line, err := server.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.TrimSuffix(line, CRLF) != want {
+				t.Fatalf("Expected %q, got %q", want, line)
+			}
+		}
+
+		if channel.History.Len() != 1 {
+			t.Fatal("A multiline message should be shown as a single line")
+		}
+	})
+}
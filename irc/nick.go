@@ -0,0 +1,123 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// How many suffixed nicks we try once the alternate nicks are used up
+const maxSuffixedNicks = 10
+
+// How often we check whether our primary nick is free when the server doesn't support MONITOR
+var RegainInterval = time.Minute
+
+// NextNick returns the nick to try after the server refused the last one during registration,
+// the alternate nicks come first and then the primary nick with a suffix.
+// An empty string means we ran out of nicks to try.
+func (c *Client) NextNick() string {
+	c.nickAttempts++
+
+	alts := c.Profile.AltNicks
+	if c.nickAttempts <= len(alts) {
+		return alts[c.nickAttempts-1]
+	}
+
+	n := c.nickAttempts - len(alts)
+	if n > maxSuffixedNicks {
+		return ""
+	}
+
+	nicklen, _ := strconv.Atoi(c.EnabledFeatures["NICKLEN"])
+
+	return suffixNick(c.Profile.Nickname, n, nicklen)
+}
+
+// suffixNick returns nick_ for the first attempt and nick1, nick2... after that,
+// cutting the nick short so it fits in maxLen if it's known.
+func suffixNick(nick string, n int, maxLen int) string {
+	suffix := "_"
+	if n > 1 {
+		suffix = strconv.Itoa(n - 1)
+	}
+
+	if maxLen > len(suffix) && len(nick)+len(suffix) > maxLen {
+		nick = nick[:maxLen-len(suffix)]
+	}
+
+	return nick + suffix
+}
+
+// StartRegain starts watching for our primary nick to become free if we didn't get it,
+// using MONITOR if the server supports it and ISON every RegainInterval otherwise.
+func (c *Client) StartRegain() {
+	if !c.Profile.RegainNick || c.regaining || c.IsMe(c.Profile.Nickname) {
+		return
+	}
+
+	c.regaining = true
+
+	if _, ok := c.EnabledFeatures["MONITOR"]; ok {
+		c.SendCommand(commands.MONITOR, "+", c.Profile.Nickname)
+		return
+	}
+
+	stop := make(chan struct{})
+	c.stopRegain = stop
+
+	go func() {
+		ticker := time.NewTicker(RegainInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.SendIson(c.Profile.Nickname)
+			}
+		}
+	}()
+}
+
+// StopRegain stops watching for our primary nick.
+func (c *Client) StopRegain() {
+	if !c.regaining {
+		return
+	}
+
+	c.regaining = false
+
+	if c.stopRegain != nil {
+		close(c.stopRegain)
+		c.stopRegain = nil
+		return
+	}
+
+	// a friend with the same nick still wants to be monitored
+	if c.Registered && c.Friend(c.Profile.Nickname) == nil {
+		c.SendCommand(commands.MONITOR, "-", c.Profile.Nickname)
+	}
+}
+
+// Regaining reports whether we're waiting for our primary nick to become free.
+func (c *Client) Regaining() bool {
+	return c.regaining
+}
@@ -0,0 +1,57 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import "testing"
+
+func TestNextNick(t *testing.T) {
+	t.Run("Test alternate nicks then suffixes", func(t *testing.T) {
+		client := &Client{Profile: Profile{Nickname: "bob", AltNicks: []string{"bobby", "robert"}}}
+
+		expected := []string{"bobby", "robert", "bob_", "bob1", "bob2"}
+		for _, nick := range expected {
+			if next := client.NextNick(); next != nick {
+				t.Fatalf("Expected %s, got %s", nick, next)
+			}
+		}
+	})
+
+	t.Run("Test running out of nicks", func(t *testing.T) {
+		client := &Client{Profile: Profile{Nickname: "bob"}}
+
+		for i := 0; i < maxSuffixedNicks; i++ {
+			if client.NextNick() == "" {
+				t.Fatal("Ran out of nicks too early")
+			}
+		}
+
+		if next := client.NextNick(); next != "" {
+			t.Fatal("Expected no more nicks, got", next)
+		}
+	})
+
+	t.Run("Test nick length", func(t *testing.T) {
+		client := &Client{
+			Profile:         Profile{Nickname: "abcdefghi"},
+			EnabledFeatures: Features{"NICKLEN": "9"},
+		}
+
+		if next := client.NextNick(); next != "abcdefgh_" {
+			t.Fatal("Expected the nick to be cut short, got", next)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	t.Run("Test AUTHENTICATE chunks", func(t *testing.T) {
+		chunks := AuthenticateChunks("Ym9i")
+		if len(chunks) != 1 || chunks[0] != "Ym9i" {
+			t.Fatal("Wrong short chunks:", chunks)
+		}
+
+		chunks = AuthenticateChunks(strings.Repeat("a", 450))
+		if len(chunks) != 2 || len(chunks[0]) != 400 || len(chunks[1]) != 50 {
+			t.Fatal("Response wasn't split at 400 bytes")
+		}
+
+		chunks = AuthenticateChunks(strings.Repeat("a", 800))
+		if len(chunks) != 3 || chunks[2] != "+" {
+			t.Fatal("Expected a trailing + after a full chunk, got", len(chunks), "chunks")
+		}
+	})
+}
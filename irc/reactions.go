@@ -0,0 +1,210 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// Length a replied-to message is cut to when it's quoted
+const maxQuoteLength = 80
+
+var ErrClientTagDenied = errors.New("the server doesn't allow replies and reactions")
+var ErrNoMsgID = errors.New("the message has no id to refer to")
+
+// Reaction is an emoji a message was reacted to with and the casefolded nicks that used it.
+// (https://ircv3.net/specs/client-tags/react)
+type Reaction struct {
+	Emoji string
+	Nicks []string
+}
+
+func (l *Line) renderReactions() string {
+	counts := make([]string, len(l.Reactions))
+	for i, reaction := range l.Reactions {
+		counts[i] = fmt.Sprintf("[%s %d]", reaction.Emoji, len(reaction.Nicks))
+	}
+
+	return reactionStyle.Render(strings.Join(counts, " "))
+}
+
+func (l *Line) renderQuote() string {
+	quote := l.Quote
+	if quote == "" {
+		quote = "(reply to a message that isn't loaded)"
+	}
+
+	return quoteStyle.Render("┃ " + quote)
+}
+
+// quoteOf cuts a message down to a single line snippet for quoting it.
+func quoteOf(content string) string {
+	return ansi.Truncate(strings.ReplaceAll(StripFormatting(content), "\n", " "), maxQuoteLength, "…")
+}
+
+// SetTags stores what the line needs from its message's tags, its msgid and the message it replies to.
+// (https://ircv3.net/specs/client-tags/reply)
+func (sb *Scrollback) SetTags(line *Line, tags MessageTags) {
+	id := tags["msgid"]
+	parent := tags["+draft/reply"]
+	if id == "" && parent == "" {
+		return
+	}
+
+	quote := ""
+	if parentLine := sb.ByMsgID(parent); parentLine != nil {
+		quote = quoteOf(parentLine.Content)
+	}
+
+	sb.Update(line, func(line *Line) {
+		line.MsgID = id
+		line.ReplyTo = parent
+		line.Quote = quote
+	})
+}
+
+// React adds or removes a nick's reaction to the message with the given msgid.
+// It returns false if that message isn't in the scrollback.
+func (sb *Scrollback) React(id string, nick string, emoji string, remove bool) bool {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	line := sb.ids[id]
+	if line == nil {
+		return false
+	}
+
+	i := slices.IndexFunc(line.Reactions, func(reaction Reaction) bool {
+		return reaction.Emoji == emoji
+	})
+
+	switch {
+	case remove && i == -1:
+		return true
+	case remove:
+		nicks := slices.DeleteFunc(line.Reactions[i].Nicks, func(n string) bool { return n == nick })
+		if len(nicks) == 0 {
+			line.Reactions = slices.Delete(line.Reactions, i, i+1)
+		} else {
+			line.Reactions[i].Nicks = nicks
+		}
+	case i == -1:
+		line.Reactions = append(line.Reactions, Reaction{Emoji: emoji, Nicks: []string{nick}})
+	case !slices.Contains(line.Reactions[i].Nicks, nick):
+		line.Reactions[i].Nicks = append(line.Reactions[i].Nicks, nick)
+	}
+
+	line.wrapped = nil
+	return true
+}
+
+// ClientTagAllowed reports whether the server relays the given client-only tag (without its "+").
+func (c *Client) ClientTagAllowed(tag string) bool {
+	if _, ok := c.EnabledCapabilities["message-tags"]; !ok {
+		return false
+	}
+
+	denied := false
+	if deny, ok := c.EnabledFeatures["CLIENTTAGDENY"]; ok {
+		for _, entry := range strings.Split(deny, ",") {
+			switch entry {
+			case "*":
+				denied = true
+			case tag:
+				return false
+			case "-" + tag:
+				return true
+			}
+		}
+	}
+
+	return !denied
+}
+
+// SendReply sends a message to the buffer's target as a reply to one of its lines.
+func (c *Client) SendReply(buffer *Channel, parent *Line, text string) error {
+	if !c.ClientTagAllowed("draft/reply") {
+		return ErrClientTagDenied
+	}
+
+	if parent.MsgID == "" {
+		return ErrNoMsgID
+	}
+
+	// every message a long or multi-line reply is sent as is a reply
+	for _, line := range c.sendMessage(buffer, text, MessageTags{"+draft/reply": parent.MsgID}) {
+		buffer.History.Update(line, func(line *Line) {
+			line.ReplyTo = parent.MsgID
+			line.Quote = quoteOf(parent.Content)
+		})
+	}
+
+	return nil
+}
+
+// SendReaction reacts to one of the buffer's lines with the given emoji.
+// Without echo-message the reaction is shown right away, otherwise once the server echoes it.
+func (c *Client) SendReaction(buffer *Channel, parent *Line, emoji string) error {
+	if !c.ClientTagAllowed("draft/react") {
+		return ErrClientTagDenied
+	}
+
+	if parent.MsgID == "" {
+		return ErrNoMsgID
+	}
+
+	tags := MessageTags{
+		"+draft/react": emoji,
+		"+draft/reply": parent.MsgID,
+	}
+	c.SendTagged(tags, commands.TAGMSG, buffer.Name)
+
+	if _, ok := c.EnabledCapabilities["echo-message"]; !ok {
+		buffer.History.React(parent.MsgID, c.Casefold(c.Nickname), emoji, false)
+	}
+
+	return nil
+}
+
+// HandleReaction applies a reaction from a TAGMSG to the message it's about.
+// It returns false if the TAGMSG isn't a reaction.
+func (c *Client) HandleReaction(buffer *Channel, msg Message) bool {
+	parent := msg.Tags["+draft/reply"]
+	if parent == "" {
+		return false
+	}
+
+	nick := c.Casefold(strings.SplitN(msg.Source, "!", 2)[0])
+
+	if emoji := msg.Tags["+draft/react"]; emoji != "" {
+		buffer.History.React(parent, nick, emoji, false)
+		return true
+	}
+
+	if emoji := msg.Tags["+draft/unreact"]; emoji != "" {
+		buffer.History.React(parent, nick, emoji, true)
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,140 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReactions(t *testing.T) {
+	t.Run("Test reply quote", func(t *testing.T) {
+		sb := NewScrollback(0)
+		parent := sb.Append(time.Now(), "alice: hello", MsgFmtOpts{})
+		sb.SetTags(parent, MessageTags{"msgid": "abc"})
+
+		reply := sb.Append(time.Now(), "bob: hi", MsgFmtOpts{})
+		sb.SetTags(reply, MessageTags{"msgid": "def", "+draft/reply": "abc"})
+
+		if reply.ReplyTo != "abc" || reply.Quote != "alice: hello" {
+			t.Fatal("Reply wasn't quoted:", reply)
+		}
+
+		if rows := sb.Rows(1, 100); len(rows) != 2 || !strings.Contains(rows[0], "alice: hello") {
+			t.Fatal("Quote isn't rendered above the reply:", rows)
+		}
+	})
+
+	t.Run("Test counting reactions", func(t *testing.T) {
+		sb := NewScrollback(0)
+		line := sb.Append(time.Now(), "alice: hello", MsgFmtOpts{})
+		sb.SetTags(line, MessageTags{"msgid": "abc"})
+
+		sb.React("abc", "bob", "👍", false)
+		sb.React("abc", "carol", "👍", false)
+		sb.React("abc", "bob", "👍", false)
+		sb.React("abc", "bob", "🎉", false)
+
+		if len(line.Reactions) != 2 || len(line.Reactions[0].Nicks) != 2 {
+			t.Fatal("Wrong reaction counts:", line.Reactions)
+		}
+
+		sb.React("abc", "bob", "🎉", true)
+		if len(line.Reactions) != 1 {
+			t.Fatal("Reaction wasn't removed:", line.Reactions)
+		}
+
+		if sb.React("missing", "bob", "👍", false) {
+			t.Fatal("Reacted to a message that isn't in the scrollback")
+		}
+	})
+
+	t.Run("Test CLIENTTAGDENY", func(t *testing.T) {
+		client := &Client{
+			EnabledCapabilities: Capabilities{"message-tags": ""},
+			EnabledFeatures:     Features{"CLIENTTAGDENY": "*,-draft/react"},
+		}
+
+		if !client.ClientTagAllowed("draft/react") || client.ClientTagAllowed("draft/reply") {
+			t.Fatal("Wrong tags allowed")
+		}
+
+		client.EnabledFeatures = Features{"CLIENTTAGDENY": "typing"}
+		if client.ClientTagAllowed("typing") || !client.ClientTagAllowed("draft/reply") {
+			t.Fatal("Wrong tags allowed")
+		}
+	})
+
+	t.Run("Test sending a reaction", func(t *testing.T) {
+		client, server := newTestClient(t)
+		client.Nickname = "bob"
+		client.EnabledCapabilities = Capabilities{"message-tags": ""}
+		client.EnabledFeatures = Features{}
+		channel := client.AppendChannel(NewChannel("#gorc"))
+
+		line := channel.AppendMsg(time.Now(), "alice: hello", MsgFmtOpts{})
+		if err := client.SendReaction(channel, line, "👍"); err != ErrNoMsgID {
+			t.Fatal("Reacted to a line without a msgid")
+		}
+
+		channel.History.SetTags(line, MessageTags{"msgid": "abc"})
+		go client.SendReaction(channel, line, "👍")
+
+		sent, _ := server.ReadString('\n')
+		if sent != "@+draft/react=👍;+draft/reply=abc TAGMSG #gorc\r\n" {
+			t.Fatalf("Wrong TAGMSG sent: %q", sent)
+		}
+	})
+
+	t.Run("Test sending a multi-line reply", func(t *testing.T) {
+		client, server := newTestClient(t)
+		client.Nickname = "bob"
+		client.EnabledCapabilities = Capabilities{"message-tags": ""}
+		client.EnabledFeatures = Features{}
+		channel := client.AppendChannel(NewChannel("#gorc"))
+
+		parent := channel.AppendMsg(time.Now(), "alice: hello", MsgFmtOpts{})
+		channel.History.SetTags(parent, MessageTags{"msgid": "abc"})
+
+		if err := client.SendReply(channel, parent, "one\ntwo"); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range []string{"@+draft/reply=abc PRIVMSG #gorc one\r\n", "@+draft/reply=abc PRIVMSG #gorc two\r\n"} {
+			if sent, _ := server.ReadString('\n'); sent != want {
+				t.Fatalf("Expected %q, got %q", want, sent)
+			}
+		}
+
+		for i := 1; i < channel.History.Len(); i++ {
+			if line := channel.History.At(i); line.ReplyTo != "abc" {
+				t.Fatal("Line isn't shown as a reply:", line.Content)
+			}
+		}
+	})
+
+	t.Run("Test line breaks in params", func(t *testing.T) {
+		client, server := newTestClient(t)
+
+		go client.SendCommand("PRIVMSG", "#gorc", "hi\r\nQUIT :bye")
+
+		if sent, _ := server.ReadString('\n'); sent != "PRIVMSG #gorc :hiQUIT :bye\r\n" {
+			t.Fatalf("Line break wasn't stripped: %q", sent)
+		}
+	})
+}
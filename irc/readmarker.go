@@ -0,0 +1,51 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// MarkRead tells the server we've read a buffer up to its last line
+// so our other clients know where we stopped reading.
+// (https://ircv3.net/specs/extensions/read-marker)
+func (c *Client) MarkRead(channel *Channel) {
+	if _, ok := c.EnabledCapabilities["draft/read-marker"]; !ok || channel == c.RootChannel || channel.IsDirect() {
+		return
+	}
+
+	last := channel.History.At(channel.History.Len() - 1)
+	if last == nil || !last.DateTime.After(channel.ReadMarker) {
+		return
+	}
+
+	channel.ReadMarker = last.DateTime
+	c.SendCommand(commands.MARKREAD, channel.Name, "timestamp="+FormatTimestamp(last.DateTime))
+}
+
+// ParseReadMarker parses the timestamp of a MARKREAD message, "*" means the buffer was never read.
+func ParseReadMarker(value string) (time.Time, bool) {
+	t, err := ParseTimestamp(strings.TrimPrefix(value, "timestamp="))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
@@ -0,0 +1,337 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/illusionman1212/gorc/ui"
+)
+
+// The amount of lines a buffer keeps in memory unless configured otherwise.
+const DefaultMaxLines = 5000
+
+type LineStatus int
+
+const (
+	LineSent LineStatus = iota
+
+	// Our own message that the server hasn't echoed back yet
+	LinePending
+
+	// Our own message that the server refused
+	LineFailed
+)
+
+type Line struct {
+	DateTime time.Time
+
+	// The unstyled message text
+	Content string
+
+	Opts MsgFmtOpts
+
+	// The server's id for this message if it sent one
+	MsgID string
+
+	Status LineStatus
+
+	// Why the server refused the message
+	FailReason string
+
+	// msgid of the message this one replies to and a snippet of it that's shown as a quote
+	ReplyTo string
+	Quote   string
+
+	// Emoji reactions to this message in the order they were first used
+	Reactions []Reaction
+
+	// Whether this is the first line of a new day,
+	// a date separator is rendered above it if it is.
+	NewDay bool
+
+	// absolute position of the line in its scrollback,
+	// this doesn't change when older lines are evicted.
+	pos int
+
+	// cached rendering of the line, wrapped at wrapWidth
+	wrapWidth int
+	wrapped   []string
+}
+
+// Scrollback holds the message history of a buffer.
+// Lines are only rendered and wrapped when they're asked for and the result is cached
+// per width, so appending a line or rendering a window of a huge history stays cheap.
+type Scrollback struct {
+	mu    sync.Mutex
+	lines []*Line
+
+	// absolute position of lines[0]
+	base int
+
+	// lines indexed by the server's msgid so history isn't shown twice
+	ids map[string]*Line
+
+	// Maximum number of lines kept in memory, older lines are evicted first.
+	// 0 means unlimited.
+	MaxLines int
+}
+
+func NewScrollback(maxLines int) *Scrollback {
+	return &Scrollback{
+		MaxLines: maxLines,
+	}
+}
+
+func (l *Line) Render() string {
+	prefixes := ""
+	style := ui.DefaultStyle
+
+	if l.Opts.WithTimestamp {
+		str := fmt.Sprintf("[%02d:%02d]", l.DateTime.Hour(), l.DateTime.Minute())
+		prefixes += timestampStyle.Render(str) + " "
+	}
+
+	if l.Opts.NotImpl {
+		prefixes += unimpl + " "
+	}
+
+	if l.Opts.AsServerMsg {
+		prefixes += serverMsgStyle.Render("==") + " "
+		style = serverMsgStyle
+	}
+
+	if l.Opts.AsErrorMsg {
+		prefixes += errorMsgStyle.Render("==") + " "
+		style = errorMsgStyle
+	}
+
+	content := l.Content
+
+	switch l.Status {
+	case LinePending:
+		style = pendingMsgStyle
+	case LineFailed:
+		style = errorMsgStyle
+		content += " (not sent: " + l.FailReason + ")"
+	}
+
+	// lines are styled one by one so lipgloss doesn't pad them all to the longest one
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = RenderFormatting(line, style)
+	}
+
+	rendered := prefixes + strings.Join(lines, "\n")
+
+	if len(l.Reactions) > 0 {
+		rendered += " " + l.renderReactions()
+	}
+
+	if l.ReplyTo != "" {
+		rendered = l.renderQuote() + "\n" + rendered
+	}
+
+	if l.NewDay {
+		dateMsg := fmt.Sprintf("————— %s %d —————", l.DateTime.Month().String(), l.DateTime.Day())
+		rendered = dateStyle.Render(dateMsg) + "\n" + rendered
+	}
+
+	return rendered
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+
+	return ay == by && am == bm && ad == bd
+}
+
+// Append adds a line to the end of the scrollback and evicts old lines if the cap is exceeded.
+func (sb *Scrollback) Append(datetime time.Time, content string, opts MsgFmtOpts) *Line {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	return sb.insertAt(len(sb.lines), datetime, content, opts)
+}
+
+// Insert adds a line in timestamp order after any lines with the same time,
+// for history that arrives after newer lines were already appended.
+func (sb *Scrollback) Insert(datetime time.Time, content string, opts MsgFmtOpts) *Line {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	i := sort.Search(len(sb.lines), func(i int) bool {
+		return sb.lines[i].DateTime.After(datetime)
+	})
+
+	return sb.insertAt(i, datetime, content, opts)
+}
+
+func (sb *Scrollback) insertAt(i int, datetime time.Time, content string, opts MsgFmtOpts) *Line {
+	line := &Line{
+		DateTime: datetime,
+		Content:  content,
+		Opts:     opts,
+		NewDay:   true,
+		pos:      sb.base + i,
+	}
+
+	if i > 0 {
+		line.NewDay = !sameDay(sb.lines[i-1].DateTime, datetime)
+	}
+
+	sb.lines = append(sb.lines, nil)
+	copy(sb.lines[i+1:], sb.lines[i:])
+	sb.lines[i] = line
+
+	for _, later := range sb.lines[i+1:] {
+		later.pos++
+	}
+
+	// the line after this one might not start a new day anymore
+	if i+1 < len(sb.lines) {
+		next := sb.lines[i+1]
+		next.NewDay = !sameDay(datetime, next.DateTime)
+		next.wrapped = nil
+	}
+
+	sb.evict()
+
+	return line
+}
+
+// Update changes a line in place and makes sure it's rendered again.
+func (sb *Scrollback) Update(line *Line, update func(line *Line)) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	update(line)
+	line.wrapped = nil
+
+	if line.MsgID != "" {
+		if sb.ids == nil {
+			sb.ids = make(map[string]*Line)
+		}
+		sb.ids[line.MsgID] = line
+	}
+}
+
+// ByMsgID returns the line with the given msgid or nil if it isn't in the scrollback.
+func (sb *Scrollback) ByMsgID(id string) *Line {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	return sb.ids[id]
+}
+
+// HasMsgID reports whether a line with the given msgid is in the scrollback.
+func (sb *Scrollback) HasMsgID(id string) bool {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	_, ok := sb.ids[id]
+	return ok
+}
+
+// evict drops the oldest lines once the cap is exceeded by a tenth
+// so we don't shift the whole slice on every single append.
+func (sb *Scrollback) evict() {
+	if sb.MaxLines <= 0 || len(sb.lines) <= sb.MaxLines+sb.MaxLines/10 {
+		return
+	}
+
+	excess := len(sb.lines) - sb.MaxLines
+	for _, line := range sb.lines[:excess] {
+		delete(sb.ids, line.MsgID)
+	}
+
+	sb.lines = append([]*Line(nil), sb.lines[excess:]...)
+	sb.base += excess
+}
+
+// SetMaxLines changes the cap of the scrollback and evicts any lines over it.
+func (sb *Scrollback) SetMaxLines(n int) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	sb.MaxLines = n
+	sb.evict()
+}
+
+// Full reports whether the scrollback reached its cap, older lines added now would be evicted right away.
+func (sb *Scrollback) Full() bool {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	return sb.MaxLines > 0 && len(sb.lines) >= sb.MaxLines
+}
+
+func (sb *Scrollback) Len() int {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	return len(sb.lines)
+}
+
+// At returns the line at index i or nil if it's out of range.
+func (sb *Scrollback) At(i int) *Line {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if i < 0 || i >= len(sb.lines) {
+		return nil
+	}
+
+	return sb.lines[i]
+}
+
+// IndexOf returns the current index of the line, or -1 if it was evicted.
+func (sb *Scrollback) IndexOf(line *Line) int {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	i := line.pos - sb.base
+	if i < 0 || i >= len(sb.lines) || sb.lines[i] != line {
+		return -1
+	}
+
+	return i
+}
+
+// Rows returns the line at index i rendered and wrapped to the given width.
+func (sb *Scrollback) Rows(i int, width int) []string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if i < 0 || i >= len(sb.lines) {
+		return nil
+	}
+
+	line := sb.lines[i]
+	if line.wrapped == nil || line.wrapWidth != width {
+		line.wrapped = strings.Split(ansi.Wrap(line.Render(), width, ""), "\n")
+		line.wrapWidth = width
+	}
+
+	return line.wrapped
+}
@@ -0,0 +1,107 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestScrollback(t *testing.T) {
+	t.Run("Test eviction", func(t *testing.T) {
+		sb := NewScrollback(100)
+		first := sb.Append(time.Now(), "first", MsgFmtOpts{})
+
+		for i := 0; i < 200; i++ {
+			sb.Append(time.Now(), fmt.Sprint(i), MsgFmtOpts{})
+		}
+
+		if sb.Len() > 110 {
+			t.Fatal("Scrollback grew past its cap")
+		}
+
+		if sb.IndexOf(first) != -1 {
+			t.Fatal("Evicted line still has an index")
+		}
+
+		last := sb.At(sb.Len() - 1)
+		if last.Content != "199" || sb.IndexOf(last) != sb.Len()-1 {
+			t.Fatal("Newest line was not kept")
+		}
+	})
+
+	t.Run("Test date separators", func(t *testing.T) {
+		sb := NewScrollback(0)
+		day := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.Local)
+
+		a := sb.Append(day, "a", MsgFmtOpts{})
+		b := sb.Append(day.Add(time.Hour), "b", MsgFmtOpts{})
+		c := sb.Append(day.Add(24*time.Hour), "c", MsgFmtOpts{})
+
+		if !a.NewDay || b.NewDay || !c.NewDay {
+			t.Fatal("Wrong date separators")
+		}
+	})
+
+	t.Run("Test wrapping", func(t *testing.T) {
+		sb := NewScrollback(0)
+		day := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.Local)
+		sb.Append(day, "aaaa bbbb cccc dddd eeee", MsgFmtOpts{})
+
+		if rows := sb.Rows(0, 20); len(rows) != 3 {
+			t.Fatalf("Expected 3 rows (including date separator), got %d", len(rows))
+		}
+
+		if rows := sb.Rows(0, 100); len(rows) != 2 {
+			t.Fatalf("Expected 2 rows after resizing, got %d", len(rows))
+		}
+	})
+
+	t.Run("Test wrapping formatted lines", func(t *testing.T) {
+		sb := NewScrollback(0)
+		day := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.Local)
+		sb.Append(day, "\x02aaaa\x02 \x0304bbbb\x03 \x1Dcccc dddd\x1D eeee", MsgFmtOpts{})
+
+		if rows := sb.Rows(0, 20); len(rows) != 3 {
+			t.Fatalf("Formatting codes shouldn't change wrapping, expected 3 rows, got %d", len(rows))
+		}
+	})
+
+	t.Run("Test ordered insert", func(t *testing.T) {
+		sb := NewScrollback(0)
+		day := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.Local)
+
+		sb.Append(day, "first", MsgFmtOpts{})
+		last := sb.Append(day.Add(24*time.Hour), "last", MsgFmtOpts{})
+		middle := sb.Insert(day.Add(12*time.Hour), "middle", MsgFmtOpts{})
+
+		if sb.IndexOf(middle) != 1 || sb.IndexOf(last) != 2 {
+			t.Fatal("Line wasn't inserted in order")
+		}
+
+		before := sb.Insert(day.Add(-24*time.Hour), "before", MsgFmtOpts{})
+		if sb.IndexOf(before) != 0 || sb.At(1).Content != "first" || !sb.At(1).NewDay {
+			t.Fatal("Wrong order or date separator after inserting at the start")
+		}
+
+		sb.Insert(day.Add(23*time.Hour), "same day as last", MsgFmtOpts{})
+		if last.NewDay {
+			t.Fatal("Line after the insert should no longer start a new day")
+		}
+	})
+}
@@ -0,0 +1,159 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Session holds every network we're connected to.
+// Each network has its own client with its own buffers, capabilities and nickname.
+type Session struct {
+	// Connected networks in the order they appear in the tab bar
+	Networks []*Client
+
+	// The network whose active buffer is shown
+	Active *Client
+
+	// Reference to the bubbletea program
+	Tea *tea.Program
+
+	// Maximum number of lines kept in memory for each buffer of new networks
+	ScrollbackLimit int
+
+	// Number of messages fetched from chathistory at a time for new networks
+	HistoryLimit int
+
+	// Don't send typing notifications on new networks
+	DisableTyping bool
+
+	// DCC settings of new networks
+	DownloadDir string
+	DCCAddress  string
+	DCCWaitAcks bool
+
+	// Time of the last message seen on each network, used for bouncer playback
+	LastSeen *LastSeen
+}
+
+// NewClient creates a client that belongs to this session.
+func (s *Session) NewClient() *Client {
+	return &Client{
+		Session:         s,
+		Tea:             s.Tea,
+		ScrollbackLimit: s.ScrollbackLimit,
+		HistoryLimit:    s.HistoryLimit,
+		DisableTyping:   s.DisableTyping,
+		DownloadDir:     s.DownloadDir,
+		DCCAddress:      s.DCCAddress,
+		DCCWaitAcks:     s.DCCWaitAcks,
+	}
+}
+
+// Add appends a network to the session and makes it the active one.
+func (s *Session) Add(client *Client) {
+	s.Networks = append(s.Networks, client)
+	s.Active = client
+}
+
+// Remove drops a network from the session,
+// the previous network becomes active if it was the active one.
+func (s *Session) Remove(client *Client) {
+	for i, c := range s.Networks {
+		if c != client {
+			continue
+		}
+
+		s.Networks = append(s.Networks[:i], s.Networks[i+1:]...)
+
+		if s.Active == client {
+			s.Active = nil
+			if len(s.Networks) > 0 {
+				s.Active = s.Networks[max(0, i-1)]
+			}
+		}
+
+		return
+	}
+}
+
+// Find returns the network with the given name or host, or nil if there's none.
+func (s *Session) Find(name string) *Client {
+	for _, client := range s.Networks {
+		if strings.EqualFold(client.NetworkName(), name) || strings.EqualFold(client.Host, name) {
+			return client
+		}
+	}
+
+	return nil
+}
+
+func (s *Session) index(client *Client) int {
+	for i, c := range s.Networks {
+		if c == client {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// NextBuffer activates the buffer after the active one,
+// moving on to the next network after the last buffer of a network.
+func (s *Session) NextBuffer() {
+	if s.Active == nil {
+		return
+	}
+
+	buffers := s.Active.Buffers
+	if buffers.Index(s.Active.ActiveChannel.Name) < buffers.Len()-1 {
+		s.Active.ActiveChannel = buffers.Next(s.Active.ActiveChannel)
+		return
+	}
+
+	s.Active = s.Networks[(s.index(s.Active)+1)%len(s.Networks)]
+	s.Active.ActiveChannel = s.Active.RootChannel
+}
+
+// PrevBuffer activates the buffer before the active one,
+// moving on to the last buffer of the previous network after the first buffer of a network.
+func (s *Session) PrevBuffer() {
+	if s.Active == nil {
+		return
+	}
+
+	buffers := s.Active.Buffers
+	if buffers.Index(s.Active.ActiveChannel.Name) > 0 {
+		s.Active.ActiveChannel = buffers.Prev(s.Active.ActiveChannel)
+		return
+	}
+
+	s.Active = s.Networks[(s.index(s.Active)-1+len(s.Networks))%len(s.Networks)]
+	s.Active.ActiveChannel = s.Active.Buffers.At(s.Active.Buffers.Len() - 1)
+}
+
+// BufferCount returns the number of buffers across all networks.
+func (s *Session) BufferCount() int {
+	count := 0
+	for _, client := range s.Networks {
+		count += client.Buffers.Len()
+	}
+
+	return count
+}
@@ -0,0 +1,164 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+// Typing states of the +typing client tag.
+// (https://ircv3.net/specs/client-tags/typing)
+const (
+	TypingActive = "active"
+	TypingPaused = "paused"
+	TypingDone   = "done"
+)
+
+const (
+	// Minimum time between two active notifications we send in a buffer
+	TypingThrottle = 3 * time.Second
+
+	// How long we wait after the last keypress before saying we paused
+	TypingPauseAfter = 3 * time.Second
+
+	// How long others' states last without being renewed
+	ActiveTypingTimeout = 6 * time.Second
+	PausedTypingTimeout = 30 * time.Second
+)
+
+type typist struct {
+	Nick    string
+	State   string
+	Expires time.Time
+}
+
+// Typing tracks who's typing in a buffer.
+type Typing struct {
+	mu      sync.Mutex
+	typists []typist
+}
+
+func NewTyping() *Typing {
+	return &Typing{}
+}
+
+// Set changes a nick's typing state, done removes it.
+func (t *Typing) Set(nick string, state string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, typist := range t.typists {
+		if strings.EqualFold(typist.Nick, nick) {
+			t.typists = append(t.typists[:i], t.typists[i+1:]...)
+			break
+		}
+	}
+
+	timeout := ActiveTypingTimeout
+	switch state {
+	case TypingActive:
+	case TypingPaused:
+		timeout = PausedTypingTimeout
+	default:
+		return
+	}
+
+	t.typists = append(t.typists, typist{Nick: nick, State: state, Expires: now.Add(timeout)})
+}
+
+// Active returns the nicks that are typing right now in the order they started.
+func (t *Typing) Active(now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var nicks []string
+	for _, typist := range t.typists {
+		if typist.State == TypingActive && now.Before(typist.Expires) {
+			nicks = append(nicks, typist.Nick)
+		}
+	}
+
+	return nicks
+}
+
+// Summary describes who's typing, e.g. "alice is typing…", or returns "" if nobody is.
+func (t *Typing) Summary(now time.Time) string {
+	nicks := t.Active(now)
+
+	switch len(nicks) {
+	case 0:
+		return ""
+	case 1:
+		return nicks[0] + " is typing…"
+	case 2:
+		return nicks[0] + " and " + nicks[1] + " are typing…"
+	case 3:
+		return fmt.Sprintf("%s, %s and %s are typing…", nicks[0], nicks[1], nicks[2])
+	default:
+		return fmt.Sprintf("%d people are typing…", len(nicks))
+	}
+}
+
+// HandleTyping updates who's typing in a buffer from a TAGMSG.
+// It returns false if the TAGMSG isn't a typing notification.
+func (c *Client) HandleTyping(buffer *Channel, msg Message) bool {
+	state := msg.Tags["+typing"]
+	if state == "" {
+		return false
+	}
+
+	nick := strings.SplitN(msg.Source, "!", 2)[0]
+	if c.IsMe(nick) {
+		return true
+	}
+
+	buffer.Typing.Set(nick, state, time.Now())
+	return true
+}
+
+// SetTyping tells the buffer's target about our typing state,
+// active notifications are only repeated every TypingThrottle
+// and paused or done are only sent if we said we were typing.
+func (c *Client) SetTyping(buffer *Channel, state string, now time.Time) {
+	if c.DisableTyping || buffer == c.RootChannel || buffer.IsDirect() || !c.ClientTagAllowed("typing") {
+		return
+	}
+
+	switch state {
+	case TypingActive:
+		if buffer.ourTyping == TypingActive && now.Sub(buffer.typingSent) < TypingThrottle {
+			return
+		}
+	case TypingPaused:
+		if buffer.ourTyping != TypingActive {
+			return
+		}
+	case TypingDone:
+		if buffer.ourTyping == "" || buffer.ourTyping == TypingDone {
+			return
+		}
+	}
+
+	buffer.ourTyping = state
+	buffer.typingSent = now
+	c.SendTagged(MessageTags{"+typing": state}, commands.TAGMSG, buffer.Name)
+}
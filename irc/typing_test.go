@@ -0,0 +1,88 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTyping(t *testing.T) {
+	t.Run("Test expiry", func(t *testing.T) {
+		typing := NewTyping()
+		now := time.Now()
+
+		typing.Set("alice", TypingActive, now)
+		typing.Set("bob", TypingActive, now.Add(4*time.Second))
+		if summary := typing.Summary(now.Add(5 * time.Second)); summary != "alice and bob are typing…" {
+			t.Fatal("Wrong summary:", summary)
+		}
+
+		if summary := typing.Summary(now.Add(7 * time.Second)); summary != "bob is typing…" {
+			t.Fatal("Active state didn't expire:", summary)
+		}
+
+		typing.Set("BOB", TypingPaused, now.Add(7*time.Second))
+		if summary := typing.Summary(now.Add(7 * time.Second)); summary != "" {
+			t.Fatal("Paused nicks shouldn't be shown as typing:", summary)
+		}
+	})
+
+	t.Run("Test throttling", func(t *testing.T) {
+		client, server := newTestClient(t)
+		client.EnabledCapabilities = Capabilities{"message-tags": ""}
+		client.EnabledFeatures = Features{}
+		channel := client.AppendChannel(NewChannel("#gorc"))
+		now := time.Now()
+
+		sent := make(chan string, 10)
+		go func() {
+			for {
+				line, err := server.ReadString('\n')
+				if err != nil {
+					close(sent)
+					return
+				}
+				sent <- line
+			}
+		}()
+
+		client.SetTyping(channel, TypingActive, now)
+		client.SetTyping(channel, TypingActive, now.Add(time.Second))
+		client.SetTyping(channel, TypingActive, now.Add(4*time.Second))
+		client.SetTyping(channel, TypingDone, now.Add(5*time.Second))
+		client.SetTyping(channel, TypingPaused, now.Add(6*time.Second))
+		client.SetTyping(client.RootChannel, TypingActive, now)
+
+		expected := []string{
+			"@+typing=active TAGMSG #gorc\r\n",
+			"@+typing=active TAGMSG #gorc\r\n",
+			"@+typing=done TAGMSG #gorc\r\n",
+		}
+		for _, want := range expected {
+			if got := <-sent; got != want {
+				t.Fatalf("Expected %q, got %q", want, got)
+			}
+		}
+
+		select {
+		case line := <-sent:
+			t.Fatal("Unexpected notification:", line)
+		default:
+		}
+	})
+}
@@ -0,0 +1,135 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// URL is a parsed irc:// or ircs:// URL.
+// (https://datatracker.ietf.org/doc/html/draft-butcher-irc-url-04)
+type URL struct {
+	Host     string
+	Port     string
+	TLS      bool
+	Nickname string
+	Password string
+	Channels []string
+}
+
+// ParseURL parses URLs such as ircs://irc.libera.chat:6697/#go,#gorc?nick=bob.
+// Channels don't have to be percent-encoded since we don't treat # as a fragment,
+// and a # is prepended to channel names that don't start with a channel prefix.
+func ParseURL(raw string) (URL, error) {
+	var u URL
+
+	scheme, rest, found := strings.Cut(raw, "://")
+	if !found {
+		return u, fmt.Errorf("invalid irc url %q", raw)
+	}
+
+	switch strings.ToLower(scheme) {
+	case "irc":
+		u.Port = "6667"
+	case "ircs":
+		u.TLS = true
+		u.Port = "6697"
+	default:
+		return u, fmt.Errorf("unsupported url scheme %q", scheme)
+	}
+
+	authority, path, _ := strings.Cut(rest, "/")
+
+	// user info isn't part of the spec but lets us pass a nick and server password
+	if userinfo, host, found := strings.Cut(authority, "@"); found {
+		authority = host
+		nick, password, _ := strings.Cut(userinfo, ":")
+		u.Nickname, _ = url.PathUnescape(nick)
+		u.Password, _ = url.PathUnescape(password)
+	}
+
+	if host, port, err := net.SplitHostPort(authority); err == nil {
+		u.Host = host
+		u.Port = port
+	} else {
+		u.Host = strings.Trim(authority, "[]")
+	}
+
+	if u.Host == "" {
+		return u, errors.New("irc url has no host")
+	}
+
+	query := ""
+	if i := strings.LastIndex(path, "?"); i != -1 {
+		path, query = path[:i], path[i+1:]
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return u, err
+	}
+
+	if nick := values.Get("nick"); nick != "" {
+		u.Nickname = nick
+	}
+
+	for _, target := range strings.Split(path, ",") {
+		target, err := url.PathUnescape(target)
+		if err != nil {
+			return u, err
+		}
+
+		if target == "" {
+			continue
+		}
+
+		if !strings.ContainsAny(target[:1], "#&+!") {
+			target = "#" + target
+		}
+
+		u.Channels = append(u.Channels, target)
+	}
+
+	return u, nil
+}
+
+// Profile returns a copy of base that connects to the URL's server and joins its channels.
+func (u URL) Profile(base Profile) Profile {
+	profile := base
+	profile.Host = u.Host
+	profile.Port = u.Port
+	profile.TLS = u.TLS
+	profile.Autojoin = nil
+
+	if u.Nickname != "" {
+		profile.Nickname = u.Nickname
+	}
+
+	if u.Password != "" {
+		profile.Password = u.Password
+	}
+
+	for _, channel := range u.Channels {
+		profile.Autojoin = append(profile.Autojoin, AutojoinChannel{Name: channel})
+	}
+
+	return profile
+}
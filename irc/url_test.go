@@ -0,0 +1,88 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import "testing"
+
+func TestURL(t *testing.T) {
+	t.Run("Test full url", func(t *testing.T) {
+		u, err := ParseURL("ircs://irc.libera.chat:6697/#go,#gorc?nick=bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Host != "irc.libera.chat" || u.Port != "6697" || !u.TLS {
+			t.Fatal("Wrong server:", u)
+		}
+
+		if u.Nickname != "bob" {
+			t.Fatal("Wrong nickname:", u.Nickname)
+		}
+
+		if len(u.Channels) != 2 || u.Channels[0] != "#go" || u.Channels[1] != "#gorc" {
+			t.Fatal("Wrong channels:", u.Channels)
+		}
+	})
+
+	t.Run("Test default port and encoded channels", func(t *testing.T) {
+		u, err := ParseURL("irc://irc.oftc.net/%23debian,oftc")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Port != "6667" || u.TLS {
+			t.Fatal("Wrong defaults:", u)
+		}
+
+		if len(u.Channels) != 2 || u.Channels[0] != "#debian" || u.Channels[1] != "#oftc" {
+			t.Fatal("Wrong channels:", u.Channels)
+		}
+	})
+
+	t.Run("Test user info", func(t *testing.T) {
+		u, err := ParseURL("ircs://bob:secret@[::1]:6697")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Host != "::1" || u.Nickname != "bob" || u.Password != "secret" || len(u.Channels) != 0 {
+			t.Fatal("Wrong url:", u)
+		}
+	})
+
+	t.Run("Test invalid urls", func(t *testing.T) {
+		for _, raw := range []string{"irc.libera.chat", "http://example.com", "irc:///#go"} {
+			if _, err := ParseURL(raw); err == nil {
+				t.Fatal("Expected an error for", raw)
+			}
+		}
+	})
+
+	t.Run("Test profile", func(t *testing.T) {
+		u, _ := ParseURL("ircs://irc.libera.chat/#go")
+		base := Profile{Nickname: "alice", Realname: "Alice", Autojoin: []AutojoinChannel{{Name: "#old"}}}
+		profile := u.Profile(base)
+
+		if profile.Nickname != "alice" || profile.Realname != "Alice" || profile.Port != "6697" {
+			t.Fatal("Identity wasn't kept:", profile)
+		}
+
+		if len(profile.Autojoin) != 1 || profile.Autojoin[0].Name != "#go" {
+			t.Fatal("Wrong autojoin:", profile.Autojoin)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import "strings"
+
+// UserInfo is what we know about a user on a network regardless of the channel we see them in.
+type UserInfo struct {
+	Nick     string
+	Username string
+	Host     string
+	Realname string
+
+	// Services account the user is logged in to, "" if they aren't
+	Account string
+
+	Away        bool
+	AwayMessage string
+
+	// Whether the user is an IRC operator
+	Oper bool
+}
+
+// Users is a network's table of the users we share a channel with.
+// Each user is stored once so a change shows up in every buffer they're in.
+type Users struct {
+	index map[string]*UserInfo
+
+	casemapping string
+}
+
+func NewUsers() *Users {
+	return &Users{
+		index:       make(map[string]*UserInfo),
+		casemapping: CasemappingRFC1459,
+	}
+}
+
+func (u *Users) fold(nick string) string {
+	return Casefold(u.casemapping, nick)
+}
+
+// SetCasemapping changes how nicks are compared and re-indexes every user.
+func (u *Users) SetCasemapping(casemapping string) {
+	u.casemapping = casemapping
+
+	index := make(map[string]*UserInfo, len(u.index))
+	for _, user := range u.index {
+		index[u.fold(user.Nick)] = user
+	}
+	u.index = index
+}
+
+func (u *Users) Len() int {
+	return len(u.index)
+}
+
+// Get returns what we know about a nick or nil if they're not in the table.
+func (u *Users) Get(nick string) *UserInfo {
+	return u.index[u.fold(nick)]
+}
+
+// Add returns the nick's entry, adding an empty one if they're not in the table yet.
+func (u *Users) Add(nick string) *UserInfo {
+	if user := u.Get(nick); user != nil {
+		return user
+	}
+
+	user := &UserInfo{Nick: nick}
+	u.index[u.fold(nick)] = user
+
+	return user
+}
+
+// Rename moves a user's entry to their new nick.
+func (u *Users) Rename(oldNick string, newNick string) {
+	user := u.Get(oldNick)
+	if user == nil {
+		return
+	}
+
+	delete(u.index, u.fold(oldNick))
+	user.Nick = newNick
+	u.index[u.fold(newNick)] = user
+}
+
+func (u *Users) Remove(nick string) {
+	delete(u.index, u.fold(nick))
+}
+
+// ParseAccount turns the "*" that means logged out into "".
+func ParseAccount(account string) string {
+	if account == "*" {
+		return ""
+	}
+
+	return account
+}
+
+// TrackUser updates the user table from any message a known user sent,
+// their user@host from its source and their account from the account tag.
+// (https://ircv3.net/specs/extensions/account-tag)
+func (c *Client) TrackUser(msg Message) {
+	nick, userhost, ok := strings.Cut(msg.Source, "!")
+	if !ok {
+		return
+	}
+
+	user := c.Users.Get(nick)
+	if user == nil {
+		return
+	}
+
+	if username, host, ok := strings.Cut(userhost, "@"); ok {
+		user.Username = username
+		user.Host = host
+	}
+
+	if _, ok := c.EnabledCapabilities["account-tag"]; ok {
+		user.Account = msg.Tags["account"]
+	}
+}
+
+// Forget drops a user from the table once we don't share any buffer with them anymore.
+func (c *Client) Forget(nick string) {
+	if c.IsMe(nick) {
+		return
+	}
+
+	// we still want to hear about online friends with extended-monitor
+	if friend := c.Friend(nick); friend != nil && friend.Online {
+		return
+	}
+
+	for _, buffer := range c.Buffers.All() {
+		if _, ok := buffer.Users[nick]; ok {
+			return
+		}
+	}
+
+	c.Users.Remove(nick)
+}
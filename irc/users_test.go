@@ -0,0 +1,80 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import "testing"
+
+func TestUsers(t *testing.T) {
+	t.Run("Test shared entries", func(t *testing.T) {
+		users := NewUsers()
+		alice := users.Add("Alice")
+
+		if users.Add("alice") != alice || users.Get("ALICE") != alice {
+			t.Fatal("Nicks weren't casefolded")
+		}
+
+		users.Rename("alice", "alice_")
+		if users.Get("alice") != nil || users.Get("alice_") != alice || alice.Nick != "alice_" {
+			t.Fatal("User wasn't renamed")
+		}
+
+		users.SetCasemapping(CasemappingASCII)
+		if users.Get("ALICE_") != alice {
+			t.Fatal("User lost after changing the casemapping")
+		}
+	})
+
+	t.Run("Test tracking from messages", func(t *testing.T) {
+		client := &Client{
+			Users:               NewUsers(),
+			Buffers:             NewBuffers(),
+			EnabledCapabilities: Capabilities{"account-tag": ""},
+		}
+		alice := client.Users.Add("alice")
+
+		client.TrackUser(Message{Source: "alice!al@example.com", Tags: MessageTags{"account": "alice"}})
+		if alice.Username != "al" || alice.Host != "example.com" || alice.Account != "alice" {
+			t.Fatal("User wasn't updated:", alice)
+		}
+
+		client.TrackUser(Message{Source: "alice!al@example.com"})
+		if alice.Account != "" {
+			t.Fatal("A message without the account tag means the user isn't logged in")
+		}
+	})
+
+	t.Run("Test forgetting users", func(t *testing.T) {
+		client := &Client{Users: NewUsers(), Buffers: NewBuffers()}
+		a := client.AppendChannel(NewChannel("#a"))
+		b := client.AppendChannel(NewChannel("#b"))
+		a.Users["alice"] = User{}
+		b.Users["alice"] = User{}
+		client.Users.Add("alice")
+
+		delete(a.Users, "alice")
+		client.Forget("alice")
+		if client.Users.Get("alice") == nil {
+			t.Fatal("User was forgotten while still in #b")
+		}
+
+		delete(b.Users, "alice")
+		client.Forget("alice")
+		if client.Users.Get("alice") != nil {
+			t.Fatal("User wasn't forgotten")
+		}
+	})
+}
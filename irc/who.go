@@ -0,0 +1,301 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illusionman1212/gorc/irc/commands"
+)
+
+var (
+	// Minimum time between two WHO queries so joining lots of channels doesn't flood the server
+	WhoInterval = 2 * time.Second
+
+	// How long we wait for the end of a WHO reply before sending the next query anyway
+	WhoTimeout = 30 * time.Second
+
+	// How often joined channels are queried again when away-notify can't keep them up to date
+	WhoRefreshInterval = 5 * time.Minute
+)
+
+// Marks the WHOX replies to our own queries
+const whoxToken = "742"
+
+// WHOX fields we ask for: token, channel, user, host, nick, flags, account and realname.
+// (https://ircv3.net/specs/extensions/whox)
+const whoxFields = "%tcuhnfar," + whoxToken
+
+// WhoReply is one user from an RPL_WHOREPLY or RPL_WHOSPCRPL.
+type WhoReply struct {
+	Channel  string
+	Nick     string
+	Username string
+	Host     string
+	Flags    string
+	Account  string
+	Realname string
+}
+
+// ParseWhoReply reads a 352 or a 354 reply to our WHOX query.
+// It returns false for 354 replies to queries that aren't ours since their fields are unknown.
+func ParseWhoReply(msg Message) (WhoReply, bool) {
+	params := msg.Parameters
+
+	switch msg.Command {
+	case commands.RPL_WHOREPLY:
+		// <client> <channel> <user> <host> <server> <nick> <flags> :<hopcount> <realname>
+		if len(params) < 8 {
+			return WhoReply{}, false
+		}
+
+		_, realname, _ := strings.Cut(params[7], " ")
+
+		return WhoReply{
+			Channel:  params[1],
+			Username: params[2],
+			Host:     params[3],
+			Nick:     params[5],
+			Flags:    params[6],
+			Realname: realname,
+		}, true
+	case commands.RPL_WHOSPCRPL:
+		// <client> <token> <channel> <user> <host> <nick> <flags> <account> :<realname>
+		if len(params) < 9 || params[1] != whoxToken {
+			return WhoReply{}, false
+		}
+
+		account := params[7]
+		if account == "0" {
+			account = ""
+		}
+
+		return WhoReply{
+			Channel:  params[2],
+			Username: params[3],
+			Host:     params[4],
+			Nick:     params[5],
+			Flags:    params[6],
+			Account:  account,
+			Realname: params[8],
+		}, true
+	}
+
+	return WhoReply{}, false
+}
+
+// Away reports whether the flags start with G(one) instead of H(ere).
+func (r WhoReply) Away() bool {
+	return strings.HasPrefix(r.Flags, "G")
+}
+
+func (r WhoReply) Oper() bool {
+	return strings.Contains(r.Flags, "*")
+}
+
+// Prefix returns the highest of the membership prefixes, ordered highest first, in the flags.
+func (r WhoReply) Prefix(prefixes string) string {
+	for _, prefix := range prefixes {
+		if strings.ContainsRune(r.Flags, prefix) {
+			return string(prefix)
+		}
+	}
+
+	return ""
+}
+
+// ApplyWhoReply stores what a WHO reply tells us about a member of one of our channels.
+func (c *Client) ApplyWhoReply(reply WhoReply) {
+	channel := c.Buffers.Get(reply.Channel)
+	if channel == nil {
+		return
+	}
+
+	// keep what else we know about them in the channel, like when they last spoke
+	member := channel.Users[reply.Nick]
+	member.Prefix = reply.Prefix(c.MembershipPrefixes())
+	channel.Users[reply.Nick] = member
+
+	user := c.Users.Add(reply.Nick)
+	user.Username = reply.Username
+	user.Host = reply.Host
+	user.Realname = reply.Realname
+	user.Away = reply.Away()
+	user.Oper = reply.Oper()
+
+	// plain WHO doesn't know about accounts
+	if _, whox := c.EnabledFeatures["WHOX"]; whox {
+		user.Account = reply.Account
+	}
+}
+
+type whoQueue struct {
+	mu      sync.Mutex
+	queue   []string
+	pending string
+	sent    time.Time
+	timer   *time.Timer
+
+	stopRefresh chan struct{}
+}
+
+// Who queues a WHO query for a channel's members,
+// queries are sent one at a time and at most once every WhoInterval.
+func (c *Client) Who(channel string) {
+	c.who.mu.Lock()
+	defer c.who.mu.Unlock()
+
+	same := func(target string) bool {
+		return c.Casefold(target) == c.Casefold(channel)
+	}
+
+	if (c.who.pending != "" && same(c.who.pending)) || slices.ContainsFunc(c.who.queue, same) {
+		return
+	}
+
+	c.who.queue = append(c.who.queue, channel)
+	c.nextWho()
+}
+
+// nextWho sends the next queued query unless we're still waiting for one, c.who.mu must be held.
+func (c *Client) nextWho() {
+	if c.who.pending != "" || c.who.timer != nil || len(c.who.queue) == 0 {
+		return
+	}
+
+	if wait := WhoInterval - time.Since(c.who.sent); wait > 0 {
+		c.who.timer = time.AfterFunc(wait, func() {
+			c.who.mu.Lock()
+			defer c.who.mu.Unlock()
+
+			c.who.timer = nil
+			c.nextWho()
+		})
+		return
+	}
+
+	target := c.who.queue[0]
+	c.who.queue = c.who.queue[1:]
+
+	// we might have left the channel while it was queued
+	if c.Buffers.Get(target) == nil {
+		c.nextWho()
+		return
+	}
+
+	sent := time.Now()
+	c.who.pending = target
+	c.who.sent = sent
+
+	if _, ok := c.EnabledFeatures["WHOX"]; ok {
+		c.SendCommand(commands.WHO, target, whoxFields)
+	} else {
+		c.SendCommand(commands.WHO, target)
+	}
+
+	time.AfterFunc(WhoTimeout, func() {
+		c.who.mu.Lock()
+		defer c.who.mu.Unlock()
+
+		if c.who.pending == target && c.who.sent.Equal(sent) {
+			c.who.pending = ""
+			c.nextWho()
+		}
+	})
+}
+
+// WhoPending reports whether we're waiting for the replies to our own WHO query for target.
+func (c *Client) WhoPending(target string) bool {
+	c.who.mu.Lock()
+	defer c.who.mu.Unlock()
+
+	return c.who.pending != "" && c.Casefold(c.who.pending) == c.Casefold(target)
+}
+
+// EndOfWho moves on to the next query once the server is done replying to ours.
+// It returns false if the query wasn't one of ours.
+func (c *Client) EndOfWho(target string) bool {
+	c.who.mu.Lock()
+	defer c.who.mu.Unlock()
+
+	if c.who.pending == "" || c.Casefold(c.who.pending) != c.Casefold(target) {
+		return false
+	}
+
+	c.who.pending = ""
+	c.nextWho()
+
+	return true
+}
+
+// StartWhoRefresh queries every joined channel again every WhoRefreshInterval
+// if the server won't tell us when users go away.
+func (c *Client) StartWhoRefresh() {
+	if _, ok := c.EnabledCapabilities["away-notify"]; ok {
+		return
+	}
+
+	c.who.mu.Lock()
+	defer c.who.mu.Unlock()
+
+	if c.who.stopRefresh != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.who.stopRefresh = stop
+
+	go func() {
+		ticker := time.NewTicker(WhoRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				for _, buffer := range c.Buffers.All() {
+					if buffer != c.RootChannel && strings.ContainsAny(buffer.Name[:1], "#&") {
+						c.Who(buffer.Name)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// ResetWho drops every queued query and stops refreshing, for when we disconnect.
+func (c *Client) ResetWho() {
+	c.who.mu.Lock()
+	defer c.who.mu.Unlock()
+
+	c.who.queue = nil
+	c.who.pending = ""
+
+	if c.who.timer != nil {
+		c.who.timer.Stop()
+		c.who.timer = nil
+	}
+
+	if c.who.stopRefresh != nil {
+		close(c.who.stopRefresh)
+		c.who.stopRefresh = nil
+	}
+}
@@ -0,0 +1,120 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWho(t *testing.T) {
+	t.Run("Test parsing replies", func(t *testing.T) {
+		who := Message{Command: "352", Parameters: []string{"bob", "#gorc", "al", "example.com", "irc.example.com", "alice", "G*@", "0 Alice A."}}
+		reply, ok := ParseWhoReply(who)
+		if !ok || reply.Nick != "alice" || reply.Realname != "Alice A." || !reply.Away() || !reply.Oper() || reply.Prefix("~&@%+") != "@" {
+			t.Fatal("Wrong WHO reply:", reply)
+		}
+
+		whox := Message{Command: "354", Parameters: []string{"bob", whoxToken, "#gorc", "al", "example.com", "alice", "H+", "alice", "Alice A."}}
+		reply, ok = ParseWhoReply(whox)
+		if !ok || reply.Account != "alice" || reply.Away() || reply.Prefix("~&@%+") != "+" {
+			t.Fatal("Wrong WHOX reply:", reply)
+		}
+
+		if reply.Prefix("@") != "" {
+			t.Fatal("Used a prefix the server doesn't have")
+		}
+
+		whox.Parameters[1] = "1"
+		if _, ok := ParseWhoReply(whox); ok {
+			t.Fatal("Parsed a WHOX reply to a query that isn't ours")
+		}
+	})
+
+	t.Run("Test applying replies", func(t *testing.T) {
+		client := &Client{Users: NewUsers(), Buffers: NewBuffers(), EnabledFeatures: Features{"WHOX": ""}}
+		channel := client.AppendChannel(NewChannel("#gorc"))
+
+		client.ApplyWhoReply(WhoReply{Channel: "#gorc", Nick: "alice", Flags: "G@", Account: "alice"})
+		user := client.Users.Get("alice")
+		if user == nil || !user.Away || user.Account != "alice" || channel.Users["alice"].Prefix != "@" {
+			t.Fatal("Reply wasn't applied:", user)
+		}
+
+		spoke := time.Now()
+		channel.Users["alice"] = User{Prefix: "@", LastSpoke: spoke}
+		client.EnabledFeatures["PREFIX"] = "(Yov)!@+"
+		client.ApplyWhoReply(WhoReply{Channel: "#gorc", Nick: "alice", Flags: "H!@"})
+		if member := channel.Users["alice"]; member.Prefix != "!" || !member.LastSpoke.Equal(spoke) {
+			t.Fatal("Wrong member after reply:", member)
+		}
+	})
+
+	t.Run("Test rate limiting", func(t *testing.T) {
+		WhoInterval = 50 * time.Millisecond
+		t.Cleanup(func() { WhoInterval = 2 * time.Second })
+
+		client, server := newTestClient(t)
+		client.Users = NewUsers()
+		client.EnabledFeatures = Features{"WHOX": ""}
+		client.AppendChannel(NewChannel("#a"))
+		client.AppendChannel(NewChannel("#b"))
+
+		sent := make(chan string, 10)
+		go func() {
+			for {
+				line, err := server.ReadString('\n')
+				if err != nil {
+					return
+				}
+				sent <- line
+			}
+		}()
+
+		client.Who("#a")
+		client.Who("#b")
+		client.Who("#A")
+
+		if line := <-sent; line != "WHO #a %tcuhnfar,742\r\n" {
+			t.Fatalf("Wrong query: %q", line)
+		}
+
+		select {
+		case line := <-sent:
+			t.Fatal("Sent a query before the last one ended:", line)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		start := time.Now()
+		if !client.EndOfWho("#A") {
+			t.Fatal("End of our query wasn't recognized")
+		}
+
+		if line := <-sent; line != "WHO #b %tcuhnfar,742\r\n" {
+			t.Fatalf("Wrong query: %q", line)
+		}
+
+		client.Who("#a")
+		client.EndOfWho("#b")
+		<-sent
+		if time.Since(start) < WhoInterval {
+			t.Fatal("Queries were sent faster than the interval")
+		}
+
+		client.ResetWho()
+	})
+}
@@ -0,0 +1,84 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+// Package logging filters what ends up in the log file by level.
+// Messages go through the standard logger so they end up wherever it points to.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff
+)
+
+var levelNames = map[string]Level{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+	"off":   LevelOff,
+}
+
+var current = LevelInfo
+
+// ParseLevel returns the level with the given name.
+func ParseLevel(name string) (Level, error) {
+	level, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn, error or off)", name)
+	}
+
+	return level, nil
+}
+
+// SetLevel drops every message below the given level.
+func SetLevel(level Level) {
+	current = level
+}
+
+func logf(level Level, prefix string, format string, args ...any) {
+	if level < current {
+		return
+	}
+
+	log.Printf(prefix+format, args...)
+}
+
+func Debugf(format string, args ...any) {
+	logf(LevelDebug, "DEBUG ", format, args...)
+}
+
+func Infof(format string, args ...any) {
+	logf(LevelInfo, "INFO ", format, args...)
+}
+
+func Warnf(format string, args ...any) {
+	logf(LevelWarn, "WARN ", format, args...)
+}
+
+func Errorf(format string, args ...any) {
+	logf(LevelError, "ERROR ", format, args...)
+}
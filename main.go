@@ -17,32 +17,173 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/illusionman1212/gorc/config"
+	"github.com/illusionman1212/gorc/irc"
+	"github.com/illusionman1212/gorc/logging"
 	"github.com/illusionman1212/gorc/ui/app"
 )
 
+type options struct {
+	config   string
+	nick     string
+	server   string
+	tls      bool
+	noLogin  bool
+	logLevel string
+}
+
+func parseFlags() (options, string) {
+	var opts options
+
+	flag.StringVar(&opts.config, "config", "", "path to the config file")
+	flag.StringVar(&opts.nick, "nick", "", "nickname to use, overrides the config")
+	flag.StringVar(&opts.server, "server", "", "server to connect to as host[:port], a network from the config or an irc:// url")
+	flag.BoolVar(&opts.tls, "tls", false, "connect to --server using TLS")
+	flag.BoolVar(&opts.noLogin, "no-login", false, "never show the login screen, exit if there's nothing to connect to")
+	flag.StringVar(&opts.logLevel, "log-level", "info", "what to write to gorc.log: debug, info, warn, error or off")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [irc://host[:port]/#channel,#channel?nick=nickname]\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	return opts, flag.Arg(0)
+}
+
+// startupProfiles returns the networks to connect to on startup.
+// A server given on the command line replaces the autoconnect networks from the config.
+func startupProfiles(cfg config.Config, opts options, target string) ([]irc.Profile, error) {
+	var profiles []irc.Profile
+
+	switch {
+	case target == "":
+		for _, network := range cfg.Autoconnect() {
+			profiles = append(profiles, cfg.Profile(network))
+		}
+	case cfg.Find(target) != nil:
+		profiles = append(profiles, cfg.Profile(*cfg.Find(target)))
+	case strings.Contains(target, "://"):
+		u, err := irc.ParseURL(target)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, u.Profile(cfg.Profile(config.Network{})))
+	default:
+		profiles = append(profiles, cfg.Profile(config.Network{Address: target, TLS: opts.tls}))
+	}
+
+	for i := range profiles {
+		if opts.tls {
+			profiles[i].TLS = true
+		}
+
+		if opts.nick != "" {
+			profiles[i].Nickname = opts.nick
+		}
+
+		// without a nick anywhere we go by the login name
+		if profiles[i].Nickname == "" {
+			profiles[i].Nickname = os.Getenv("USER")
+		}
+	}
+
+	if opts.noLogin && len(profiles) == 0 {
+		return nil, errors.New("--no-login was given but there's no server to connect to")
+	}
+
+	return profiles, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
 func main() {
-	gorc := app.InitialState()
+	opts, url := parseFlags()
+
+	if opts.server != "" && url != "" {
+		fatal(errors.New("--server and a url can't be used together"))
+	}
+
+	level, err := logging.ParseLevel(opts.logLevel)
+	if err != nil {
+		fatal(err)
+	}
+	logging.SetLevel(level)
+
+	path := opts.config
+	if path == "" {
+		path, err = config.Path()
+		if err != nil {
+			fatal(err)
+		}
+	} else if _, err := os.Stat(path); err != nil {
+		// only the default config is allowed to be missing
+		fatal(err)
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		fatal(err)
+	}
+
+	if opts.nick != "" {
+		cfg.Identity.Nick = opts.nick
+	}
+
+	startup, err := startupProfiles(cfg, opts, opts.server+url)
+	if err != nil {
+		fatal(err)
+	}
+
+	gorc := app.InitialState(cfg, startup)
+	gorc.NoLogin = opts.noLogin
+
+	if path, err := irc.LastSeenPath(); err == nil {
+		// a broken file just means playback might repeat some messages
+		gorc.Session.LastSeen, _ = irc.LoadLastSeen(path)
+	}
 
 	p := tea.NewProgram(
 		gorc,
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
+		tea.WithReportFocus(),
 	)
 
-	gorc.Client.Tea = p
+	gorc.Session.Tea = p
 
-	f, err := tea.LogToFile("gorc.log", "gorc")
-	if err != nil {
-		log.Fatal(err)
+	if level == logging.LevelOff {
+		// the terminal belongs to the UI so nothing can go to stderr either
+		log.SetOutput(io.Discard)
+	} else {
+		f, err := tea.LogToFile("gorc.log", "gorc")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
 	}
-	defer f.Close()
 
-	_, err = p.Run()
+	model, err := p.Run()
 
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if state, ok := model.(app.State); ok && state.Err != nil {
+		fatal(state.Err)
+	}
 }
@@ -17,9 +17,14 @@
 package app
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/illusionman1212/gorc/cmds"
+	"github.com/illusionman1212/gorc/config"
 	"github.com/illusionman1212/gorc/irc"
 	"github.com/illusionman1212/gorc/irc/handler"
 	"github.com/illusionman1212/gorc/ui"
@@ -44,27 +49,118 @@ type State struct {
 	UI             UI
 	TerminalWidth  int
 	TerminalHeight int
-	Client         *irc.Client
+	Session        *irc.Session
+	Config         config.Config
+
+	// Networks connected to on startup
+	Startup []irc.Profile
+
+	// Exit instead of showing the login screen if no startup network connects
+	NoLogin bool
+
+	// Why we quit if it was an error, it's printed once the terminal is restored
+	Err error
+
+	// Startup networks we haven't tried connecting to yet
+	startupLeft int
 }
 
-func initialUIState(client *irc.Client) UI {
-	return UI{
-		Login:      login.NewLogin(),
-		MainScreen: mainscreen.NewMainScreen(client),
+func initialUIState(session *irc.Session, cfg config.Config, startup []irc.Profile) UI {
+	loginState := login.NewLogin()
+	loginState.Inputs[3].SetValue(cfg.Identity.Nick)
+
+	ui := UI{
+		Login:      loginState,
+		MainScreen: mainscreen.NewMainScreen(session),
 	}
+
+	// networks are connected to in Init so we never show the login screen
+	if len(startup) > 0 {
+		ui.CurrentScreen = MainScreen
+	}
+
+	return ui
 }
 
-func InitialState() *State {
-	client := &irc.Client{}
+// InitialState creates the app state, the startup profiles are connected to in Init.
+func InitialState(cfg config.Config, startup []irc.Profile) *State {
+	irc.StripColors = cfg.Settings.StripColors
+	if cfg.Settings.ConfirmPaste != 0 {
+		mainscreen.ConfirmPasteLines = cfg.Settings.ConfirmPaste
+	}
+
+	session := &irc.Session{
+		ScrollbackLimit: cfg.Settings.Scrollback,
+		HistoryLimit:    cfg.Settings.History,
+		DisableTyping:   cfg.Settings.DisableTyping,
+		DownloadDir:     cfg.Settings.DownloadDir,
+		DCCAddress:      cfg.Settings.DCCAddress,
+		DCCWaitAcks:     cfg.Settings.DCCWaitAcks,
+	}
 
 	return &State{
-		Client: client,
-		UI:     initialUIState(client),
+		Session:     session,
+		Config:      cfg,
+		Startup:     startup,
+		UI:          initialUIState(session, cfg, startup),
+		startupLeft: len(startup),
+	}
+}
+
+// connectedMsg is the outcome of connecting to a network in the background.
+type connectedMsg struct {
+	client  *irc.Client
+	profile irc.Profile
+	err     error
+
+	// Whether it was started from the login screen
+	login bool
+}
+
+// connect opens a new network connection in the background so a slow host doesn't freeze the UI,
+// the client registers and becomes the active network once connectedMsg gets back to Update.
+func (s *State) connect(profile irc.Profile, login bool) tea.Cmd {
+	client := s.Session.NewClient()
+
+	return func() tea.Msg {
+		err := client.Initialize(profile)
+		return connectedMsg{client: client, profile: profile, err: err, login: login}
+	}
+}
+
+// connected registers a client that connected and makes it the active network.
+func (s *State) connected(client *irc.Client) {
+	client.Register()
+	s.Session.Add(client)
+
+	go handler.ReadLoop(client)
+}
+
+// loginProfile builds a profile from the login form, filling the rest from the config's identity.
+func (s *State) loginProfile() irc.Profile {
+	profile := s.Config.Profile(config.Network{})
+
+	profile.Host = s.UI.Login.Inputs[0].Value()
+	profile.Port = s.UI.Login.Inputs[1].Value()
+	profile.Nickname = s.UI.Login.Inputs[3].Value()
+	profile.Password = s.UI.Login.Inputs[4].Value()
+	profile.TLS = s.UI.Login.TLS
+
+	if channel := s.UI.Login.Inputs[2].Value(); channel != "" {
+		profile.Autojoin = []irc.AutojoinChannel{{Name: channel}}
 	}
+
+	return profile
 }
 
 func (s State) Init() tea.Cmd {
-	return textinput.Blink
+	batch := []tea.Cmd{textinput.Blink}
+
+	for _, profile := range s.Startup {
+		batch = append(batch, cmds.ConnectNetwork(profile))
+	}
+
+	return tea.Batch(batch...)
 }
 
 func (s State) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -72,7 +168,7 @@ func (s State) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c":
-			return s, cmds.Quit(s.Client)
+			return s, cmds.Quit(s.Session, "")
 		}
 
 	case tea.WindowSizeMsg:
@@ -92,24 +188,84 @@ func (s State) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		return s, nil
 	case cmds.ConnectMsg:
-		host := s.UI.Login.Inputs[0].Value()
-		port := s.UI.Login.Inputs[1].Value()
-		channel := s.UI.Login.Inputs[2].Value()
-		nickname := s.UI.Login.Inputs[3].Value()
-		password := s.UI.Login.Inputs[4].Value()
-		tlsEnabled := s.UI.Login.TLS
+		return s, s.connect(s.loginProfile(), true)
+	case cmds.ConnectNetworkMsg:
+		return s, s.connect(msg.Profile, false)
+	case connectedMsg:
+		if msg.login {
+			if msg.err != nil {
+				s.UI.Login.Err = msg.err.Error()
+				return s, nil
+			}
+
+			s.connected(msg.client)
+
+			s.UI.Login.Err = ""
+			s.UI.CurrentScreen = MainScreen
+			s.UI.MainScreen.SetSize(s.TerminalWidth, s.TerminalHeight)
+
+			return s, textinput.Blink
+		}
+
+		active := s.Session.Active
+		s.startupLeft = max(0, s.startupLeft-1)
 
-		s.UI.CurrentScreen = MainScreen
-		s.Client.InitialChannel = channel
+		if err := msg.err; err != nil {
+			message := fmt.Sprintf("Failed to connect to %s:%s: %v", msg.profile.Host, msg.profile.Port, err)
 
-		s.Client.Initialize(host, port, tlsEnabled)
-		s.Client.Register(nickname, password, channel)
+			// nothing we were told to connect to worked and there's no login screen to fall back to
+			if active == nil && s.NoLogin {
+				if s.startupLeft > 0 {
+					return s, nil
+				}
 
+				s.Err = errors.New(message)
+				return s, tea.Quit
+			}
+
+			// an autoconnect network failed before anything else connected
+			if active == nil {
+				s.UI.CurrentScreen = Login
+				s.UI.Login.Err = message
+				s.UI.Login.SetSize(s.TerminalWidth, s.TerminalHeight)
+				return s, nil
+			}
+
+			active.ActiveChannel.AppendMsg(time.Now(), message, irc.MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true})
+			return s, cmds.ReceivedIRCMsg
+		}
+
+		s.connected(msg.client)
+
+		if s.UI.CurrentScreen != MainScreen {
+			s.UI.CurrentScreen = MainScreen
+		}
 		s.UI.MainScreen.SetSize(s.TerminalWidth, s.TerminalHeight)
 
-		go handler.ReadLoop(s.Client)
+		return s, tea.Batch(cmds.UpdateTabBar, cmds.SwitchChannels)
+	case cmds.DisconnectNetworkMsg:
+		client := s.Session.Active
+		if msg.Network != "" {
+			client = s.Session.Find(msg.Network)
+		}
+
+		if client == nil {
+			message := fmt.Sprintf("Not connected to %s", msg.Network)
+			s.Session.Active.ActiveChannel.AppendMsg(time.Now(), message, irc.MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true})
+			return s, cmds.ReceivedIRCMsg
+		}
+
+		client.Disconnect(msg.Reason)
+		s.Session.Remove(client)
+
+		// go back to the login screen once we're not connected to anything
+		if s.Session.Active == nil {
+			s.UI.CurrentScreen = Login
+			s.UI.Login.SetSize(s.TerminalWidth, s.TerminalHeight)
+			return s, textinput.Blink
+		}
 
-		return s, textinput.Blink
+		return s, tea.Batch(cmds.UpdateTabBar, cmds.SwitchChannels)
 	}
 
 	// switch between which screen is currently active and update its state
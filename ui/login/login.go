@@ -31,6 +31,7 @@ type State struct {
 	Inputs                    []textinput.Model
 	TLS                       bool
 	CanConnect                bool
+	Err                       string
 	ConnectButtonBlurredStyle string
 	ConnectButtonFocusedStyle string
 	DialogStyle               lipgloss.Style
@@ -203,6 +204,10 @@ func (s State) View() string {
 
 	sb.WriteString(lipgloss.JoinVertical(lipgloss.Center, checkbox, button))
 
+	if s.Err != "" {
+		sb.WriteString("\n" + ErrorStyle.Render(s.Err))
+	}
+
 	screen := lipgloss.JoinVertical(lipgloss.Center, s.WelcomeMsgStyle.Render(WelcomeMsg), s.DialogStyle.Render(sb.String()))
 
 	return ui.MainStyle.Render(screen)
@@ -100,4 +100,7 @@ var (
 			Foreground(ui.AccentColor)
 	FocusedStyle = lipgloss.NewStyle().
 			Foreground(ui.AccentColor)
+	ErrorStyle = lipgloss.NewStyle().
+			Foreground(ui.ErrorColor).
+			MarginTop(1)
 )
@@ -0,0 +1,179 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package mainscreen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+
+	"github.com/illusionman1212/gorc/irc"
+)
+
+// The formatting codes the keybindings insert.
+// ctrl+i is a tab in most terminals so alt+i does italics too
+var formatKeys = map[string]rune{
+	"ctrl+b": '\x02',
+	"ctrl+i": '\x1D',
+	"alt+i":  '\x1D',
+	"ctrl+u": '\x1F',
+	"ctrl+o": '\x0F',
+}
+
+const pickerKey = "ctrl+k"
+
+// The codes that get markers, the same ones the scrollback renders
+const markedCodes = "\x02\x03\x04\x0F\x11\x16\x1D\x1E\x1F"
+
+// marker is the control picture (␂, ␃, ␏...) that stands in for a formatting code in the input,
+// the textinput drops control characters and they wouldn't show up anyway.
+func marker(code rune) rune {
+	return 0x2400 + code
+}
+
+// toMarkers replaces the formatting codes in text with their markers.
+func toMarkers(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && strings.ContainsRune(markedCodes, r) {
+			return marker(r)
+		}
+		return r
+	}, text)
+}
+
+// fromMarkers turns the markers in the input back into the formatting codes that are sent.
+func fromMarkers(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 0x2400 && r < 0x2420 && strings.ContainsRune(markedCodes, r-0x2400) {
+			return r - 0x2400
+		}
+		return r
+	}, text)
+}
+
+// hasMarkers says whether text has any formatting to preview.
+func hasMarkers(text string) bool {
+	return fromMarkers(text) != text
+}
+
+// colorPicker picks the foreground and optionally the background of a color code.
+type colorPicker struct {
+	Selected int
+	Fg       int
+	// whether the foreground was picked and it's the background's turn
+	PickingBg bool
+
+	// the digits typed so far, a color can be picked by its number
+	typed string
+}
+
+// Update handles a key while the picker is open, it returns the code to insert once a color is picked and
+// whether the picker is done.
+func (p *colorPicker) Update(msg tea.KeyMsg) (string, bool) {
+	switch key := msg.String(); key {
+	case "left", "h":
+		p.Selected = (p.Selected + irc.ColorCount - 1) % irc.ColorCount
+		p.typed = ""
+	case "right", "l":
+		p.Selected = (p.Selected + 1) % irc.ColorCount
+		p.typed = ""
+	case ",":
+		if !p.PickingBg {
+			p.Fg = p.Selected
+			p.PickingBg = true
+			p.typed = ""
+		}
+	case "enter":
+		if p.PickingBg {
+			return fmt.Sprintf("%c%02d,%02d", marker('\x03'), p.Fg, p.Selected), true
+		}
+		return fmt.Sprintf("%c%02d", marker('\x03'), p.Selected), true
+	case "esc", pickerKey:
+		return "", true
+	default:
+		if len(key) != 1 || key[0] < '0' || key[0] > '9' {
+			break
+		}
+
+		if len(p.typed) == 1 {
+			p.typed += key
+		} else {
+			p.typed = key
+		}
+
+		n, _ := strconv.Atoi(p.typed)
+		if n >= irc.ColorCount {
+			p.typed = key
+			n, _ = strconv.Atoi(key)
+		}
+		p.Selected = n
+	}
+
+	return "", false
+}
+
+// View shows as many colors around the selected one as fit in width.
+func (p colorPicker) View(width int) string {
+	label := "fg: "
+	if p.PickingBg {
+		label = fmt.Sprintf("fg %02d, bg: ", p.Fg)
+	}
+
+	hint := "  ←/→ or type a number, , for background, enter to insert"
+
+	const swatchWidth = 4
+	count := max(1, (width-lipgloss.Width(label))/swatchWidth)
+	count = min(count, irc.ColorCount)
+	start := min(max(0, p.Selected-count/2), irc.ColorCount-count)
+
+	var b strings.Builder
+	b.WriteString(label)
+	for n := start; n < start+count; n++ {
+		b.WriteString(swatch(n, n == p.Selected))
+	}
+
+	line := b.String()
+	if lipgloss.Width(line)+lipgloss.Width(hint) <= width {
+		line += typingStyle.UnsetPadding().Render(hint)
+	}
+
+	return ansi.Truncate(line, max(0, width), "…")
+}
+
+// swatch shows a color with its number in a readable color on top.
+func swatch(n int, selected bool) string {
+	hex := irc.ColorHex(n)
+	r, _ := strconv.ParseUint(hex[1:3], 16, 8)
+	g, _ := strconv.ParseUint(hex[3:5], 16, 8)
+	b, _ := strconv.ParseUint(hex[5:7], 16, 8)
+
+	fg := "#FFFFFF"
+	if r*299+g*587+b*114 > 128000 {
+		fg = "#000000"
+	}
+
+	style := lipgloss.NewStyle().Background(lipgloss.Color(hex)).Foreground(lipgloss.Color(fg))
+	if selected {
+		return style.Bold(true).Render(fmt.Sprintf("[%02d]", n))
+	}
+
+	return style.Render(fmt.Sprintf(" %02d ", n))
+}
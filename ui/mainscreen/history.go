@@ -0,0 +1,97 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package mainscreen
+
+import "strings"
+
+// How many sent lines each buffer remembers
+const historySize = 100
+
+const searchKey = "ctrl+r"
+
+// inputHistory is what was sent in a buffer, oldest first, and where up and down are in it.
+type inputHistory struct {
+	lines []string
+
+	// the line being shown, len(lines) when it's what we were typing
+	pos int
+
+	// what we were typing before going through the history
+	typed string
+}
+
+func (h *inputHistory) add(line string) {
+	if len(h.lines) == 0 || h.lines[len(h.lines)-1] != line {
+		h.lines = append(h.lines, line)
+	}
+
+	if len(h.lines) > historySize {
+		h.lines = h.lines[len(h.lines)-historySize:]
+	}
+
+	h.pos = len(h.lines)
+}
+
+// prev goes back a line, current is what's in the input so it can be brought back.
+func (h *inputHistory) prev(current string) (string, bool) {
+	if h.pos == 0 {
+		return "", false
+	}
+
+	if h.pos == len(h.lines) {
+		h.typed = current
+	}
+
+	h.pos--
+	return h.lines[h.pos], true
+}
+
+func (h *inputHistory) next() (string, bool) {
+	if h.pos >= len(h.lines) {
+		return "", false
+	}
+
+	h.pos++
+	if h.pos == len(h.lines) {
+		return h.typed, true
+	}
+
+	return h.lines[h.pos], true
+}
+
+// find returns the newest line before index before that contains query, or -1.
+func (h *inputHistory) find(query string, before int) int {
+	query = strings.ToLower(query)
+	for i := min(before, len(h.lines)) - 1; i >= 0; i-- {
+		if strings.Contains(strings.ToLower(h.lines[i]), query) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// historySearch is a ctrl+r search through the history.
+type historySearch struct {
+	query string
+
+	// index of the line found, -1 if nothing matches
+	match int
+
+	// what was in the input before searching, esc brings it back
+	typed string
+}
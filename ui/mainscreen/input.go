@@ -17,17 +17,66 @@
 package mainscreen
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 
 	"github.com/illusionman1212/gorc/cmds"
+	"github.com/illusionman1212/gorc/irc"
 	"github.com/illusionman1212/gorc/ui"
 )
 
+type InputMode int
+
+const (
+	Chatting InputMode = iota
+	Replying
+	Reacting
+)
+
+const defaultPrompt = "> "
+
+// Stands in for a new line in the input while writing a multi-line message
+const newlineMarker = '␤'
+
+// Pasting more lines than this asks before sending them, -1 never asks
+var ConfirmPasteLines = 5
+
 type InputState struct {
 	Input textinput.Model
 	Style lipgloss.Style
+
+	// What pressing enter does and the line it's about when replying or reacting
+	Mode   InputMode
+	Target *irc.Line
+
+	// A yes or no question shown instead of the input until it's answered
+	Question string
+
+	// open while picking a color to insert
+	picker *colorPicker
+
+	// the matches tab cycles through and the value it left the input with,
+	// editing the input starts a new completion
+	completion      *irc.Completion
+	completionBase  string
+	completionIndex int
+	completed       string
+
+	// The buffer we're typing in, what was sent in each buffer and what was left unsent in the others
+	client    *irc.Client
+	buffer    *irc.Channel
+	histories map[*irc.Channel]*inputHistory
+	drafts    map[*irc.Channel]string
+
+	// open while searching the history with ctrl+r
+	search *historySearch
+
+	width int
 }
 
 func NewInputBox() InputState {
@@ -36,25 +85,102 @@ func NewInputBox() InputState {
 	input.Focus()
 
 	return InputState{
-		Input: input,
-		Style: InputboxStyle,
+		Input:     input,
+		Style:     InputboxStyle,
+		histories: make(map[*irc.Channel]*inputHistory),
+		drafts:    make(map[*irc.Channel]string),
 	}
 }
 
 func (s InputState) Update(msg tea.Msg) (InputState, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if s.picker != nil {
+			if code, done := s.picker.Update(msg); done {
+				s.picker = nil
+				s.insert(code)
+			}
+
+			return s, nil
+		}
+
+		if s.search != nil && s.updateSearch(msg) {
+			return s, nil
+		}
+
+		if msg.Paste {
+			// keep the formatting of pasted text
+			paste := toMarkers(string(msg.Runes))
+			paste = strings.ReplaceAll(paste, "\r\n", "\n")
+			paste = strings.ReplaceAll(paste, "\r", "\n")
+
+			if cmd, ok := s.pasteLines(paste); ok {
+				return s, cmd
+			}
+
+			msg.Runes = []rune(paste)
+		}
+
 		key := msg.String()
+		if code, ok := formatKeys[key]; ok {
+			s.insert(string(marker(code)))
+			return s, nil
+		}
+
 		switch key {
+		case pickerKey:
+			s.picker = &colorPicker{}
+			return s, nil
+		case "alt+enter", "ctrl+j":
+			if s.Mode == Chatting {
+				s.insert(string(newlineMarker))
+			}
+			return s, nil
+		case "up", "down":
+			history := s.history()
+
+			line, ok := history.prev(s.Input.Value())
+			if key == "down" {
+				line, ok = history.next()
+			}
+
+			if ok {
+				s.Input.SetValue(line)
+				s.Input.CursorEnd()
+			}
+			return s, nil
+		case searchKey:
+			s.search = &historySearch{typed: s.Input.Value(), match: -1}
+			return s, nil
 		case "enter":
-			value := s.Input.Value()
-			if len(value) == 0 {
+			if s.Input.Value() == "" {
 				return s, nil
 			}
 
+			value := s.message()
+			if s.Mode != Reacting {
+				s.history().add(s.Input.Value())
+			}
+
 			s.Input.Reset()
 
+			mode, target := s.Mode, s.Target
+			s.SetMode(Chatting, nil)
+
+			switch mode {
+			case Replying:
+				return s, cmds.SendReply(value, target)
+			case Reacting:
+				return s, cmds.SendReaction(value, target)
+			}
+
 			return s, cmds.SendPrivMsg(value)
+		case "esc":
+			if s.Mode != Chatting {
+				s.Input.Reset()
+				s.SetMode(Chatting, nil)
+				return s, nil
+			}
 		}
 	}
 
@@ -63,6 +189,239 @@ func (s InputState) Update(msg tea.Msg) (InputState, tea.Cmd) {
 	return s, cmd
 }
 
+// Complete completes the word before the cursor or moves on to the next match if it was just completed.
+// It reports whether there was anything to complete.
+func (s *InputState) Complete(client *irc.Client, slash []irc.SlashCommand) bool {
+	value := s.Input.Value()
+	if s.completion != nil && value == s.completed {
+		s.completionIndex = (s.completionIndex + 1) % len(s.completion.Matches)
+	} else {
+		completion := client.Complete(value, s.Input.Position(), slash)
+		if len(completion.Matches) == 0 {
+			s.completion = nil
+			return false
+		}
+
+		s.completion = &completion
+		s.completionBase = value
+		s.completionIndex = 0
+	}
+
+	base := []rune(s.completionBase)
+	match := []rune(s.completion.Matches[s.completionIndex])
+
+	s.Input.SetValue(string(base[:s.completion.Start]) + string(match) + string(base[s.completion.End:]))
+	s.Input.SetCursor(s.completion.Start + len(match))
+	s.completed = s.Input.Value()
+
+	return true
+}
+
+// insert types text at the cursor like it was typed.
+func (s *InputState) insert(text string) {
+	if text == "" {
+		return
+	}
+
+	s.Input, _ = s.Input.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(text)})
+}
+
+// pasteLines puts pasted text with new lines in the input as a multi-line message,
+// the textinput would otherwise turn them into spaces. Pastes of many lines are sent right away
+// once we say yes, they'd be unwieldy to edit.
+func (s *InputState) pasteLines(paste string) (tea.Cmd, bool) {
+	if !strings.Contains(paste, "\n") {
+		return nil, false
+	}
+
+	// commands, replies and reactions are single line
+	text := s.Input.Value()
+	if text == "" {
+		text = paste
+	}
+
+	if s.Mode != Chatting || strings.HasPrefix(text, "/") {
+		return nil, false
+	}
+
+	// copied lines usually end with a new line we don't want to send
+	paste = strings.TrimRight(paste, "\n")
+	if strings.TrimSpace(paste) == "" {
+		return nil, true
+	}
+
+	lines := strings.Count(paste, "\n") + 1
+	if ConfirmPasteLines >= 0 && lines > ConfirmPasteLines {
+		question := fmt.Sprintf("Send the %d pasted lines?", lines)
+
+		// the lines go where they were pasted even if we switched buffers before answering
+		client, buffer := s.client, s.buffer
+
+		return func() tea.Msg {
+			return cmds.Ask(question, func(yes bool) tea.Cmd {
+				if !yes {
+					return nil
+				}
+				return func() tea.Msg {
+					return pastedLinesMsg{client: client, buffer: buffer, text: fromMarkers(paste)}
+				}
+			})
+		}, true
+	}
+
+	s.insert(strings.ReplaceAll(paste, "\n", string(newlineMarker)))
+	return nil, true
+}
+
+// message is what's in the input as it's sent, new lines are only kept in messages.
+func (s InputState) message() string {
+	value := fromMarkers(s.Input.Value())
+
+	newline := "\n"
+	if s.Mode != Chatting || strings.HasPrefix(value, "/") {
+		newline = " "
+	}
+
+	return strings.ReplaceAll(value, string(newlineMarker), newline)
+}
+
+func (s *InputState) history() *inputHistory {
+	history, ok := s.histories[s.buffer]
+	if !ok {
+		history = &inputHistory{}
+		s.histories[s.buffer] = history
+	}
+
+	return history
+}
+
+// updateSearch handles a key while searching the history, typing searches and ctrl+r finds older lines.
+// Enter keeps the line found and esc goes back to what we were typing, any other key keeps the line
+// and is handled as usual so it reports whether the key was used up.
+func (s *InputState) updateSearch(msg tea.KeyMsg) bool {
+	history, search := s.history(), s.search
+
+	switch msg.String() {
+	case searchKey:
+		if search.match < 0 {
+			return true
+		}
+
+		if match := history.find(search.query, search.match); match >= 0 {
+			search.match = match
+		}
+	case "backspace":
+		query := []rune(search.query)
+		if len(query) == 0 {
+			return true
+		}
+
+		search.query = string(query[:len(query)-1])
+		search.match = history.find(search.query, len(history.lines))
+	case "esc":
+		s.search = nil
+		s.Input.SetValue(search.typed)
+		s.Input.CursorEnd()
+		return true
+	case "enter":
+		s.search = nil
+		return true
+	default:
+		if (msg.Type != tea.KeyRunes && msg.Type != tea.KeySpace) || msg.Alt {
+			s.search = nil
+			return false
+		}
+
+		search.query += string(msg.Runes)
+		search.match = history.find(search.query, len(history.lines))
+	}
+
+	// a failed search keeps showing the last line found
+	if search.match >= 0 {
+		history.pos, history.typed = search.match, search.typed
+		s.Input.SetValue(history.lines[search.match])
+		s.Input.CursorEnd()
+	}
+
+	return true
+}
+
+// SetBuffer keeps what's typed as the draft of the buffer we're leaving and brings back the one of buffer.
+// Replies and reactions are about a line of the buffer we left so they're dropped.
+func (s *InputState) SetBuffer(client *irc.Client, buffer *irc.Channel) {
+	s.client = client
+	if buffer == s.buffer {
+		return
+	}
+
+	// whatever was typed before connecting stays
+	if s.buffer == nil {
+		s.buffer = buffer
+		return
+	}
+
+	if s.Mode == Chatting && s.Input.Value() != "" {
+		s.drafts[s.buffer] = s.Input.Value()
+	}
+
+	s.buffer = buffer
+	s.search = nil
+	s.SetMode(Chatting, nil)
+
+	s.Input.SetValue(s.drafts[buffer])
+	s.Input.CursorEnd()
+	delete(s.drafts, buffer)
+}
+
+// SetMode changes what the next message is sent as, the prompt and placeholder say which it is.
+func (s *InputState) SetMode(mode InputMode, target *irc.Line) {
+	s.Mode = mode
+	s.Target = target
+
+	switch mode {
+	case Replying:
+		s.Input.Prompt = "reply> "
+		s.Input.Placeholder = "Reply to " + irc.StripFormatting(ansi.Strip(target.Content))
+	case Reacting:
+		s.Input.Prompt = "react> "
+		s.Input.Placeholder = "React to " + irc.StripFormatting(ansi.Strip(target.Content))
+	default:
+		s.Input.Prompt = defaultPrompt
+		s.Input.Placeholder = "Send a message..."
+	}
+
+	s.resizeInput()
+}
+
+// Capturing says whether the color picker or a history search is open and gets all the keys.
+func (s InputState) Capturing() bool {
+	return s.picker != nil || s.search != nil
+}
+
+// StatusLine is what's shown above the inputbox while editing, the color picker or a preview of
+// how the formatted message will look. It's empty if there's nothing to show.
+func (s InputState) StatusLine(width int) string {
+	if s.picker != nil {
+		return s.picker.View(width)
+	}
+
+	if s.search != nil {
+		label := "search: "
+		if s.search.match < 0 && s.search.query != "" {
+			label = "failing search: "
+		}
+		return ansi.Truncate(label+s.search.query, max(0, width), "…")
+	}
+
+	value := s.Input.Value()
+	if !hasMarkers(value) || strings.HasPrefix(value, "/") {
+		return ""
+	}
+
+	preview := irc.RenderFormatting(fromMarkers(value), lipgloss.NewStyle())
+	return ansi.Truncate("preview: "+preview, max(0, width), "…")
+}
+
 func (s *InputState) Focus() {
 	s.Input.Focus()
 	s.Style = s.Style.BorderForeground(ui.AccentColor)
@@ -74,12 +433,27 @@ func (s *InputState) Blur() {
 }
 
 func (s *InputState) SetSize(width int) {
-	// set a max width for the input field so it scrolls horizontally instead of wrapping to a newline
-	// -4 for input prompt char, cursor, and some extra magical padding
-	s.Input.Width = width - s.Style.GetHorizontalFrameSize() - 4
+	s.width = width
+	s.resizeInput()
 	s.Style = s.Style.Width(width - s.Style.GetHorizontalBorderSize())
 }
 
+func (s *InputState) resizeInput() {
+	if s.width == 0 {
+		return
+	}
+
+	// set a max width for the input field so it scrolls horizontally instead of wrapping to a newline
+	// -2 for the cursor and some extra magical padding
+	s.Input.Width = s.width - s.Style.GetHorizontalFrameSize() - lipgloss.Width(s.Input.Prompt) - 2
+	s.Input.Placeholder = ansi.Truncate(s.Input.Placeholder, max(0, s.Input.Width-1), "…")
+}
+
 func (s InputState) View() string {
+	if s.Question != "" {
+		question := ansi.Truncate(s.Question+" [y/n]", max(0, s.width-s.Style.GetHorizontalFrameSize()), "…")
+		return s.Style.Render(questionStyle.Render(question))
+	}
+
 	return s.Style.Render(s.Input.View())
 }
@@ -20,11 +20,12 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
-	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 	"github.com/illusionman1212/gorc/cmds"
 	"github.com/illusionman1212/gorc/irc"
 	"github.com/illusionman1212/gorc/irc/commands"
@@ -47,66 +48,248 @@ const (
 )
 
 type State struct {
-	Client     *irc.Client
-	Viewport   *viewport.Model
+	Session    *irc.Session
+	Messages   *MessagesState
 	FocusIndex Window
 	// TabRenderingDirection TabDirection
 
 	InputBox  InputState
 	SidePanel *SidePanelState
+
+	// Counts edits of the inputbox so we know if we paused typing when the timer fires
+	typingSeq int
+
+	// Questions waiting to be answered, the first one is shown in the inputbox
+	questions []cmds.AskMsg
+
+	// Whether a MARKREAD is about to be sent and if the terminal lost focus,
+	// nothing is marked read while we're looking at another window
+	markReadPending bool
+	unfocused       bool
+}
+
+// How often we tell the server how far we've read while new lines keep coming in
+const markReadInterval = 3 * time.Second
+
+// markReadMsg fires once it's time to mark the active buffer read.
+type markReadMsg struct{}
+
+// typingPausedMsg fires a while after an edit of the inputbox.
+type typingPausedMsg struct {
+	seq    int
+	client *irc.Client
+	buffer *irc.Channel
 }
 
-func NewMainScreen(client *irc.Client) State {
-	newViewport := viewport.New(0, 0)
-	newViewport.Style = MessagesStyle
+// pastedLinesMsg sends pasted lines once they're confirmed to the buffer they were pasted in.
+type pastedLinesMsg struct {
+	client *irc.Client
+	buffer *irc.Channel
+	text   string
+}
 
+func NewMainScreen(session *irc.Session) State {
 	return State{
-		Client:     client,
-		Viewport:   &newViewport,
+		Session:    session,
+		Messages:   NewMessages(),
 		FocusIndex: InputBox,
 		InputBox:   NewInputBox(),
-		SidePanel:  NewSidePanel(client),
+		SidePanel:  NewSidePanel(session),
 		// TabRenderingDirection: Right,
 	}
 }
 
+// Client returns the client of the active network.
+func (s State) Client() *irc.Client {
+	return s.Session.Active
+}
+
+// updateTyping tells the active buffer whether we're typing after the inputbox got a message,
+// and says we paused if it's left alone for a while.
+func (s *State) updateTyping(msg tea.Msg, before string) tea.Cmd {
+	value := s.InputBox.Input.Value()
+	if value == before {
+		return nil
+	}
+
+	// the message we're sending tells others we're done
+	if key, ok := msg.(tea.KeyMsg); ok && key.String() == "enter" {
+		return nil
+	}
+
+	client, buffer := s.Client(), s.Client().ActiveChannel
+
+	// nobody should see us typing commands or reactions
+	if value == "" || value[0] == '/' || s.InputBox.Mode == Reacting {
+		client.SetTyping(buffer, irc.TypingDone, time.Now())
+		return nil
+	}
+
+	client.SetTyping(buffer, irc.TypingActive, time.Now())
+
+	s.typingSeq++
+	seq := s.typingSeq
+
+	return tea.Tick(irc.TypingPauseAfter, func(time.Time) tea.Msg {
+		return typingPausedMsg{seq: seq, client: client, buffer: buffer}
+	})
+}
+
+// nextQuestion shows the first unanswered question in the inputbox, or the input again if there's none.
+func (s *State) nextQuestion() {
+	s.InputBox.Question = ""
+	if len(s.questions) > 0 {
+		s.InputBox.Question = s.questions[0].Question
+	}
+}
+
+// answer handles a key pressed while a question is shown, y or n answer it and esc puts it off.
+// Everything else is ignored so a question can't be answered by accident while typing.
+func (s *State) answer(key string) (handled bool, cmd tea.Cmd) {
+	question := s.questions[0]
+
+	switch key {
+	case "y", "Y":
+		cmd = question.Answer(true)
+	case "n", "N":
+		cmd = question.Answer(false)
+	case "esc":
+	case "tab", "shift+tab", "ctrl+c":
+		return false, nil
+	default:
+		return true, nil
+	}
+
+	s.questions = s.questions[1:]
+	s.nextQuestion()
+
+	return true, cmd
+}
+
+// showError shows an error from an action on the active buffer in that buffer.
+func (s State) showError(err error) {
+	if err != nil {
+		s.Client().ActiveChannel.AppendMsg(time.Now(), err.Error(), irc.MsgFmtOpts{WithTimestamp: true, AsErrorMsg: true})
+	}
+}
+
+// markRead moves the read marker of the active buffer once we've seen its last line.
+func (s State) markRead() {
+	if s.Messages.AtBottom() && !s.unfocused {
+		s.Client().MarkRead(s.Client().ActiveChannel)
+	}
+}
+
+// scheduleMarkRead marks the active buffer read a little later, lines that come in meanwhile
+// are marked along with it so a busy channel doesn't get a MARKREAD for every line.
+func (s *State) scheduleMarkRead() tea.Cmd {
+	if s.markReadPending {
+		return nil
+	}
+
+	s.markReadPending = true
+	return tea.Tick(markReadInterval, func(time.Time) tea.Msg {
+		return markReadMsg{}
+	})
+}
+
+// followActiveBuffer shows the active buffer if a handler or a key switched to another one,
+// the line we stopped reading at is where the read marker is drawn.
+func (s *State) followActiveBuffer() {
+	channel := s.Client().ActiveChannel
+	if s.Messages.Buffer != channel.History {
+		s.Messages.SetBuffer(channel.History)
+		s.Messages.SetReadMarker(channel.ReadMarker)
+	}
+
+	// the server tells us where we were after we join
+	if s.Messages.readMarker.IsZero() {
+		s.Messages.SetReadMarker(channel.ReadMarker)
+	}
+
+	s.InputBox.SetBuffer(s.Client(), channel)
+}
+
 func (s State) Update(msg tea.Msg) (State, tea.Cmd) {
 	var cmd tea.Cmd
 	var cmdsToProcess []tea.Cmd
 
+	// we're still connecting to the autoconnect networks
+	if s.Session.Active == nil {
+		return s, nil
+	}
+
 	switch msg := msg.(type) {
 	case cmds.ReceivedIRCMsgMsg:
-		wasAtBottom := s.Viewport.AtBottom()
-		s.Viewport.SetContent(s.Client.ActiveChannel.Value.History)
-
-		if wasAtBottom {
-			s.Viewport.GotoBottom()
-		}
+		// new lines are picked up on the next render,
+		// we only need to follow the active channel if a handler changed it.
+		s.followActiveBuffer()
+		return s, s.scheduleMarkRead()
+	case markReadMsg:
+		s.markReadPending = false
+		s.markRead()
+		return s, nil
+	case tea.FocusMsg:
+		s.unfocused = false
+		s.markRead()
+		return s, nil
+	case tea.BlurMsg:
+		s.unfocused = true
 		return s, nil
 	case cmds.SendPrivMsgMsg:
 		if msg.Msg[0] == '/' {
-			cmd = handler.HandleSlashCommand(msg.Msg, s.Client)
+			cmd = handler.HandleSlashCommand(msg.Msg, s.Client())
 			return s, cmd
 		} else {
-			if s.Client.ActiveChannel.Value.Name != s.Client.Host {
-				fullMsg := s.Client.Nickname + ": " + msg.Msg
-				msgOpts := irc.MsgFmtOpts{
-					WithTimestamp: true,
-				}
-
-				s.Client.ActiveChannel.Value.AppendMsg(msg.Datetime, fullMsg, msgOpts)
-				// TODO: make sure to only append the message to the history if server sends back no errors
-				s.Client.SendCommand(commands.PRIVMSG, s.Client.ActiveChannel.Value.Name, msg.Msg)
-				s.Viewport.SetContent(s.Client.ActiveChannel.Value.History)
-				s.Viewport.GotoBottom()
+			if s.Client().ActiveChannel != s.Client().RootChannel {
+				// the line stays pending until the server echoes it back if it supports echo-message
+				s.Client().SendPrivMsg(s.Client().ActiveChannel, msg.Msg)
+				s.Messages.GotoBottom()
 			}
 		}
 
+		return s, nil
+	case pastedLinesMsg:
+		// the buffer might have been closed while we were asked
+		if msg.buffer == msg.client.RootChannel || msg.client.Buffers.Get(msg.buffer.Name) != msg.buffer {
+			return s, nil
+		}
+
+		msg.client.SendPrivMsg(msg.buffer, msg.text)
+		if msg.buffer == s.Client().ActiveChannel {
+			s.Messages.GotoBottom()
+		}
+
+		return s, nil
+	case cmds.SendReplyMsg:
+		err := s.Client().SendReply(s.Client().ActiveChannel, msg.Parent, msg.Msg)
+		s.showError(err)
+		s.Messages.GotoBottom()
+
+		return s, nil
+	case cmds.SendReactionMsg:
+		err := s.Client().SendReaction(s.Client().ActiveChannel, msg.Parent, msg.Emoji)
+		s.showError(err)
+
+		return s, nil
+	case cmds.TypingChangedMsg:
+		// someone stopped typing, the indicator is redrawn without them
+		return s, nil
+	case cmds.AskMsg:
+		s.questions = append(s.questions, msg)
+		s.nextQuestion()
+
+		return s, nil
+	case typingPausedMsg:
+		if msg.seq == s.typingSeq {
+			msg.client.SetTyping(msg.buffer, irc.TypingPaused, time.Now())
+		}
+
 		return s, nil
 	case cmds.SwitchChannelsMsg:
-		s.Viewport.SetContent(s.Client.ActiveChannel.Value.History)
-		// log.Println(s)
-		s.Viewport.GotoBottom()
+		s.followActiveBuffer()
+		s.Messages.GotoBottom()
+		s.markRead()
 
 		*s.SidePanel, cmd = s.SidePanel.Update(msg)
 		return s, cmd
@@ -116,8 +299,26 @@ func (s State) Update(msg tea.Msg) (State, tea.Cmd) {
 		return s, nil
 	case tea.KeyMsg:
 		key := msg.String()
+
+		if len(s.questions) > 0 && s.FocusIndex == InputBox {
+			if handled, cmd := s.answer(key); handled {
+				return s, cmd
+			}
+		}
+
+		// the color picker and history search get every key until they're closed
+		if s.FocusIndex == InputBox && s.InputBox.Capturing() {
+			break
+		}
+
 		switch key {
 		case "tab", "shift+tab":
+			// tab completes in the inputbox and only moves on if there's nothing to complete
+			before := s.InputBox.Input.Value()
+			if key == "tab" && s.FocusIndex == InputBox && s.InputBox.Complete(s.Client(), handler.SlashCommands) {
+				return s, s.updateTyping(msg, before)
+			}
+
 			if key == "tab" {
 				s.FocusIndex++
 			} else {
@@ -153,15 +354,15 @@ func (s State) Update(msg tea.Msg) (State, tea.Cmd) {
 			}
 
 			// we need this so that the viewport doesnt scroll to the bottom
-			if s.Client.RootChannel.Next == s.Client.RootChannel {
+			if s.Session.BufferCount() == 1 {
 				return s, nil
 			}
 
 			if key == "right" {
 				// s.Client.ActiveChannelIndex++
-				s.Client.ActiveChannel = s.Client.ActiveChannel.Next
+				s.Session.NextBuffer()
 			} else {
-				s.Client.ActiveChannel = s.Client.ActiveChannel.Prev
+				s.Session.PrevBuffer()
 				// s.Client.ActiveChannelIndex--
 			}
 
@@ -181,45 +382,76 @@ func (s State) Update(msg tea.Msg) (State, tea.Cmd) {
 
 			// s.Client.ActiveChannel = s.Client.Channels[s.Client.ActiveChannelIndex].Name
 			return s, cmds.SwitchChannels
+		case "r", "+":
+			selected := s.Messages.Selected()
+			if s.FocusIndex != Viewport || selected == nil {
+				break
+			}
+
+			if key == "r" {
+				s.InputBox.SetMode(Replying, selected)
+			} else {
+				s.InputBox.SetMode(Reacting, selected)
+			}
+
+			s.Messages.ClearSelection()
+			s.FocusIndex = InputBox
+			s.Blur()
+			s.InputBox.Focus()
+
+			return s, textinput.Blink
 		case "g":
 			if s.FocusIndex == Viewport {
-				s.Viewport.GotoTop()
+				s.Messages.GotoTop()
 			}
 		case "G":
 			if s.FocusIndex == Viewport {
-				s.Viewport.GotoBottom()
+				s.Messages.GotoBottom()
 			}
 		}
 	}
 
 	switch s.FocusIndex {
 	case Viewport:
-		*s.Viewport, cmd = s.Viewport.Update(msg)
+		*s.Messages, cmd = s.Messages.Update(msg)
 		cmdsToProcess = append(cmdsToProcess, cmd)
+
+		// page back through the server's history once we scroll up past what we have,
+		// unless we're still waiting for the last page
+		if channel := s.Client().ActiveChannel; s.Messages.ScrolledToTop() && !channel.HistoryLoading {
+			s.Client().RequestHistoryBefore(channel)
+		}
+
+		// scrolling down to the newest line reads it
+		if s.Messages.AtBottom() {
+			cmdsToProcess = append(cmdsToProcess, s.scheduleMarkRead())
+		}
 	case InputBox:
+		before := s.InputBox.Input.Value()
 		s.InputBox, cmd = s.InputBox.Update(msg)
+		cmdsToProcess = append(cmdsToProcess, s.updateTyping(msg, before))
 		width := 0
 
 		command := strings.Split(s.InputBox.Input.Value(), " ")[0]
 		if len(command) > 0 && command[0] == '/' {
 			switch strings.ToUpper(command)[1:] {
 			case commands.AWAY:
-				w, err := strconv.ParseInt(s.Client.EnabledFeatures["AWAYLEN"], 10, 32)
+				w, err := strconv.ParseInt(s.Client().EnabledFeatures["AWAYLEN"], 10, 32)
 				if err == nil {
 					width = int(w)
 				}
 			case commands.TOPIC:
-				w, err := strconv.ParseInt(s.Client.EnabledFeatures["TOPICLEN"], 10, 32)
+				w, err := strconv.ParseInt(s.Client().EnabledFeatures["TOPICLEN"], 10, 32)
 				if err == nil {
 					width = int(w)
 				}
 			case commands.NICK:
-				w, err := strconv.ParseInt(s.Client.EnabledFeatures["NICKLEN"], 10, 32)
+				w, err := strconv.ParseInt(s.Client().EnabledFeatures["NICKLEN"], 10, 32)
 				if err == nil {
 					width = int(w)
 				}
 			case commands.KICK:
-				w, err := strconv.ParseInt(s.Client.EnabledFeatures["KICKLEN"], 10, 32)
+				w, err := strconv.ParseInt(s.Client().EnabledFeatures["KICKLEN"], 10, 32)
 				if err == nil {
 					width = int(w)
 				}
@@ -238,7 +470,7 @@ func (s State) Update(msg tea.Msg) (State, tea.Cmd) {
 }
 
 func (s *State) Focus() {
-	s.Viewport.Style = s.Viewport.Style.BorderForeground(ui.AccentColor)
+	s.Messages.Style = s.Messages.Style.BorderForeground(ui.AccentColor)
 
 	tab = tab.BorderForeground(ui.AccentColor)
 	leftArrowDim = leftArrowDim.BorderForeground(ui.AccentColor)
@@ -248,7 +480,7 @@ func (s *State) Focus() {
 	tabLine = tabLine.Foreground(ui.AccentColor)
 }
 func (s *State) Blur() {
-	s.Viewport.Style = s.Viewport.Style.BorderForeground(ui.PrimaryColor)
+	s.Messages.Style = s.Messages.Style.BorderForeground(ui.PrimaryColor)
 
 	tab = tab.BorderForeground(ui.PrimaryColor)
 	leftArrowDim = leftArrowDim.BorderForeground(ui.PrimaryColor)
@@ -259,45 +491,69 @@ func (s *State) Blur() {
 }
 
 func (s *State) SetSize(width, height int) {
+	if s.Session.Active == nil {
+		return
+	}
+
 	s.InputBox.SetSize(width)
-	s.SidePanel.SetSize(width, height, s.InputBox.Style.GetVerticalPadding())
+	// +1 for the typing indicator above the inputbox
+	s.SidePanel.SetSize(width, height, s.InputBox.Style.GetVerticalPadding()+1)
 
 	// We floor because width is an int and some fractions are lost when casting
 	// and also because we ceil the sidepanel's width
-	// -3 for the tab bar height
+	// -3 for the tab bar height and -1 for the typing indicator
 	newWidth := int(math.Floor(float64(width) * 8 / 10))
-	newHeight := height - s.InputBox.Style.GetVerticalFrameSize() - 3 - 1
+	newHeight := height - s.InputBox.Style.GetVerticalFrameSize() - 3 - 1 - 1
 
-	s.Viewport.Width = newWidth
-	s.Viewport.Height = newHeight
+	s.Messages.Width = newWidth
+	s.Messages.Height = newHeight
 
-	s.Viewport.Style = s.Viewport.Style.Width(newWidth)
-	s.Viewport.Style = s.Viewport.Style.Height(newHeight)
+	s.Messages.Style = s.Messages.Style.Width(newWidth)
+	s.Messages.Style = s.Messages.Style.Height(newHeight)
+
+	// lines are re-wrapped lazily for the new width as they become visible
+	s.Messages.SetBuffer(s.Client().ActiveChannel.History)
+}
 
-	// we need this to render an empty viewport
-	// history := ""
-	history := s.Client.ActiveChannel.Value.History
+// directTabName marks the tab of a DCC chat as direct and shows whether it's connected.
+func directTabName(chat *irc.DirectChat) string {
+	name := directMark + chat.Nick
 
-	// we need to re-set the content because words wrap differently on different sizes
-	s.Viewport.SetContent(history)
-	s.Viewport.SetYOffset(s.Viewport.YOffset)
+	switch chat.Status() {
+	case irc.ChatOffered:
+		name += " …"
+	case irc.ChatClosed:
+		name += " ✕"
+	}
+
+	return name
 }
 
 func (s State) buildTabBar(rightArrow string, leftArrow string) string {
 	var renderedTabs []string
 	tabs := ""
 
-	current := s.Client.RootChannel
-	for {
-		if current == s.Client.ActiveChannel {
-			renderedTabs = append(renderedTabs, activeTab.Render(current.Value.Name))
-		} else {
-			renderedTabs = append(renderedTabs, tab.Render(current.Value.Name))
+	for i, client := range s.Session.Networks {
+		// separate the buffers of each network
+		if i > 0 {
+			renderedTabs = append(renderedTabs, networkSeparator)
 		}
 
-		current = current.Next
-		if current == s.Client.RootChannel {
-			break
+		for _, current := range client.Buffers.All() {
+			name := current.Name
+			if current == client.RootChannel {
+				name = client.NetworkName()
+			}
+
+			if current.IsDirect() {
+				name = directTabName(current.Direct)
+			}
+
+			if client == s.Session.Active && current == client.ActiveChannel {
+				renderedTabs = append(renderedTabs, activeTab.Render(name))
+			} else {
+				renderedTabs = append(renderedTabs, tab.Render(name))
+			}
 		}
 	}
 
@@ -355,6 +611,10 @@ func (s State) buildTabBar(rightArrow string, leftArrow string) string {
 }
 
 func (s State) View() string {
+	if s.Session.Active == nil {
+		return ui.MainStyle.Render("Connecting...")
+	}
+
 	leftArrow := leftArrowDim.Render("❰")
 	rightArrow := rightArrowDim.Render("❱")
 
@@ -372,15 +632,28 @@ func (s State) View() string {
 	tabBarLine := tabLine.Render(
 		strings.Repeat(
 			"─",
-			max(0, lipgloss.Width(s.Viewport.View())-lipgloss.Width(tabs)-lipgloss.Width(rightArrow)-lipgloss.Width(leftArrow)),
+			max(0, lipgloss.Width(s.Messages.View())-lipgloss.Width(tabs)-lipgloss.Width(rightArrow)-lipgloss.Width(leftArrow)),
 		),
 	)
 	tabs = lipgloss.JoinHorizontal(lipgloss.Bottom, leftArrow, tabs, tabBarLine, rightArrow)
 	tabBar.WriteString(tabs)
 
-	leftSide := lipgloss.JoinVertical(0, tabBar.String(), s.Viewport.View())
+	leftSide := lipgloss.JoinVertical(0, tabBar.String(), s.Messages.View())
 	top := lipgloss.JoinHorizontal(lipgloss.Right, leftSide, s.SidePanel.View())
-	screen := lipgloss.JoinVertical(0, top, s.InputBox.View())
+
+	width := lipgloss.Width(top)
+	lineWidth := max(0, width-typingStyle.GetHorizontalPadding())
+
+	// what we're writing is more interesting than who else is
+	typing := s.InputBox.StatusLine(lineWidth)
+	if typing == "" {
+		typing = s.Client().ActiveChannel.Typing.Summary(time.Now())
+		typing = typingStyle.Width(width).Render(ansi.Truncate(typing, lineWidth, "…"))
+	} else {
+		typing = typingStyle.UnsetItalic().UnsetForeground().Width(width).Render(typing)
+	}
+
+	screen := lipgloss.JoinVertical(0, top, typing, s.InputBox.View())
 
 	return ui.MainStyle.Render(screen)
 }
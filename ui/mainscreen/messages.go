@@ -0,0 +1,392 @@
+// gorc project
+// Copyright (C) 2022 IllusionMan1212
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see https://www.gnu.org/licenses.
+
+package mainscreen
+
+import (
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/illusionman1212/gorc/irc"
+)
+
+// Number of lines above the visible window that are wrapped ahead of time
+// so scrolling up doesn't have to wrap them on the spot.
+const renderMargin = 10
+
+// MessagesState renders a buffer's scrollback.
+// Unlike a regular viewport it never holds the whole history as one string,
+// it only asks the scrollback for the rows that are currently visible.
+type MessagesState struct {
+	Buffer *irc.Scrollback
+	Width  int
+	Height int
+	Style  lipgloss.Style
+	KeyMap viewport.KeyMap
+
+	MouseWheelDelta int
+
+	// Whether the view sticks to the newest line
+	following bool
+
+	// The first visible line when not following,
+	// and how many of its rows are scrolled past.
+	top    *irc.Line
+	topRow int
+
+	// The line picked to reply or react to, nil if there's none
+	selected *irc.Line
+
+	// Where we had stopped reading when the buffer was opened,
+	// a marker is drawn above the first line after it.
+	readMarker time.Time
+
+	// Whether the last update scrolled up onto the top, that's when older history is fetched
+	scrolledToTop bool
+}
+
+func NewMessages() *MessagesState {
+	return &MessagesState{
+		Style:           MessagesStyle,
+		KeyMap:          viewport.DefaultKeyMap(),
+		MouseWheelDelta: 3,
+		following:       true,
+	}
+}
+
+func (s *MessagesState) contentSize() (int, int) {
+	w, h := s.Width, s.Height
+	if sw := s.Style.GetWidth(); sw != 0 {
+		w = min(w, sw)
+	}
+	if sh := s.Style.GetHeight(); sh != 0 {
+		h = min(h, sh)
+	}
+
+	return max(0, w-s.Style.GetHorizontalFrameSize()), max(0, h-s.Style.GetVerticalFrameSize())
+}
+
+func (s *MessagesState) rows(i int) []string {
+	width, _ := s.contentSize()
+	rows := s.Buffer.Rows(i, max(1, width))
+
+	if s.unreadStartsAt(i) {
+		return append([]string{readMarkerRow(max(1, width))}, rows...)
+	}
+
+	return rows
+}
+
+// unreadStartsAt reports whether line i is the first one we haven't read.
+func (s *MessagesState) unreadStartsAt(i int) bool {
+	if s.readMarker.IsZero() || i == 0 {
+		return false
+	}
+
+	line, prev := s.Buffer.At(i), s.Buffer.At(i-1)
+	return line != nil && prev != nil && line.DateTime.After(s.readMarker) && !prev.DateTime.After(s.readMarker)
+}
+
+func readMarkerRow(width int) string {
+	label := " new messages "
+	side := max(0, width-lipgloss.Width(label)) / 2
+
+	return readMarkerStyle.Render(ansi.Truncate(strings.Repeat("─", side)+label+strings.Repeat("─", side), width, ""))
+}
+
+// bottomAnchor returns the position of the first visible row
+// when the view is scrolled all the way down.
+func (s *MessagesState) bottomAnchor() (int, int) {
+	_, height := s.contentSize()
+	idx := s.Buffer.Len()
+	row := 0
+	remaining := height
+
+	for remaining > 0 && idx > 0 {
+		idx--
+		rows := len(s.rows(idx))
+		remaining -= rows
+		row = max(0, -remaining)
+	}
+
+	return idx, row
+}
+
+func (s *MessagesState) anchor() (int, int) {
+	if s.following || s.top == nil {
+		return s.bottomAnchor()
+	}
+
+	idx := s.Buffer.IndexOf(s.top)
+	if idx == -1 {
+		// the line we were looking at was evicted
+		return 0, 0
+	}
+
+	return idx, s.topRow
+}
+
+func (s *MessagesState) setAnchor(idx, row int) {
+	bottomIdx, bottomRow := s.bottomAnchor()
+	if idx > bottomIdx || (idx == bottomIdx && row >= bottomRow) {
+		s.GotoBottom()
+		return
+	}
+
+	s.following = false
+	s.top = s.Buffer.At(idx)
+	s.topRow = row
+}
+
+// SetBuffer switches the scrollback being displayed, the view starts at the bottom.
+func (s *MessagesState) SetBuffer(buffer *irc.Scrollback) {
+	if s.Buffer == buffer {
+		return
+	}
+
+	s.Buffer = buffer
+	s.selected = nil
+	s.GotoBottom()
+}
+
+// SetReadMarker sets where the read marker is drawn, the zero time hides it.
+func (s *MessagesState) SetReadMarker(marker time.Time) {
+	s.readMarker = marker
+}
+
+// Selected returns the selected line if it's still in the scrollback.
+func (s *MessagesState) Selected() *irc.Line {
+	if s.selected == nil || s.Buffer.IndexOf(s.selected) == -1 {
+		return nil
+	}
+
+	return s.selected
+}
+
+func (s *MessagesState) ClearSelection() {
+	s.selected = nil
+}
+
+// MoveSelection moves the selection by n lines, a new selection starts at the newest line.
+func (s *MessagesState) MoveSelection(n int) {
+	if s.Buffer == nil || s.Buffer.Len() == 0 {
+		return
+	}
+
+	idx := s.Buffer.Len() - 1
+	if selected := s.Selected(); selected != nil {
+		idx = min(max(s.Buffer.IndexOf(selected)+n, 0), s.Buffer.Len()-1)
+	}
+
+	s.selected = s.Buffer.At(idx)
+	s.scrollTo(idx)
+}
+
+// scrollTo scrolls just enough for the whole line at idx to be visible.
+func (s *MessagesState) scrollTo(idx int) {
+	top, row := s.anchor()
+	if idx < top || (idx == top && row > 0) {
+		s.setAnchor(idx, 0)
+		return
+	}
+
+	_, height := s.contentSize()
+	used := -row
+	for i := top; i <= idx; i++ {
+		used += len(s.rows(i))
+	}
+
+	if used > height {
+		s.ScrollDown(used - height)
+	}
+}
+
+func (s *MessagesState) AtBottom() bool {
+	if s.following {
+		return true
+	}
+
+	idx, row := s.anchor()
+	bottomIdx, bottomRow := s.bottomAnchor()
+
+	return idx > bottomIdx || (idx == bottomIdx && row >= bottomRow)
+}
+
+func (s *MessagesState) AtTop() bool {
+	idx, row := s.anchor()
+	return idx == 0 && row == 0
+}
+
+func (s *MessagesState) GotoTop() {
+	s.setAnchor(0, 0)
+}
+
+// ScrolledToTop reports whether the last update scrolled up onto the oldest line.
+func (s *MessagesState) ScrolledToTop() bool {
+	return s.scrolledToTop
+}
+
+func (s *MessagesState) GotoBottom() {
+	s.following = true
+	s.top = nil
+	s.topRow = 0
+}
+
+func (s *MessagesState) ScrollUp(n int) {
+	if s.Buffer == nil {
+		return
+	}
+
+	idx, row := s.anchor()
+	row -= n
+	for row < 0 && idx > 0 {
+		idx--
+		row += len(s.rows(idx))
+	}
+
+	s.setAnchor(idx, max(0, row))
+}
+
+func (s *MessagesState) ScrollDown(n int) {
+	if s.Buffer == nil || s.following {
+		return
+	}
+
+	idx, row := s.anchor()
+	row += n
+	for idx < s.Buffer.Len() && row >= len(s.rows(idx)) {
+		row -= len(s.rows(idx))
+		idx++
+	}
+
+	s.setAnchor(idx, row)
+}
+
+func (s MessagesState) Update(msg tea.Msg) (MessagesState, tea.Cmd) {
+	_, height := s.contentSize()
+	s.scrolledToTop = false
+	scrolledUp := false
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		// with a line selected up and down move the selection instead of scrolling
+		selecting := s.Selected() != nil
+
+		switch {
+		case msg.String() == "v":
+			if selecting {
+				s.ClearSelection()
+			} else {
+				s.MoveSelection(0)
+			}
+		case msg.String() == "esc":
+			s.ClearSelection()
+		case selecting && key.Matches(msg, s.KeyMap.Down):
+			s.MoveSelection(1)
+		case selecting && key.Matches(msg, s.KeyMap.Up):
+			s.MoveSelection(-1)
+			scrolledUp = true
+		case key.Matches(msg, s.KeyMap.PageDown):
+			s.ScrollDown(height)
+		case key.Matches(msg, s.KeyMap.PageUp):
+			s.ScrollUp(height)
+			scrolledUp = true
+		case key.Matches(msg, s.KeyMap.HalfPageDown):
+			s.ScrollDown(height / 2)
+		case key.Matches(msg, s.KeyMap.HalfPageUp):
+			s.ScrollUp(height / 2)
+			scrolledUp = true
+		case key.Matches(msg, s.KeyMap.Down):
+			s.ScrollDown(1)
+		case key.Matches(msg, s.KeyMap.Up):
+			s.ScrollUp(1)
+			scrolledUp = true
+		}
+	case tea.MouseMsg:
+		if msg.Action != tea.MouseActionPress {
+			break
+		}
+
+		switch msg.Button {
+		case tea.MouseButtonWheelUp:
+			s.ScrollUp(s.MouseWheelDelta)
+			scrolledUp = true
+		case tea.MouseButtonWheelDown:
+			s.ScrollDown(s.MouseWheelDelta)
+		}
+	}
+
+	s.scrolledToTop = scrolledUp && s.AtTop()
+
+	return s, nil
+}
+
+func (s *MessagesState) visibleRows() []string {
+	if s.Buffer == nil {
+		return nil
+	}
+
+	_, height := s.contentSize()
+	idx, row := s.anchor()
+	visible := make([]string, 0, height)
+	selected := -1
+	if s.selected != nil {
+		selected = s.Buffer.IndexOf(s.selected)
+	}
+
+	for i := idx; i < s.Buffer.Len() && len(visible) < height; i++ {
+		rows := s.rows(i)
+		if i == idx {
+			rows = rows[min(row, len(rows)):]
+		}
+
+		if i == selected {
+			highlighted := make([]string, len(rows))
+			for j, r := range rows {
+				highlighted[j] = selectedLineStyle.Render(ansi.Strip(r))
+			}
+			rows = highlighted
+		}
+
+		visible = append(visible, rows[:min(len(rows), height-len(visible))]...)
+	}
+
+	for i := idx - 1; i >= 0 && i >= idx-renderMargin; i-- {
+		s.rows(i)
+	}
+
+	return visible
+}
+
+func (s MessagesState) View() string {
+	width, height := s.contentSize()
+
+	contents := lipgloss.NewStyle().
+		Width(width).
+		Height(height).
+		MaxHeight(height).
+		MaxWidth(width).
+		Render(strings.Join(s.visibleRows(), "\n"))
+
+	return s.Style.
+		UnsetWidth().UnsetHeight().
+		Render(contents)
+}
@@ -32,20 +32,63 @@ import (
 )
 
 type SidePanelState struct {
-	Client   *irc.Client
+	Session  *irc.Session
 	Viewport viewport.Model
 	Focused  bool
+
+	// Whether the network's friends are listed instead of the channel's users
+	ShowFriends bool
 }
 
 func (s *SidePanelState) getHeader() string {
-	usersCount := 0
-	usersCount = len(s.Client.ActiveChannel.Value.Users)
 	separator := strings.Repeat("—", s.Viewport.Width-s.Viewport.Style.GetHorizontalFrameSize()) + "\n"
+
+	if s.ShowFriends {
+		online := 0
+		for _, friend := range s.Session.Active.Friends() {
+			if friend.Online {
+				online++
+			}
+		}
+
+		return fmt.Sprintf("%d Friends online\n", online) + separator
+	}
+
+	usersCount := 0
+	usersCount = len(s.Session.Active.ActiveChannel.Users)
 	header := fmt.Sprintf("%d Users\n", usersCount) + separator
 
 	return header
 }
 
+// getFriends lists online friends first, then offline ones dimmed.
+func (s *SidePanelState) getFriends() []string {
+	client := s.Session.Active
+	var friends []string
+
+	for _, friend := range client.Friends() {
+		if !friend.Online {
+			friends = append(friends, awayNickStyle.Render("○ "+friend.Nick))
+			continue
+		}
+
+		nick := "● " + friend.Nick
+		info := client.Users.Get(friend.Nick)
+
+		if info != nil && info.Away {
+			nick = awayNickStyle.Render(nick)
+		}
+
+		if info != nil && info.Account != "" {
+			nick += accountMark
+		}
+
+		friends = append(friends, nick)
+	}
+
+	return friends
+}
+
 // lessCaseInsensitive compares s, t without allocating
 func lessCaseInsensitive(s, t string) bool {
 	for {
@@ -74,23 +117,43 @@ func lessCaseInsensitive(s, t string) bool {
 }
 
 func (s *SidePanelState) getLatestNicks() []string {
+	client := s.Session.Active
 	nicks := make([]string, 0)
 
-	for nick, user := range s.Client.ActiveChannel.Value.Users {
+	for nick, user := range client.ActiveChannel.Users {
 		_nick := user.Prefix + nick
 		nicks = append(nicks, _nick)
 	}
 
 	sort.Slice(nicks, func(i, j int) bool { return lessCaseInsensitive(nicks[i], nicks[j]) })
+
+	// away users are dimmed and users logged in to an account are marked
+	for i, nick := range nicks {
+		info := client.Users.Get(strings.TrimLeft(nick, "~&@%+"))
+		if info == nil {
+			continue
+		}
+
+		if info.Away {
+			nick = awayNickStyle.Render(nick)
+		}
+
+		if info.Account != "" {
+			nick += accountMark
+		}
+
+		nicks[i] = nick
+	}
+
 	return nicks
 }
 
-func NewSidePanel(client *irc.Client) *SidePanelState {
+func NewSidePanel(session *irc.Session) *SidePanelState {
 	newViewport := viewport.New(0, 0)
 	newViewport.Style = SidePanelStyle
 
 	return &SidePanelState{
-		Client:   client,
+		Session:  session,
 		Viewport: newViewport,
 	}
 }
@@ -114,6 +177,12 @@ func (s SidePanelState) Update(msg tea.Msg) (SidePanelState, tea.Cmd) {
 			if s.Focused {
 				s.Viewport.GotoBottom()
 			}
+		case "F":
+			if s.Focused {
+				s.ShowFriends = !s.ShowFriends
+				s.UpdateNicks()
+				s.Viewport.GotoTop()
+			}
 		}
 	}
 
@@ -137,7 +206,13 @@ func (s *SidePanelState) Blur() {
 
 func (s *SidePanelState) UpdateNicks() {
 	header := s.getHeader()
-	nicks := s.getLatestNicks()
+
+	var nicks []string
+	if s.ShowFriends {
+		nicks = s.getFriends()
+	} else {
+		nicks = s.getLatestNicks()
+	}
 
 	s.Viewport.SetContent(header + strings.Join(nicks, "\n"))
 }
@@ -108,6 +108,33 @@ var (
 			Border(rightArrowBorder, true).
 			Foreground(ui.PrimaryColor)
 
+	selectedLineStyle = lipgloss.NewStyle().
+				Reverse(true)
+
+	awayNickStyle = lipgloss.NewStyle().
+			Foreground(ui.DisabledColor)
+	accountMark = lipgloss.NewStyle().
+			Foreground(ui.AccentColor).
+			Render(" ✓")
+
+	typingStyle = lipgloss.NewStyle().
+			Foreground(ui.DisabledColorFocus).
+			Italic(true).
+			PaddingLeft(1)
+
+	readMarkerStyle = lipgloss.NewStyle().
+			Foreground(ui.AccentColor)
+
+	questionStyle = lipgloss.NewStyle().
+			Foreground(ui.AccentColor).
+			Bold(true)
+
 	tabLine = lipgloss.NewStyle().
 		Foreground(ui.PrimaryColor)
+
+	// Marks the tabs of DCC chats, which don't go through the server
+	directMark = "⇄ "
+
+	// Bottom-aligned gap between the tabs of two networks
+	networkSeparator = tabLine.Render("  \n  \n══")
 )